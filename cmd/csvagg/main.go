@@ -1,19 +1,111 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log/slog"
 	"os"
+	"os/signal"
+	"runtime/debug"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/khanhduong95/ad-performance-aggregator/internal/aggregator"
 )
 
+// config holds the parsed command-line options.
+type config struct {
+	inputs       []string
+	output       string
+	topK         int
+	workers      int
+	groupBy      []string
+	columnMap    map[string]string
+	dialect      []aggregator.CSVOption
+	numbers      aggregator.NumberFormat
+	validation   aggregator.Validation
+	format       aggregator.Format
+	policy       aggregator.ErrorPolicy
+	rejects      string
+	minimum      aggregator.Eligibility
+	eligComments bool
+	rankBy       aggregator.RankMode
+	confidence   float64
+	moneyDigits  int
+	reports      []string
+	columns      []string
+	metrics      []aggregator.CustomMetric
+	ranks        []aggregator.CustomRank
+	reportCfg    *aggregator.ReportConfig
+	flags        map[string]string
+	progress     aggregator.ProgressReporter
+	progressInt  time.Duration
+}
+
+// version is the csvagg version recorded in the run manifest. Release
+// builds set it with -ldflags "-X main.version=v1.2.3"; otherwise it
+// comes from the module build info.
+var version string
+
+// exitInterrupted is the exit status of a run cancelled by SIGINT or
+// SIGTERM, 128 + SIGINT as shells report it.
+const exitInterrupted = 130
+
+// stdoutName is the --output value that writes reports to stdout.
+const stdoutName = "-"
+
+// stringList is a flag.Value collecting every occurrence of a
+// repeatable flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
 func main() {
-	input := flag.String("input", "", "path to input CSV file (required)")
-	output := flag.String("output", "", "path to output directory (required)")
+	var inputs stringList
+	flag.Var(&inputs, "input", "input CSV file, directory or glob, or - for stdin; repeatable (required)")
+	output := flag.String("output", "", "path to output directory, or - for stdout (required)")
 	topK := flag.Int("topk", 10, "number of top campaigns to include in reports (default: 10)")
+	groupBy := flag.String("group-by", "campaign_id", "comma-separated columns to aggregate by (default: campaign_id)")
+	columnMap := flag.String("column-map", "", "comma-separated input=column renames, e.g. cmp_id=campaign_id,impr=impressions,cost=spend,conv=conversions")
+	delimiter := flag.String("delimiter", ",", "input field delimiter: a character, or comma, tab, semicolon or pipe (default: comma)")
+	comment := flag.String("comment", "", "skip input lines starting with this character, e.g. #")
+	lazyQuotes := flag.Bool("lazy-quotes", false, "accept stray quotes in input fields instead of rejecting the row")
+	noHeader := flag.Bool("no-header", false, "inputs have no header row; fields follow --input-columns")
+	inputColumns := flag.String("input-columns", "", "with --no-header, comma-separated column names of the input fields in order (default: group-by columns, then impressions,clicks,spend,conversions)")
+	numberFormat := flag.String("number-format", "plain", "how spend and revenue are written: plain, us ($1,234.56) or eu (1.234,56 €), optionally followed by overrides such as 'eu currency=off' (default: plain)")
+	moneyPrecision := flag.Int("money-precision", 2, "decimal places of total_spend and total_revenue in CSV reports, 0 to 6 (default: 2)")
+	validate := flag.String("validate", "", "comma-separated rule=severity overrides for row validation, severity reject, warn, clamp or off, e.g. negative_spend=reject; rule all sets every rule")
+	format := flag.String("format", "csv", "report format: csv, json, jsonl or parquet (default: csv)")
+	workers := flag.Int("workers", 1, "number of parallel parse workers (default: 1)")
+	onError := flag.String("on-error", "fail", "what to do with bad rows: skip or fail (default: fail)")
+	rejects := flag.String("rejects", "", "path to write rejected rows to as CSV")
+	maxErrors := flag.String("max-errors", "", "with --on-error=skip, fail once more than N rows (or P% of rows) are rejected")
+	var minimum aggregator.Eligibility
+	flag.Int64Var(&minimum.MinImpressions, "min-impressions", 0, "only rank campaigns with at least this many impressions")
+	flag.Int64Var(&minimum.MinClicks, "min-clicks", 0, "only rank campaigns with at least this many clicks")
+	flag.Int64Var(&minimum.MinConversions, "min-conversions", 0, "only rank campaigns with at least this many conversions")
+	flag.Var(&minimum.MinSpend, "min-spend", "only rank campaigns with at least this much spend")
+	eligComments := flag.Bool("eligibility-comments", false, "start CSV reports with a \"# eligibility: ...\" comment line recording the thresholds")
+	rankBy := flag.String("rank-by", "raw", "rank by raw, smoothed (empirical-Bayes) or lower (Wilson lower bound) CTR and CPA (default: raw)")
+	confidence := flag.String("confidence", "", "add Wilson interval columns for CTR and CVR at this level, e.g. 0.95 or 95%")
+	reports := flag.String("reports", "ctr,cpa", "comma-separated metrics to write a top-K report for: ctr, cpa, cpc, cpm, cvr, roas or profit (default: ctr,cpa)")
+	columns := flag.String("columns", "", "comma-separated extra columns for every report: cpc, cpm, cvr, roas, profit or revenue")
+	var metrics, ranks stringList
+	flag.Var(&metrics, "metric", "custom metric column as name=expression over impressions, clicks, spend, conversions and revenue, e.g. 'eCPC=spend/clicks'; repeatable")
+	flag.Var(&ranks, "rank", "top-K report ranked by a custom metric, as 'name asc' or 'name desc'; repeatable")
+	configPath := flag.String("config", "", "JSON or YAML file declaring the reports to write; replaces --reports, --metric and --rank")
+	progress := flag.String("progress", "auto", "progress reporting: tty (an updating line on stderr), log (periodic log records), off, or auto for tty when stderr is a terminal and log otherwise (default: auto)")
+	progressInterval := flag.Duration("progress-interval", 0, "time between progress reports (default: 1s for tty, 10s for log)")
 	benchmark := flag.Bool("benchmark", false, "enable benchmark timing logs on stderr")
 	flag.Parse()
 
@@ -21,38 +113,346 @@ func main() {
 		slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})))
 	}
 
-	if *input == "" || *output == "" {
-		fmt.Fprintln(os.Stderr, "usage: csvagg --input <csv_path> --output <output_dir> [--topk <number>] [--benchmark]")
+	if len(inputs) == 0 || *output == "" {
+		fmt.Fprintln(os.Stderr, "usage: csvagg --input <csv_path>... --output <output_dir> [--topk <number>] [--group-by <columns>] [--column-map <input=column,...>] [--delimiter <char>] [--comment <char>] [--lazy-quotes] [--no-header [--input-columns <columns>]] [--number-format <format>] [--validate <rule=severity,...>] [--format csv|json|jsonl|parquet] [--money-precision <digits>] [--workers <number>] [--on-error skip|fail] [--rejects <path>] [--max-errors N|P%] [--min-impressions N] [--min-clicks N] [--min-conversions N] [--min-spend X] [--eligibility-comments] [--rank-by raw|smoothed|lower] [--confidence L] [--reports <metrics>] [--columns <metrics>] [--metric name=expr]... [--rank 'name asc|desc']... [--config <path>] [--progress auto|tty|log|off] [--progress-interval <duration>] [--benchmark]")
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
 
-	if err := run(*input, *output, *topK); err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
+	cfg := config{
+		output:       *output,
+		topK:         *topK,
+		workers:      *workers,
+		rejects:      *rejects,
+		minimum:      minimum,
+		eligComments: *eligComments,
+		moneyDigits:  *moneyPrecision,
+		flags:        setFlags(),
+	}
+	if cfg.moneyDigits < 0 || cfg.moneyDigits > aggregator.MoneyScale {
+		fatal(fmt.Errorf("invalid money precision %d; want 0 to %d", cfg.moneyDigits, aggregator.MoneyScale))
+	}
+	if err := cfg.minimum.Validate(); err != nil {
+		fatal(err)
+	}
+	var err error
+	if cfg.groupBy, err = aggregator.ParseGroupBy(*groupBy); err != nil {
+		fatal(err)
+	}
+	if *columnMap != "" {
+		if cfg.columnMap, err = aggregator.ParseColumnMap(*columnMap, cfg.groupBy); err != nil {
+			fatal(err)
+		}
+	}
+	if cfg.dialect, err = inputDialect(*delimiter, *comment, *lazyQuotes, *noHeader, *inputColumns, cfg.groupBy); err != nil {
+		fatal(err)
+	}
+	if cfg.numbers, err = aggregator.ParseNumberFormat(*numberFormat); err != nil {
+		fatal(err)
+	}
+	if *validate != "" {
+		if cfg.validation, err = aggregator.ParseValidation(*validate); err != nil {
+			fatal(err)
+		}
+	}
+	if cfg.format, err = aggregator.ParseFormat(*format); err != nil {
+		fatal(err)
+	}
+	if cfg.policy, err = errorPolicy(*onError, *maxErrors); err != nil {
+		fatal(err)
+	}
+	if cfg.rankBy, err = aggregator.ParseRankMode(*rankBy); err != nil {
+		fatal(err)
+	}
+	if *confidence != "" {
+		if cfg.confidence, err = aggregator.ParseConfidence(*confidence); err != nil {
+			fatal(err)
+		}
+	}
+	if cfg.reports, err = aggregator.ParseReports(*reports); err != nil {
+		fatal(err)
+	}
+	if *columns != "" {
+		if cfg.columns, err = aggregator.ParseColumns(*columns); err != nil {
+			fatal(err)
+		}
+	}
+	for _, s := range metrics {
+		m, err := aggregator.ParseMetric(s)
+		if err != nil {
+			fatal(err)
+		}
+		cfg.metrics = append(cfg.metrics, m)
+	}
+	for _, s := range ranks {
+		r, err := aggregator.ParseRank(s)
+		if err != nil {
+			fatal(err)
+		}
+		cfg.ranks = append(cfg.ranks, r)
+	}
+	if err := aggregator.ValidateCustomMetrics(cfg.metrics, cfg.ranks, cfg.groupBy); err != nil {
+		fatal(err)
+	}
+	if *configPath != "" {
+		if cfg.reportCfg, err = reportConfig(*configPath, cfg); err != nil {
+			fatal(err)
+		}
+	}
+	if cfg.inputs, err = aggregator.ExpandInputs(inputs); err != nil {
+		fatal(err)
+	}
+	if cfg.progress, cfg.progressInt, err = progressReporter(*progress, *progressInterval); err != nil {
+		fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		// Restore the default handling once the first signal has
+		// cancelled the run, so a second one kills it outright.
+		<-ctx.Done()
+		stop()
+	}()
+	if err := run(ctx, cfg); err != nil {
+		if errors.Is(err, context.Canceled) {
+			fmt.Fprintln(os.Stderr, "interrupted: no reports written")
+			os.Exit(exitInterrupted)
+		}
+		fatal(err)
+	}
+	stop()
+}
+
+// progressReporter resolves the --progress mode and its interval. It
+// returns a nil reporter for off.
+func progressReporter(mode string, interval time.Duration) (aggregator.ProgressReporter, time.Duration, error) {
+	if interval < 0 {
+		return nil, 0, fmt.Errorf("invalid progress interval %s; want a positive duration", interval)
+	}
+	if mode == "auto" {
+		mode = "log"
+		if info, err := os.Stderr.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+			mode = "tty"
+		}
+	}
+	switch mode {
+	case "tty":
+		if interval == 0 {
+			interval = time.Second
+		}
+		return aggregator.NewTerminalProgress(os.Stderr), interval, nil
+	case "log":
+		if interval == 0 {
+			interval = 10 * time.Second
+		}
+		return aggregator.NewLogProgress(slog.Default()), interval, nil
+	case "off":
+		return nil, 0, nil
+	}
+	return nil, 0, fmt.Errorf("invalid progress mode %q; want auto, tty, log or off", mode)
+}
+
+// setFlags returns the flags given on the command line with their
+// values; repeated flags are joined with commas.
+func setFlags() map[string]string {
+	flags := make(map[string]string)
+	flag.Visit(func(f *flag.Flag) {
+		flags[f.Name] = f.Value.String()
+	})
+	return flags
+}
+
+// buildVersion returns version, or else the module version of the
+// binary, or its VCS revision for a development build.
+func buildVersion() string {
+	if version != "" {
+		return version
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
 	}
+	if info.Main.Version == "(devel)" {
+		for _, s := range info.Settings {
+			if s.Key == "vcs.revision" {
+				return "(devel) " + s.Value
+			}
+		}
+	}
+	return info.Main.Version
+}
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "error: %v\n", err)
+	os.Exit(1)
 }
 
-func run(input, output string, topK int) error {
-	f, err := os.Open(input)
+// inputDialect turns the input format flags into processor options.
+func inputDialect(delimiter, comment string, lazyQuotes, noHeader bool, inputColumns string, groupBy []string) ([]aggregator.CSVOption, error) {
+	comma, err := aggregator.ParseDelimiter(delimiter)
 	if err != nil {
-		return fmt.Errorf("open input: %w", err)
+		return nil, err
+	}
+	opts := []aggregator.CSVOption{aggregator.WithDelimiter(comma)}
+	if comment != "" {
+		c, err := aggregator.ParseComment(comment)
+		if err != nil {
+			return nil, err
+		}
+		if c == comma {
+			return nil, fmt.Errorf("--comment and --delimiter must differ")
+		}
+		opts = append(opts, aggregator.WithComment(c))
+	}
+	if lazyQuotes {
+		opts = append(opts, aggregator.WithLazyQuotes())
+	}
+	switch {
+	case noHeader && inputColumns == "":
+		opts = append(opts, aggregator.WithoutHeader(aggregator.DefaultInputColumns(groupBy)))
+	case noHeader:
+		columns := strings.Split(inputColumns, ",")
+		for i := range columns {
+			columns[i] = strings.TrimSpace(columns[i])
+		}
+		opts = append(opts, aggregator.WithoutHeader(columns))
+	case inputColumns != "":
+		return nil, fmt.Errorf("--input-columns requires --no-header")
+	}
+	return opts, nil
+}
+
+// reportConfig loads and validates the --config file before any input
+// is read.
+func reportConfig(path string, cfg config) (*aggregator.ReportConfig, error) {
+	reportsSet := false
+	flag.Visit(func(f *flag.Flag) {
+		reportsSet = reportsSet || f.Name == "reports"
+	})
+	if reportsSet || len(cfg.metrics) > 0 || len(cfg.ranks) > 0 {
+		return nil, fmt.Errorf("--config cannot be combined with --reports, --metric or --rank")
+	}
+	rc, err := aggregator.LoadReportConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := rc.Validate(cfg.groupBy); err != nil {
+		return nil, err
+	}
+	check := rc.CheckFiles
+	if cfg.output == stdoutName {
+		check = rc.CheckStream
+	}
+	if err := check(cfg.format); err != nil {
+		return nil, err
+	}
+	return rc, nil
+}
+
+func errorPolicy(onError, maxErrors string) (aggregator.ErrorPolicy, error) {
+	mode, err := aggregator.ParseErrorMode(onError)
+	if err != nil {
+		return aggregator.ErrorPolicy{}, err
+	}
+	policy := aggregator.ErrorPolicy{Mode: mode}
+	if maxErrors != "" {
+		policy.MaxErrors, policy.MaxErrorRatio, err = aggregator.ParseErrorBudget(maxErrors)
+		if err != nil {
+			return aggregator.ErrorPolicy{}, err
+		}
+	}
+	return policy, nil
+}
+
+func run(ctx context.Context, cfg config) error {
+	inputs := make([]aggregator.Input, len(cfg.inputs))
+	for i, path := range cfg.inputs {
+		if path == aggregator.StdinName {
+			inputs[i] = aggregator.StdinInput()
+		} else {
+			inputs[i] = aggregator.FileInput(path)
+		}
+	}
+
+	opts := []aggregator.CSVOption{
+		aggregator.WithGroupBy(cfg.groupBy),
+		aggregator.WithColumnMap(cfg.columnMap),
+		aggregator.WithWorkers(cfg.workers),
+		aggregator.WithNumberFormat(cfg.numbers),
+		aggregator.WithValidation(cfg.validation),
+		aggregator.WithErrorPolicy(cfg.policy),
+	}
+	opts = append(opts, cfg.dialect...)
+	if cfg.progress != nil {
+		opts = append(opts, aggregator.WithProgress(cfg.progress, cfg.progressInt))
+	}
+	if cfg.rejects != "" {
+		rf, err := os.Create(cfg.rejects)
+		if err != nil {
+			return fmt.Errorf("create rejects file: %w", err)
+		}
+		defer rf.Close()
+		opts = append(opts, aggregator.WithRejects(aggregator.NewCSVRejectWriter(rf)))
 	}
-	defer f.Close()
 
 	start := time.Now()
-	fmt.Fprintf(os.Stderr, "processing %s ...\n", input)
+	fmt.Fprintf(os.Stderr, "processing %s ...\n", strings.Join(cfg.inputs, ", "))
+
+	reportOpts := []aggregator.ReportOption{
+		aggregator.WithKeyColumns(cfg.groupBy),
+		aggregator.WithFormat(cfg.format),
+		aggregator.WithEligibility(cfg.minimum),
+		aggregator.WithRankBy(cfg.rankBy),
+		aggregator.WithConfidence(cfg.confidence),
+		aggregator.WithMoneyPrecision(cfg.moneyDigits),
+		aggregator.WithReports(cfg.reports),
+		aggregator.WithColumns(cfg.columns),
+		aggregator.WithMetrics(cfg.metrics),
+		aggregator.WithRanks(cfg.ranks),
+		aggregator.WithProvenance(buildVersion(), cfg.flags),
+	}
+	if cfg.reportCfg != nil {
+		reportOpts = append(reportOpts, aggregator.WithReportConfig(cfg.reportCfg))
+	}
+	if cfg.eligComments {
+		reportOpts = append(reportOpts, aggregator.WithEligibilityComments())
+	}
+	if !cfg.minimum.IsZero() {
+		fmt.Fprintf(os.Stderr, "eligibility: %s\n", cfg.minimum)
+	}
+	var writer aggregator.ReportWriter
+	if cfg.output == stdoutName {
+		writer = aggregator.NewStreamReportWriter(os.Stdout, cfg.topK, reportOpts...)
+	} else {
+		writer = aggregator.NewFileReportWriter(cfg.output, cfg.topK, reportOpts...)
+	}
 
-	svc := aggregator.NewService(
-		aggregator.NewCSVProcessor(),
-		aggregator.NewFileReportWriter(output, topK),
-	)
+	svc := aggregator.NewService(aggregator.NewCSVProcessor(opts...), writer)
 
-	if err := svc.Run(f); err != nil {
+	stats, err := svc.RunInputs(ctx, inputs)
+	fmt.Fprintf(os.Stderr, "rows: %d accepted, %d rejected\n", stats.RowsAccepted, stats.RowsRejected)
+	if summary := violationSummary(stats, cfg.validation); summary != "" {
+		fmt.Fprintf(os.Stderr, "validation: %s\n", summary)
+	}
+	if err != nil {
 		return err
 	}
 
 	fmt.Fprintf(os.Stderr, "done in %s\n", time.Since(start))
-	fmt.Fprintf(os.Stderr, "reports written to %s/\n", output)
+	if cfg.output != stdoutName {
+		fmt.Fprintf(os.Stderr, "reports written to %s/\n", cfg.output)
+	}
 	return nil
 }
+
+// violationSummary lists the rules rows broke, in rule order, with
+// their counts and severities, or returns "" if none were broken.
+func violationSummary(stats aggregator.ProcessStats, v aggregator.Validation) string {
+	var parts []string
+	for _, rule := range aggregator.ValidationRules() {
+		if n := stats.Violations[rule]; n > 0 {
+			parts = append(parts, fmt.Sprintf("%s %d (%s)", rule, n, v.Severity(rule)))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,177 @@
+package aggregator
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// CustomMetric is a user-defined metric: a named expression evaluated
+// for every group and shown as a column of every report.
+type CustomMetric struct {
+	Name string `json:"name"`
+	Expr *Expr  `json:"expr"`
+}
+
+// SortOrder is the direction a report ranks its metric in.
+type SortOrder string
+
+const (
+	Ascending  SortOrder = "asc"
+	Descending SortOrder = "desc"
+)
+
+// CustomRank is a top-K report ranked by a custom metric, named
+// top{K}_{metric}. Groups where the metric is null are left out. Ties
+// are broken by impressions descending, then key ascending.
+type CustomRank struct {
+	Metric string    `json:"metric"`
+	Order  SortOrder `json:"order"`
+}
+
+// ParseMetric parses a --metric value of the form name=expression.
+func ParseMetric(s string) (CustomMetric, error) {
+	name, src, ok := strings.Cut(s, "=")
+	name = strings.TrimSpace(name)
+	if !ok {
+		return CustomMetric{}, fmt.Errorf("invalid metric %q; want name=expression", s)
+	}
+	if !isIdentifier(name) {
+		return CustomMetric{}, fmt.Errorf("invalid metric %q: name %q must be letters, digits and underscores, not starting with a digit", s, name)
+	}
+	expr, err := ParseExpr(strings.TrimSpace(src))
+	if err != nil {
+		return CustomMetric{}, fmt.Errorf("invalid metric %s: %w", name, err)
+	}
+	return CustomMetric{Name: name, Expr: expr}, nil
+}
+
+// ParseRank parses a --rank value of the form "name asc" or "name desc".
+func ParseRank(s string) (CustomRank, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 2 {
+		return CustomRank{}, fmt.Errorf("invalid rank %q; want \"<metric> asc\" or \"<metric> desc\"", s)
+	}
+	order, err := ParseSortOrder(fields[1])
+	if err != nil {
+		return CustomRank{}, fmt.Errorf("invalid rank %q: %w", s, err)
+	}
+	return CustomRank{Metric: fields[0], Order: order}, nil
+}
+
+// ParseSortOrder maps "asc" and "desc", in any case, to a SortOrder.
+func ParseSortOrder(s string) (SortOrder, error) {
+	switch o := SortOrder(strings.ToLower(s)); o {
+	case Ascending, Descending:
+		return o, nil
+	}
+	return "", fmt.Errorf("invalid order %q; want asc or desc", s)
+}
+
+// ValidateCustomMetrics checks that metric names are unique and do not
+// clash with a key column or any built-in column or report, ignoring
+// case, and that every rank names a defined metric at most once.
+func ValidateCustomMetrics(metrics []CustomMetric, ranks []CustomRank, keyColumns []string) error {
+	reserved := append(slices.Clone(keyColumns), reservedColumnNames()...)
+	reserved = append(reserved, metricNames()...)
+	var defined []string
+	for _, m := range metrics {
+		if containsFold(reserved, m.Name) {
+			return fmt.Errorf("metric %s: name is already used by a column or report", m.Name)
+		}
+		if containsFold(defined, m.Name) {
+			return fmt.Errorf("metric %s: defined more than once", m.Name)
+		}
+		defined = append(defined, m.Name)
+	}
+	var ranked []string
+	for _, r := range ranks {
+		if !slices.Contains(defined, r.Metric) {
+			return fmt.Errorf("rank %s: unknown metric; define it with --metric", r.Metric)
+		}
+		if slices.Contains(ranked, r.Metric) {
+			return fmt.Errorf("rank %s: ranked more than once", r.Metric)
+		}
+		ranked = append(ranked, r.Metric)
+	}
+	return nil
+}
+
+// reservedColumnNames returns every built-in column name plus the
+// report column of streamed tables.
+func reservedColumnNames() []string {
+	names := []string{"report"}
+	for _, c := range (reportOptions{}).catalog(nil) {
+		names = append(names, c.name)
+	}
+	return names
+}
+
+func containsFold(names []string, name string) bool {
+	return slices.ContainsFunc(names, func(n string) bool { return strings.EqualFold(n, name) })
+}
+
+func isIdentifier(s string) bool {
+	if s == "" || !isIdentStart(s[0]) {
+		return false
+	}
+	for i := 1; i < len(s); i++ {
+		if !isIdentStart(s[i]) && !isDigit(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// customColumn shows m, null where it is undefined.
+func customColumn(m CustomMetric) reportColumn {
+	return reportColumn{
+		name: m.Name, float: true, nullable: true, digits: 4,
+		value: func(c *CampaignMetrics) any {
+			v, ok := m.Expr.Eval(c)
+			if !ok {
+				return nil
+			}
+			return v
+		},
+	}
+}
+
+// exprValue returns the value of expr for ranking, and a filter
+// accepting the groups where it is defined.
+func exprValue(expr *Expr) (value func(*CampaignMetrics) float64, defined Filter) {
+	value = func(m *CampaignMetrics) float64 {
+		v, _ := expr.Eval(m)
+		return v
+	}
+	defined = func(m *CampaignMetrics) bool {
+		_, ok := expr.Eval(m)
+		return ok
+	}
+	return value, defined
+}
+
+// lookupCustom returns the custom metric called name.
+func (o reportOptions) lookupCustom(name string) (CustomMetric, bool) {
+	i := slices.IndexFunc(o.metrics, func(m CustomMetric) bool { return m.Name == name })
+	if i < 0 {
+		return CustomMetric{}, false
+	}
+	return o.metrics[i], true
+}
+
+// customReport ranks store by the metric of r among the eligible groups
+// where it is defined. It returns false if the metric is not defined.
+func (o reportOptions) customReport(store MetricsStore, r CustomRank, columns []reportColumn) (report, bool) {
+	m, ok := o.lookupCustom(r.Metric)
+	if !ok {
+		return report{}, false
+	}
+	value, defined := exprValue(m.Expr)
+	return report{
+		name:        fmt.Sprintf("top%d_%s", o.topK, r.Metric),
+		rows:        topK(store, o.topK, valueRanking(value, r.Order == Descending, impressionsVolume), defined, o.eligibility),
+		columns:     columns,
+		eligibility: o.eligibility,
+	}, true
+}
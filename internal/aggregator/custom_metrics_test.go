@@ -0,0 +1,119 @@
+package aggregator
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func mustMetric(t *testing.T, s string) CustomMetric {
+	t.Helper()
+	m, err := ParseMetric(s)
+	if err != nil {
+		t.Fatalf("ParseMetric(%q): %v", s, err)
+	}
+	return m
+}
+
+func TestParseMetric(t *testing.T) {
+	m := mustMetric(t, " eCPC = spend / clicks ")
+	if m.Name != "eCPC" || m.Expr.String() != "spend / clicks" {
+		t.Errorf("got %s=%s", m.Name, m.Expr)
+	}
+	for _, bad := range []string{"spend/clicks", "=spend", "2x=spend", "e-cpc=spend", "x=spend/"} {
+		if _, err := ParseMetric(bad); err == nil {
+			t.Errorf("ParseMetric(%q): expected error", bad)
+		}
+	}
+}
+
+func TestParseRank(t *testing.T) {
+	r, err := ParseRank(" eCPC  ASC ")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if r != (CustomRank{Metric: "eCPC", Order: Ascending}) {
+		t.Errorf("got %+v", r)
+	}
+	for _, bad := range []string{"eCPC", "eCPC up", "eCPC asc desc", ""} {
+		if _, err := ParseRank(bad); err == nil {
+			t.Errorf("ParseRank(%q): expected error", bad)
+		}
+	}
+}
+
+func TestValidateCustomMetrics(t *testing.T) {
+	eCPC := mustMetric(t, "eCPC=spend/clicks")
+	cases := map[string]struct {
+		metrics []CustomMetric
+		ranks   []CustomRank
+		want    string
+	}{
+		"ok":             {[]CustomMetric{eCPC}, []CustomRank{{"eCPC", Ascending}}, ""},
+		"builtin column": {[]CustomMetric{mustMetric(t, "total_spend=spend")}, nil, "already used"},
+		"builtin case":   {[]CustomMetric{mustMetric(t, "cvr_LOWER=spend")}, nil, "already used"},
+		"builtin report": {[]CustomMetric{mustMetric(t, "profit=revenue")}, nil, "already used"},
+		"key column":     {[]CustomMetric{mustMetric(t, "Campaign_ID=spend")}, nil, "already used"},
+		"duplicate":      {[]CustomMetric{eCPC, mustMetric(t, "ecpc=spend")}, nil, "more than once"},
+		"unknown rank":   {[]CustomMetric{eCPC}, []CustomRank{{"ecpc", Ascending}}, "unknown metric"},
+		"ranked twice":   {[]CustomMetric{eCPC}, []CustomRank{{"eCPC", Ascending}, {"eCPC", Descending}}, "more than once"},
+	}
+	for name, c := range cases {
+		err := ValidateCustomMetrics(c.metrics, c.ranks, DefaultGroupBy)
+		if c.want == "" && err != nil || c.want != "" && (err == nil || !strings.Contains(err.Error(), c.want)) {
+			t.Errorf("%s: got %v, want error containing %q", name, err, c.want)
+		}
+	}
+}
+
+func TestFileReportWriter_CustomRank(t *testing.T) {
+	store := NewInMemoryMetricsStore()
+	store.Add("cheap", 1000, 50, amount("25.00"), 1)
+	store.Add("pricey", 1000, 10, amount("50.00"), 1)
+	store.Add("tied", 4000, 100, amount("50.00"), 2) // same eCPC as cheap, more impressions
+	store.Add("no_clicks", 1000, 0, amount("10.00"), 0)
+
+	dir := t.TempDir()
+	w := NewFileReportWriter(dir, 10,
+		WithReports(nil),
+		WithMetrics([]CustomMetric{mustMetric(t, "eCPC=spend/clicks")}),
+		WithRanks([]CustomRank{{Metric: "eCPC", Order: Ascending}}),
+	)
+	if err := w.WriteReports(context.Background(), store, RunInfo{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "top10_eCPC.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "campaign_id,total_impressions,total_clicks,total_spend,total_conversions,CTR,CPA,eCPC\n" +
+		"tied,4000,100,50.00,2,0.0250,25.00,0.5000\n" +
+		"cheap,1000,50,25.00,1,0.0500,25.00,0.5000\n" +
+		"pricey,1000,10,50.00,1,0.0100,50.00,5.0000\n"
+	if string(data) != want {
+		t.Errorf("got:\n%s\nwant:\n%s", data, want)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+		t.Errorf("expected only the custom report and the manifest, got %d files", len(entries))
+	}
+}
+
+func TestStreamReportWriter_CustomMetricNull(t *testing.T) {
+	var buf strings.Builder
+	w := NewStreamReportWriter(&buf, 10, WithFormat(FormatJSONL), fixedClock,
+		WithMetrics([]CustomMetric{mustMetric(t, "roi=(revenue-spend)/spend")}))
+	if err := w.WriteReports(context.Background(), jsonTestStore(), RunInfo{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if !strings.Contains(lines[0], `"metrics":[{"name":"roi","expr":"(revenue-spend)/spend"}]`) {
+		t.Errorf("metrics missing from metadata: %s", lines[0])
+	}
+	for _, line := range lines[1:] {
+		if !strings.HasSuffix(line, `"roi":null}`) {
+			t.Errorf("expected null roi without a revenue column: %s", line)
+		}
+	}
+}
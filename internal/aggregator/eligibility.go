@@ -0,0 +1,55 @@
+package aggregator
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Filter reports whether a campaign may appear in a ranking.
+type Filter func(*CampaignMetrics) bool
+
+// Eligibility holds the minimum volumes a campaign needs before it is
+// ranked, so that tiny campaigns with extreme ratios (3 impressions and
+// 1 click) do not top the reports. The zero value ranks every campaign.
+type Eligibility struct {
+	MinImpressions int64 `json:"min_impressions"`
+	MinClicks      int64 `json:"min_clicks"`
+	MinConversions int64 `json:"min_conversions"`
+	MinSpend       Money `json:"min_spend"`
+}
+
+// IsZero reports whether no threshold is set.
+func (e Eligibility) IsZero() bool {
+	return e == Eligibility{}
+}
+
+// Validate rejects negative thresholds.
+func (e Eligibility) Validate() error {
+	if e.MinImpressions < 0 || e.MinClicks < 0 || e.MinConversions < 0 || e.MinSpend < 0 {
+		return errors.New("eligibility thresholds must not be negative")
+	}
+	return nil
+}
+
+// Allows reports whether m meets every threshold.
+func (e Eligibility) Allows(m *CampaignMetrics) bool {
+	return m.TotalImpressions >= e.MinImpressions &&
+		m.TotalClicks >= e.MinClicks &&
+		m.TotalConversions >= e.MinConversions &&
+		m.TotalSpend >= e.MinSpend
+}
+
+func (e Eligibility) String() string {
+	return fmt.Sprintf("min_impressions=%d min_clicks=%d min_conversions=%d min_spend=%s",
+		e.MinImpressions, e.MinClicks, e.MinConversions, e.MinSpend)
+}
+
+// merge returns the stricter of e and other for every threshold.
+func (e Eligibility) merge(other Eligibility) Eligibility {
+	return Eligibility{
+		MinImpressions: max(e.MinImpressions, other.MinImpressions),
+		MinClicks:      max(e.MinClicks, other.MinClicks),
+		MinConversions: max(e.MinConversions, other.MinConversions),
+		MinSpend:       max(e.MinSpend, other.MinSpend),
+	}
+}
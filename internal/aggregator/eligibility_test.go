@@ -0,0 +1,44 @@
+package aggregator
+
+import "testing"
+
+func TestEligibility_Allows(t *testing.T) {
+	m := &CampaignMetrics{TotalImpressions: 1000, TotalClicks: 30, TotalSpend: amount("50.5"), TotalConversions: 3}
+	cases := []struct {
+		name string
+		e    Eligibility
+		want bool
+	}{
+		{"zero", Eligibility{}, true},
+		{"at thresholds", Eligibility{MinImpressions: 1000, MinClicks: 30, MinConversions: 3, MinSpend: amount("50.5")}, true},
+		{"impressions", Eligibility{MinImpressions: 1001}, false},
+		{"clicks", Eligibility{MinClicks: 31}, false},
+		{"conversions", Eligibility{MinConversions: 4}, false},
+		{"spend", Eligibility{MinSpend: amount("50.51")}, false},
+	}
+	for _, tc := range cases {
+		if got := tc.e.Allows(m); got != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestEligibility_Validate(t *testing.T) {
+	if err := (Eligibility{MinImpressions: 10, MinSpend: amount("1.5")}).Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := (Eligibility{MinClicks: -1}).Validate(); err == nil {
+		t.Error("expected error for negative threshold")
+	}
+	if err := (Eligibility{MinSpend: amount("-0.01")}).Validate(); err == nil {
+		t.Error("expected error for negative spend")
+	}
+}
+
+func TestEligibility_String(t *testing.T) {
+	e := Eligibility{MinImpressions: 100, MinSpend: amount("2.5")}
+	want := "min_impressions=100 min_clicks=0 min_conversions=0 min_spend=2.5"
+	if got := e.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
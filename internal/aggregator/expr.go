@@ -0,0 +1,269 @@
+package aggregator
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Expr is a compiled arithmetic expression over a group's totals, such
+// as spend/clicks or (revenue - spend) / spend. It supports numbers, the
+// variables of exprVariables, parentheses, unary minus and + - * / with
+// the usual precedence.
+//
+// Evaluation is null-propagating: dividing by zero, referencing revenue
+// without a revenue column or producing a non-finite value yields null,
+// and any expression with a null operand is null.
+type Expr struct {
+	src  string
+	root exprNode
+}
+
+// exprVariables are the names an expression can reference. The second
+// result is false when the value is undefined for the group.
+var exprVariables = map[string]func(*CampaignMetrics) (float64, bool){
+	"impressions": func(m *CampaignMetrics) (float64, bool) { return float64(m.TotalImpressions), true },
+	"clicks":      func(m *CampaignMetrics) (float64, bool) { return float64(m.TotalClicks), true },
+	"spend":       func(m *CampaignMetrics) (float64, bool) { return m.TotalSpend.Float64(), true },
+	"conversions": func(m *CampaignMetrics) (float64, bool) { return float64(m.TotalConversions), true },
+	"revenue":     func(m *CampaignMetrics) (float64, bool) { return m.TotalRevenue.Float64(), m.HasRevenue },
+}
+
+// ParseExpr compiles s.
+func ParseExpr(s string) (*Expr, error) {
+	p := exprParser{src: s}
+	p.next()
+	root, err := p.sum()
+	if err == nil && p.tok.kind != tokEOF {
+		err = p.errorf("unexpected %s", p.tok)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %w", s, err)
+	}
+	return &Expr{src: s, root: root}, nil
+}
+
+// Eval returns the value of e for m, or false when it is null.
+func (e *Expr) Eval(m *CampaignMetrics) (float64, bool) {
+	v, ok := e.root.eval(m)
+	if !ok || math.IsInf(v, 0) || math.IsNaN(v) {
+		return 0, false
+	}
+	return v, true
+}
+
+// String returns the source of e.
+func (e *Expr) String() string {
+	return e.src
+}
+
+// MarshalText records e by its source in report metadata.
+func (e *Expr) MarshalText() ([]byte, error) {
+	return []byte(e.src), nil
+}
+
+type exprNode interface {
+	eval(m *CampaignMetrics) (float64, bool)
+}
+
+type numberNode float64
+
+func (n numberNode) eval(*CampaignMetrics) (float64, bool) { return float64(n), true }
+
+type variableNode func(*CampaignMetrics) (float64, bool)
+
+func (n variableNode) eval(m *CampaignMetrics) (float64, bool) { return n(m) }
+
+type negateNode struct{ x exprNode }
+
+func (n negateNode) eval(m *CampaignMetrics) (float64, bool) {
+	v, ok := n.x.eval(m)
+	return -v, ok
+}
+
+type binaryNode struct {
+	op   byte
+	x, y exprNode
+}
+
+func (n binaryNode) eval(m *CampaignMetrics) (float64, bool) {
+	x, ok := n.x.eval(m)
+	if !ok {
+		return 0, false
+	}
+	y, ok := n.y.eval(m)
+	if !ok {
+		return 0, false
+	}
+	switch n.op {
+	case '+':
+		return x + y, true
+	case '-':
+		return x - y, true
+	case '*':
+		return x * y, true
+	}
+	if y == 0 {
+		return 0, false
+	}
+	return x / y, true
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNumber
+	tokIdent
+	tokOp // one of + - * / ( )
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	if t.kind == tokEOF {
+		return "end of expression"
+	}
+	return strconv.Quote(t.text)
+}
+
+// exprParser is a recursive-descent parser for the grammar
+//
+//	sum     = product { ("+" | "-") product }
+//	product = unary { ("*" | "/") unary }
+//	unary   = "-" unary | primary
+//	primary = number | variable | "(" sum ")"
+type exprParser struct {
+	src string
+	pos int
+	tok token
+}
+
+func (p *exprParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("at offset %d: %s", p.tok.pos, fmt.Sprintf(format, args...))
+}
+
+// next advances p.tok to the next token. Characters that cannot start a
+// token become a one-character tokOp the grammar then rejects.
+func (p *exprParser) next() {
+	for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t') {
+		p.pos++
+	}
+	start := p.pos
+	if p.pos == len(p.src) {
+		p.tok = token{kind: tokEOF, pos: start}
+		return
+	}
+	switch c := p.src[p.pos]; {
+	case isDigit(c) || c == '.':
+		for p.pos < len(p.src) && (isDigit(p.src[p.pos]) || p.src[p.pos] == '.') {
+			p.pos++
+		}
+		p.tok = token{kind: tokNumber, text: p.src[start:p.pos], pos: start}
+	case isIdentStart(c):
+		for p.pos < len(p.src) && (isIdentStart(p.src[p.pos]) || isDigit(p.src[p.pos])) {
+			p.pos++
+		}
+		p.tok = token{kind: tokIdent, text: p.src[start:p.pos], pos: start}
+	default:
+		p.pos++
+		p.tok = token{kind: tokOp, text: p.src[start:p.pos], pos: start}
+	}
+}
+
+func (p *exprParser) isOp(ops string) bool {
+	return p.tok.kind == tokOp && strings.Contains(ops, p.tok.text)
+}
+
+func (p *exprParser) sum() (exprNode, error) {
+	return p.binary("+-", p.product)
+}
+
+func (p *exprParser) product() (exprNode, error) {
+	return p.binary("*/", p.unary)
+}
+
+// binary parses a left-associative chain of operand separated by ops.
+func (p *exprParser) binary(ops string, operand func() (exprNode, error)) (exprNode, error) {
+	x, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp(ops) {
+		op := p.tok.text[0]
+		p.next()
+		y, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		x = binaryNode{op: op, x: x, y: y}
+	}
+	return x, nil
+}
+
+func (p *exprParser) unary() (exprNode, error) {
+	if p.isOp("-") {
+		p.next()
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return negateNode{x}, nil
+	}
+	return p.primary()
+}
+
+func (p *exprParser) primary() (exprNode, error) {
+	tok := p.tok
+	switch {
+	case tok.kind == tokNumber:
+		v, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, p.errorf("bad number %q", tok.text)
+		}
+		p.next()
+		return numberNode(v), nil
+	case tok.kind == tokIdent:
+		variable, ok := exprVariables[tok.text]
+		if !ok {
+			return nil, p.errorf("unknown variable %q; want %s", tok.text, strings.Join(exprVariableNames(), ", "))
+		}
+		p.next()
+		return variableNode(variable), nil
+	case p.isOp("("):
+		p.next()
+		x, err := p.sum()
+		if err != nil {
+			return nil, err
+		}
+		if !p.isOp(")") {
+			return nil, p.errorf("expected \")\", got %s", p.tok)
+		}
+		p.next()
+		return x, nil
+	}
+	return nil, p.errorf("unexpected %s", tok)
+}
+
+func exprVariableNames() []string {
+	names := make([]string, 0, len(exprVariables))
+	for name := range exprVariables {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
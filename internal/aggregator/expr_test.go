@@ -0,0 +1,86 @@
+package aggregator
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExpr_Eval(t *testing.T) {
+	m := &CampaignMetrics{
+		TotalImpressions: 2000, TotalClicks: 40, TotalSpend: amount("100"),
+		TotalConversions: 4, TotalRevenue: amount("250"), HasRevenue: true,
+	}
+	cases := map[string]float64{
+		"spend/clicks":                  2.5,
+		"spend / clicks * 1000":         2500,
+		"1000 * clicks / impressions":   20,
+		"(revenue - spend) / spend":     1.5,
+		"revenue - spend / conversions": 225,
+		"-spend + -(-revenue)":          150,
+		"10 - 4 - 3":                    3,
+		"64 / 4 / 2":                    8,
+		"conversions*.5":                2,
+	}
+	for src, want := range cases {
+		e, err := ParseExpr(src)
+		if err != nil {
+			t.Errorf("ParseExpr(%q): %v", src, err)
+			continue
+		}
+		got, ok := e.Eval(m)
+		if !ok || got != want {
+			t.Errorf("%s = %v (ok=%v), want %v", src, got, ok, want)
+		}
+	}
+}
+
+func TestExpr_Null(t *testing.T) {
+	noRevenue := &CampaignMetrics{TotalImpressions: 100, TotalSpend: amount("5")}
+	for _, src := range []string{
+		"spend/clicks",
+		"1 + spend/clicks",
+		"(spend/clicks) * 0",
+		"revenue",
+		"revenue - spend",
+		"spend / (impressions - 100)",
+	} {
+		e, err := ParseExpr(src)
+		if err != nil {
+			t.Fatalf("ParseExpr(%q): %v", src, err)
+		}
+		if v, ok := e.Eval(noRevenue); ok {
+			t.Errorf("%s = %v, want null", src, v)
+		}
+	}
+}
+
+func TestExpr_NonFiniteIsNull(t *testing.T) {
+	e, err := ParseExpr("spend * 1" + strings.Repeat("0", 308) + " * 10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := e.Eval(&CampaignMetrics{TotalSpend: amount("10")}); ok {
+		t.Errorf("overflow = %v, want null", v)
+	}
+}
+
+func TestParseExpr_Errors(t *testing.T) {
+	for src, want := range map[string]string{
+		"":              "unexpected end of expression",
+		"spend/":        "at offset 6: unexpected end of expression",
+		"spend clicks":  `at offset 6: unexpected "clicks"`,
+		"(spend":        `expected ")"`,
+		"spend)":        `unexpected ")"`,
+		"spend % 2":     `unexpected "%"`,
+		"1.2.3":         `bad number "1.2.3"`,
+		"1.25e0":        `unexpected "e0"`,
+		"Spend":         `unknown variable "Spend"`,
+		"ctr * 2":       `unknown variable "ctr"; want clicks, conversions, impressions, revenue, spend`,
+		"spend//clicks": `unexpected "/"`,
+	} {
+		_, err := ParseExpr(src)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("ParseExpr(%q): got %v, want error containing %q", src, err, want)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package aggregator
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/khanhduong95/ad-performance-aggregator/internal/zstd"
+)
+
+// Compression names an input compression format.
+type Compression string
+
+const (
+	CompressionNone  Compression = "none"
+	CompressionGzip  Compression = "gzip"
+	CompressionBzip2 Compression = "bzip2"
+	CompressionZstd  Compression = "zstd"
+)
+
+var magicNumbers = []struct {
+	format Compression
+	magic  []byte
+}{
+	{CompressionGzip, []byte{0x1f, 0x8b}},
+	{CompressionZstd, []byte{0x28, 0xb5, 0x2f, 0xfd}},
+}
+
+// A bzip2 stream starts with "BZh", a block size digit from 1 to 9 and
+// the magic of its first block, or of the end of the stream if it holds
+// no data. "BZh" alone is too common a start for a CSV header.
+var (
+	bzip2BlockMagic = []byte{0x31, 0x41, 0x59, 0x26, 0x53, 0x59}
+	bzip2EndMagic   = []byte{0x17, 0x72, 0x45, 0x38, 0x50, 0x90}
+)
+
+// maxMagicLen is the number of leading bytes DetectCompression needs:
+// the bzip2 header and block magic.
+const maxMagicLen = 10
+
+// DetectCompression identifies the compression format of data from
+// its leading magic bytes. The file name is deliberately not
+// consulted, so misnamed inputs are still read correctly.
+func DetectCompression(data []byte) Compression {
+	for _, m := range magicNumbers {
+		if bytes.HasPrefix(data, m.magic) {
+			return m.format
+		}
+	}
+	if isBzip2(data) {
+		return CompressionBzip2
+	}
+	return CompressionNone
+}
+
+func isBzip2(data []byte) bool {
+	if len(data) < maxMagicLen || !bytes.HasPrefix(data, []byte("BZh")) || data[3] < '1' || data[3] > '9' {
+		return false
+	}
+	return bytes.Equal(data[4:10], bzip2BlockMagic) || bytes.Equal(data[4:10], bzip2EndMagic)
+}
+
+// Decompress sniffs the compression format of r and returns a reader
+// that yields the decompressed stream. Uncompressed input that is
+// seekable is rewound and returned as-is so that parallel parsing can
+// still use it; compressed input is always a plain forward-only stream.
+func Decompress(r io.Reader) (io.Reader, Compression, error) {
+	var src io.Reader
+	var head []byte
+	if rs, ok := r.(io.ReadSeeker); ok && isSeekable(rs) {
+		pos, err := rs.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, "", fmt.Errorf("detect compression: %w", err)
+		}
+		head = make([]byte, maxMagicLen)
+		n, err := io.ReadFull(rs, head)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return nil, "", fmt.Errorf("detect compression: %w", err)
+		}
+		head = head[:n]
+		if _, err := rs.Seek(pos, io.SeekStart); err != nil {
+			return nil, "", fmt.Errorf("detect compression: %w", err)
+		}
+		src = rs
+	} else {
+		br := bufio.NewReader(r)
+		var err error
+		head, err = br.Peek(maxMagicLen)
+		if err != nil && err != io.EOF {
+			return nil, "", fmt.Errorf("detect compression: %w", err)
+		}
+		src = br
+	}
+
+	format := DetectCompression(head)
+	switch format {
+	case CompressionGzip:
+		zr, err := gzip.NewReader(src)
+		if err != nil {
+			return nil, "", fmt.Errorf("open gzip stream: %w", err)
+		}
+		return zr, format, nil
+	case CompressionBzip2:
+		return bzip2.NewReader(src), format, nil
+	case CompressionZstd:
+		return zstd.NewReader(src), format, nil
+	}
+	return src, format, nil
+}
+
+// isSeekable reports whether rs can actually seek; an *os.File for a
+// pipe or terminal has a Seek method that always fails.
+func isSeekable(rs io.Seeker) bool {
+	_, err := rs.Seek(0, io.SeekCurrent)
+	return err == nil
+}
+
+// Input is one named input stream, opened only when the service gets
+// to it so that many shards do not hold many file descriptors. Path is
+// the file it reads, if any; the service then opens the file itself,
+// rather than calling Open, so that it can hash the raw bytes for the
+// run manifest as they are read.
+type Input struct {
+	Name string
+	Path string
+	Open func() (io.ReadCloser, error)
+}
+
+// FileInput returns an Input that opens path and decompresses it if
+// needed.
+func FileInput(path string) Input {
+	return Input{
+		Name: path,
+		Path: path,
+		Open: func() (io.ReadCloser, error) {
+			f, err := os.Open(path)
+			if err != nil {
+				return nil, fmt.Errorf("open input: %w", err)
+			}
+			return decompressFile(f, path)
+		},
+	}
+}
+
+// decompressFile returns the decompressed stream of f, an open input
+// file, or f itself if it is not compressed so that parallel parsing
+// can still use it. Closing the result closes f; f is closed on error.
+func decompressFile(f io.ReadCloser, path string) (io.ReadCloser, error) {
+	r, compression, err := Decompress(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	slog.Debug("opened input", "path", path, "compression", compression)
+	if r == io.Reader(f) {
+		return f, nil
+	}
+	return &namedReader{Reader: r, name: path, close: f.Close}, nil
+}
+
+// StdinName is the --input value that reads standard input.
+const StdinName = "-"
+
+// StdinInput returns an Input reading standard input, decompressing it
+// if needed. Closing it leaves stdin open.
+func StdinInput() Input {
+	return Input{
+		Name: "stdin",
+		Open: func() (io.ReadCloser, error) {
+			r, compression, err := Decompress(os.Stdin)
+			if err != nil {
+				return nil, err
+			}
+			slog.Debug("opened input", "path", "stdin", "compression", compression)
+			if r == io.Reader(os.Stdin) {
+				// Redirected from a regular file: keep random access
+				// so parallel parsing still applies.
+				return stdinFile{os.Stdin}, nil
+			}
+			return &namedReader{Reader: r, name: "stdin"}, nil
+		},
+	}
+}
+
+// stdinFile is os.Stdin with a display name and a no-op Close.
+type stdinFile struct {
+	*os.File
+}
+
+func (stdinFile) Name() string { return "stdin" }
+func (stdinFile) Close() error { return nil }
+
+// namedReader is a stream derived from an input (decompressed or
+// buffered) that still reports the input's name, and closes the
+// underlying file if close is set.
+type namedReader struct {
+	io.Reader
+	name  string
+	close func() error
+}
+
+func (n *namedReader) Name() string {
+	return n.name
+}
+
+func (n *namedReader) Close() error {
+	if n.close == nil {
+		return nil
+	}
+	return n.close()
+}
+
+// ExpandInputs resolves --input arguments to a list of files. Each
+// argument may be a file, a directory (its regular, non-hidden files,
+// not recursive), a glob pattern, or StdinName, which is passed through
+// as is. An argument naming an existing file or directory is never
+// treated as a pattern. Results keep argument order, with directory and
+// glob matches sorted by name; duplicates are dropped.
+func ExpandInputs(args []string) ([]string, error) {
+	var paths []string
+	seen := make(map[string]bool)
+	add := func(p string) {
+		if !seen[p] {
+			seen[p] = true
+			paths = append(paths, p)
+		}
+	}
+
+	for _, arg := range args {
+		if arg == StdinName {
+			add(arg)
+			continue
+		}
+		matches := []string{arg}
+		// A path that exists is taken literally, so report[1].csv is a
+		// file rather than a pattern matching report1.csv.
+		if _, err := os.Stat(arg); err != nil && strings.ContainsAny(arg, "*?[") {
+			matches, err = filepath.Glob(arg)
+			if err != nil {
+				return nil, fmt.Errorf("input %q: %w", arg, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("input %q: no files match", arg)
+			}
+		}
+		for _, m := range matches {
+			info, err := os.Stat(m)
+			if err != nil {
+				return nil, fmt.Errorf("input %q: %w", arg, err)
+			}
+			if !info.IsDir() {
+				add(m)
+				continue
+			}
+			files, err := dirFiles(m)
+			if err != nil {
+				return nil, fmt.Errorf("input %q: %w", arg, err)
+			}
+			for _, f := range files {
+				add(f)
+			}
+		}
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no input files found in %v", args)
+	}
+	return paths, nil
+}
+
+func dirFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, e := range entries {
+		if e.Type().IsRegular() && !strings.HasPrefix(e.Name(), ".") {
+			files = append(files, filepath.Join(dir, e.Name()))
+		}
+	}
+	return files, nil
+}
@@ -0,0 +1,360 @@
+package aggregator
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDecompress_Formats(t *testing.T) {
+	want, err := os.ReadFile(filepath.Join("testdata", "ad_data.csv"))
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+
+	cases := map[string]Compression{
+		"ad_data.csv":     CompressionNone,
+		"ad_data.csv.gz":  CompressionGzip,
+		"ad_data.csv.bz2": CompressionBzip2,
+		"ad_data.csv.zst": CompressionZstd,
+	}
+	for name, wantFormat := range cases {
+		t.Run(name, func(t *testing.T) {
+			f, err := os.Open(filepath.Join("testdata", name))
+			if err != nil {
+				t.Fatalf("open fixture: %v", err)
+			}
+			defer f.Close()
+
+			r, format, err := Decompress(f)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if format != wantFormat {
+				t.Errorf("format: got %s, want %s", format, wantFormat)
+			}
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestDecompress_IgnoresExtension(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "ad_data.csv.gz"))
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+	// A non-seekable reader with no name at all is still detected.
+	_, format, err := Decompress(io.MultiReader(bytes.NewReader(data)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if format != CompressionGzip {
+		t.Errorf("format: got %s, want gzip", format)
+	}
+}
+
+func TestDecompress_PlainCSVStartingWithBZh(t *testing.T) {
+	input := "BZh_campaign,impressions,clicks,spend,conversions\ncamp1,10,1,1.00,1\n"
+	for name, r := range map[string]io.Reader{
+		"seekable": strings.NewReader(input),
+		"stream":   io.MultiReader(strings.NewReader(input)),
+	} {
+		dr, format, err := Decompress(r)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+		if format != CompressionNone {
+			t.Errorf("%s: format: got %s, want none", name, format)
+		}
+		got, err := io.ReadAll(dr)
+		if err != nil || string(got) != input {
+			t.Errorf("%s: got %q, %v, want the input unchanged", name, got, err)
+		}
+	}
+}
+
+func TestDetectCompression_Bzip2(t *testing.T) {
+	for head, want := range map[string]Compression{
+		"BZh9\x31\x41\x59\x26\x53\x59": CompressionBzip2,
+		"BZh1\x17\x72\x45\x38\x50\x90": CompressionBzip2,
+		"BZh0\x31\x41\x59\x26\x53\x59": CompressionNone,
+		"BZh9\x31\x41\x59\x26\x53\x58": CompressionNone,
+		"BZh9,1,2,3":                   CompressionNone,
+		"BZh9":                         CompressionNone,
+	} {
+		if got := DetectCompression([]byte(head)); got != want {
+			t.Errorf("%q: got %s, want %s", head, got, want)
+		}
+	}
+}
+
+func TestDecompress_PlainSeekableStaysSeekable(t *testing.T) {
+	f, err := os.Open(filepath.Join("testdata", "ad_data.csv"))
+	if err != nil {
+		t.Fatalf("open fixture: %v", err)
+	}
+	defer f.Close()
+
+	r, _, err := Decompress(f)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if r != io.Reader(f) {
+		t.Error("expected the original file to be returned for parallel parsing")
+	}
+}
+
+func TestDecompress_ShortInput(t *testing.T) {
+	r, format, err := Decompress(bytes.NewReader([]byte("a")))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if format != CompressionNone {
+		t.Errorf("format: got %s, want none", format)
+	}
+	if got, _ := io.ReadAll(r); string(got) != "a" {
+		t.Errorf("got %q, want %q", got, "a")
+	}
+}
+
+func TestCSVProcessor_CompressedInput(t *testing.T) {
+	f, err := os.Open(filepath.Join("testdata", "ad_data.csv.zst"))
+	if err != nil {
+		t.Fatalf("open fixture: %v", err)
+	}
+	defer f.Close()
+
+	r, _, err := Decompress(f)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	store := NewInMemoryMetricsStore()
+	if _, err := NewCSVProcessor(WithWorkers(4)).Process(context.Background(), r, store); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if m := findByCampaignID(store.TopKByCTR(10), "camp1"); m == nil || m.TotalImpressions != 1500 {
+		t.Errorf("camp1: got %v, want 1500 impressions", m)
+	}
+}
+
+func TestExpandInputs(t *testing.T) {
+	dir := t.TempDir()
+	shards := filepath.Join(dir, "shards")
+	if err := os.Mkdir(shards, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{
+		"ad_data_T01.csv", "ad_data_T00.csv", "other.csv",
+		filepath.Join("shards", "b.csv"), filepath.Join("shards", "a.csv.gz"), filepath.Join("shards", ".hidden"),
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := ExpandInputs([]string{
+		filepath.Join(dir, "ad_data_T*.csv"),
+		shards,
+		filepath.Join(dir, "ad_data_T00.csv"), // already matched by the glob
+		filepath.Join(dir, "other.csv"),
+		StdinName,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{
+		filepath.Join(dir, "ad_data_T00.csv"),
+		filepath.Join(dir, "ad_data_T01.csv"),
+		filepath.Join(shards, "a.csv.gz"),
+		filepath.Join(shards, "b.csv"),
+		filepath.Join(dir, "other.csv"),
+		StdinName,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestExpandInputs_LiteralBrackets(t *testing.T) {
+	dir := t.TempDir()
+	literal := filepath.Join(dir, "report[1].csv")
+	for _, path := range []string{literal, filepath.Join(dir, "report1.csv")} {
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := ExpandInputs([]string{literal})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got) != 1 || got[0] != literal {
+		t.Errorf("got %q, want %q", got, literal)
+	}
+
+	// A pattern that names no file is still globbed.
+	got, err = ExpandInputs([]string{filepath.Join(dir, "report[0-9].csv")})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := filepath.Join(dir, "report1.csv"); len(got) != 1 || got[0] != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestExpandInputs_Errors(t *testing.T) {
+	dir := t.TempDir()
+	for _, args := range [][]string{
+		{filepath.Join(dir, "*.csv")},
+		{filepath.Join(dir, "missing.csv")},
+		{dir},
+	} {
+		if _, err := ExpandInputs(args); err == nil {
+			t.Errorf("%q: expected error", args)
+		}
+	}
+}
+
+func TestService_RunInputs(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	a := write("a.csv", "campaign_id,impressions,clicks,spend,conversions\ncamp1,1000,50,100.00,10\n")
+	b := write("b.csv", "campaign_id,impressions,clicks,spend,conversions\ncamp1,500,25,50.00,5\ncamp2,100,1,1.00,1\n")
+	reordered := write("c.csv", "impressions,campaign_id,clicks,spend,conversions\n100,camp3,1,1.00,1\n")
+	bad := write("d.csv", "campaign_id,impressions,clicks,spend,conversions\ncamp1,x,25,50.00,5\n")
+	withRevenue := write("e.csv", "campaign_id,impressions,clicks,spend,conversions,revenue\ncamp1,10,1,1.00,1,5.00\n")
+
+	out := t.TempDir()
+	svc := NewService(NewCSVProcessor(), NewFileReportWriter(out, 10))
+	stats, err := svc.RunInputs(context.Background(), []Input{FileInput(a), FileInput(b)})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stats.RowsAccepted != 3 {
+		t.Errorf("expected 3 rows accepted, got %d", stats.RowsAccepted)
+	}
+	data, err := os.ReadFile(filepath.Join(out, "top10_ctr.csv"))
+	if err != nil {
+		t.Fatalf("read ctr file: %v", err)
+	}
+	if !strings.Contains(string(data), "camp1,1500,75,150.00,15,") {
+		t.Errorf("expected camp1 summed across files, got:\n%s", data)
+	}
+
+	for _, workers := range []int{1, 4} {
+		svc = NewService(NewCSVProcessor(WithWorkers(workers)), NewFileReportWriter(out, 10))
+		stats, err = svc.RunInputs(context.Background(), []Input{FileInput(a), FileInput(reordered)})
+		if err != nil {
+			t.Fatalf("workers=%d: unexpected error with reordered columns: %v", workers, err)
+		}
+		data, err = os.ReadFile(filepath.Join(out, "top10_ctr.csv"))
+		if err != nil {
+			t.Fatalf("read ctr file: %v", err)
+		}
+		if stats.RowsAccepted != 2 || !strings.Contains(string(data), "camp3,100,1,1.00,1,") {
+			t.Errorf("workers=%d: expected camp3 read by its column names, got:\n%s", workers, data)
+		}
+	}
+
+	svc = NewService(NewCSVProcessor(), NewFileReportWriter(out, 10))
+	_, err = svc.RunInputs(context.Background(), []Input{FileInput(a), FileInput(withRevenue)})
+	if err == nil || !strings.HasPrefix(err.Error(), withRevenue+": header") {
+		t.Errorf("expected a column mismatch naming %s, got %v", withRevenue, err)
+	}
+
+	svc = NewService(NewCSVProcessor(), NewFileReportWriter(out, 10))
+	_, err = svc.RunInputs(context.Background(), []Input{FileInput(a), FileInput(bad)})
+	if err == nil || !strings.HasPrefix(err.Error(), bad+": line 2: bad impressions") {
+		t.Errorf("expected parse error naming %s, got %v", bad, err)
+	}
+}
+
+func TestCSVProcessor_ErrorBudgetSpansInputs(t *testing.T) {
+	p := NewCSVProcessor(WithErrorPolicy(ErrorPolicy{Mode: SkipOnError, MaxErrors: maxErrors(1)}))
+	store := NewInMemoryMetricsStore()
+	input := "campaign_id,impressions,clicks,spend,conversions\ncamp1,x,1,1.00,1\n"
+
+	if _, err := p.Process(context.Background(), strings.NewReader(input), store); err != nil {
+		t.Fatalf("first input: unexpected error: %v", err)
+	}
+	if _, err := p.Process(context.Background(), strings.NewReader(input), store); err == nil {
+		t.Fatal("second input: expected the shared error budget to be exceeded")
+	}
+}
+
+// withStdin points os.Stdin at f for the duration of the test.
+func withStdin(t *testing.T, f *os.File) {
+	t.Helper()
+	orig := os.Stdin
+	os.Stdin = f
+	t.Cleanup(func() { os.Stdin = orig })
+}
+
+func TestStdinInput_Pipe(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "ad_data.csv.gz"))
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.Close()
+	go func() {
+		pw.Write(data)
+		pw.Close()
+	}()
+	withStdin(t, pr)
+
+	rc, err := StdinInput().Open()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer rc.Close()
+	stats, err := NewCSVProcessor(WithWorkers(4)).Process(context.Background(), rc, NewInMemoryMetricsStore())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stats.RowsAccepted != 3 {
+		t.Errorf("expected 3 rows, got %d", stats.RowsAccepted)
+	}
+}
+
+func TestStdinInput_RedirectedFileStaysSeekable(t *testing.T) {
+	f, err := os.Open(filepath.Join("testdata", "ad_data.csv"))
+	if err != nil {
+		t.Fatalf("open fixture: %v", err)
+	}
+	defer f.Close()
+	withStdin(t, f)
+
+	rc, err := StdinInput().Open()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := rc.(seekableReaderAt); !ok {
+		t.Errorf("expected a seekable reader, got %T", rc)
+	}
+	if err := rc.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	// Closing the input must leave stdin itself usable.
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Errorf("stdin was closed: %v", err)
+	}
+}
@@ -1,29 +1,96 @@
 package aggregator
 
-import "io"
+import (
+	"context"
+	"io"
+	"time"
+)
 
+// Processor reads an input into a store. It stops early with ctx.Err()
+// once ctx is done.
 type Processor interface {
-	Process(r io.Reader, store MetricsStore) error
+	Process(ctx context.Context, r io.Reader, store MetricsStore) (ProcessStats, error)
 }
 
+// ProcessStats summarises the rows seen by a Processor. Violations
+// counts the rows that broke each validation rule, by rule name,
+// whatever its severity.
+type ProcessStats struct {
+	RowsAccepted int64
+	RowsRejected int64
+	Violations   map[string]int64
+}
+
+func (s *ProcessStats) add(other ProcessStats) {
+	s.RowsAccepted += other.RowsAccepted
+	s.RowsRejected += other.RowsRejected
+	for rule, n := range other.Violations {
+		s.countViolations(rule, n)
+	}
+}
+
+func (s *ProcessStats) countViolation(rule string) {
+	s.countViolations(rule, 1)
+}
+
+func (s *ProcessStats) countViolations(rule string, n int64) {
+	if s.Violations == nil {
+		s.Violations = make(map[string]int64)
+	}
+	s.Violations[rule] += n
+}
+
+// ReportWriter writes the reports for a filled store. If ctx is done
+// before the reports are complete it returns ctx.Err() without leaving
+// any of them behind.
 type ReportWriter interface {
-	WriteReports(store MetricsStore) error
+	WriteReports(ctx context.Context, store MetricsStore, run RunInfo) error
+}
+
+// RunInfo describes the run that filled a store, for writers that
+// record provenance alongside the reports. Files describes each input
+// as it was read, when the run read inputs one by one; only the name is
+// set for inputs that are not files.
+type RunInfo struct {
+	Inputs  []string
+	Files   []ManifestInput
+	Stats   ProcessStats
+	Started time.Time
 }
 
 // MetricsStore owns the accumulation (write path) and top-K retrieval
-// (read path) of campaign metrics.
+// (read path) of campaign metrics, grouped by GroupKey.
 type MetricsStore interface {
+	// Add adds one row to the group. It fails, leaving the group
+	// unchanged, if the total spend would overflow Money.
 	Add(
-		campaignID string,
+		key GroupKey,
 		impressions, clicks int64,
-		spend float64,
+		spend Money,
 		conversions int64,
-	)
+	) error
 
-	// TopKByCTR returns the top k campaigns sorted by CTR descending.
-	TopKByCTR(k int) []*CampaignMetrics
+	// AddRevenue adds revenue to the group, marking it as having
+	// revenue. It is only called for inputs with a revenue column, and
+	// fails like Add on overflow.
+	AddRevenue(key GroupKey, revenue Money) error
+
+	// TopKByCTR returns the top k campaigns sorted by CTR descending,
+	// among those accepted by every filter. Ties are broken by
+	// impressions descending, then key ascending.
+	TopKByCTR(k int, filters ...Filter) []*CampaignMetrics
 
 	// TopKByCPA returns the top k campaigns sorted by CPA ascending,
-	// excluding campaigns with zero conversions.
-	TopKByCPA(k int) []*CampaignMetrics
+	// excluding campaigns with zero conversions and those rejected by a
+	// filter. Ties are broken by conversions descending, then key
+	// ascending.
+	TopKByCPA(k int, filters ...Filter) []*CampaignMetrics
+
+	// TopK returns the top k campaigns under rank, best first, among
+	// those accepted by every filter.
+	TopK(k int, rank Ranking, filters ...Filter) []*CampaignMetrics
+
+	// Each calls fn for every group, in no particular order. It is used
+	// to compute population statistics after aggregation.
+	Each(fn func(*CampaignMetrics))
 }
@@ -0,0 +1,103 @@
+package aggregator
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// DefaultConfidence is the interval confidence level used when ranking
+// by lower bounds without an explicit level.
+const DefaultConfidence = 0.95
+
+// ParseConfidence parses a two-sided confidence level given as a
+// fraction ("0.95") or a percentage ("95%").
+func ParseConfidence(s string) (float64, error) {
+	num, scale := s, 1.0
+	if p, ok := strings.CutSuffix(s, "%"); ok {
+		num, scale = p, 100
+	}
+	v, err := strconv.ParseFloat(num, 64)
+	if err != nil || !(v/scale > 0 && v/scale < 1) {
+		return 0, fmt.Errorf("invalid confidence level %q; want a value between 0 and 1 exclusive, or a percentage", s)
+	}
+	return v / scale, nil
+}
+
+// zScore returns the standard normal quantile for a two-sided interval
+// at the given confidence level, e.g. 1.96 for 0.95.
+func zScore(level float64) float64 {
+	return math.Sqrt2 * math.Erfinv(level)
+}
+
+// wilson returns the Wilson score interval for successes out of trials
+// at normal quantile z. ok is false when the proportion is undefined:
+// no trials, or more successes than trials.
+func wilson(successes, trials int64, z float64) (lo, hi float64, ok bool) {
+	if trials <= 0 || successes < 0 || successes > trials {
+		return 0, 0, false
+	}
+	n := float64(trials)
+	p := float64(successes) / n
+	z2 := z * z
+	denom := 1 + z2/n
+	center := (p + z2/(2*n)) / denom
+	half := z / denom * math.Sqrt(p*(1-p)/n+z2/(4*n*n))
+	return max(center-half, 0), min(center+half, 1), true
+}
+
+// intervalColumns returns the CTR and CVR (conversions / clicks) bound
+// columns at normal quantile z.
+func intervalColumns(z float64) (ctr, cvr []reportColumn) {
+	bound := func(name string, upper bool, successes, trials func(*CampaignMetrics) int64) reportColumn {
+		return reportColumn{
+			name: name, float: true, nullable: true, digits: 4,
+			value: func(m *CampaignMetrics) any {
+				lo, hi, ok := wilson(successes(m), trials(m), z)
+				switch {
+				case !ok:
+					return nil
+				case upper:
+					return hi
+				}
+				return lo
+			},
+		}
+	}
+	clicks := func(m *CampaignMetrics) int64 { return m.TotalClicks }
+	impressions := func(m *CampaignMetrics) int64 { return m.TotalImpressions }
+	conversions := func(m *CampaignMetrics) int64 { return m.TotalConversions }
+	ctr = []reportColumn{
+		bound("CTR_lower", false, clicks, impressions),
+		bound("CTR_upper", true, clicks, impressions),
+	}
+	cvr = []reportColumn{
+		bound("CVR_lower", false, conversions, clicks),
+		bound("CVR_upper", true, conversions, clicks),
+	}
+	return ctr, cvr
+}
+
+// ctrLowerBound is the CTR ranking value in RankLower mode: the lower
+// Wilson bound, or 0 when CTR is undefined.
+func ctrLowerBound(z float64) func(*CampaignMetrics) float64 {
+	return func(m *CampaignMetrics) float64 {
+		lo, _, _ := wilson(m.TotalClicks, m.TotalImpressions, z)
+		return lo
+	}
+}
+
+// cpaAtCVRLowerBound is the CPA ranking value in RankLower mode: the CPA
+// implied by the pessimistic conversion rate,
+// spend / (clicks * CVR lower bound). It is +Inf, ranking last, when
+// that bound is zero or undefined.
+func cpaAtCVRLowerBound(z float64) func(*CampaignMetrics) float64 {
+	return func(m *CampaignMetrics) float64 {
+		lo, _, ok := wilson(m.TotalConversions, m.TotalClicks, z)
+		if !ok || lo == 0 {
+			return math.Inf(1)
+		}
+		return m.TotalSpend.Float64() / (float64(m.TotalClicks) * lo)
+	}
+}
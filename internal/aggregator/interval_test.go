@@ -0,0 +1,96 @@
+package aggregator
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParseConfidence(t *testing.T) {
+	cases := []struct {
+		in      string
+		want    float64
+		wantErr bool
+	}{
+		{in: "0.95", want: 0.95},
+		{in: "99%", want: 0.99},
+		{in: "0.5", want: 0.5},
+		{in: "1", wantErr: true},
+		{in: "0", wantErr: true},
+		{in: "100%", wantErr: true},
+		{in: "-0.9", wantErr: true},
+		{in: "high", wantErr: true},
+	}
+	for _, tc := range cases {
+		got, err := ParseConfidence(tc.in)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%q: unexpected error state: %v", tc.in, err)
+			continue
+		}
+		if err != nil && !strings.Contains(err.Error(), strconv.Quote(tc.in)) {
+			t.Errorf("%q: error %q does not quote the argument", tc.in, err)
+		}
+		if got != tc.want {
+			t.Errorf("%q: got %v, want %v", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestZScore(t *testing.T) {
+	for level, want := range map[float64]float64{0.95: 1.9599639845400536, 0.99: 2.5758293035489} {
+		if got := zScore(level); math.Abs(got-want) > 1e-9 {
+			t.Errorf("zScore(%v) = %v, want %v", level, got, want)
+		}
+	}
+}
+
+func TestWilson(t *testing.T) {
+	z := zScore(0.95)
+	cases := []struct {
+		successes, trials int64
+		lo, hi            float64
+	}{
+		{11, 400, 0.015423636260295418, 0.048565481885614784},
+		{1, 10, 0.017876213095072924, 0.40415002679523837},
+		{300, 10000, 0.02683280461731258, 0.03352815385112342},
+		{0, 50, 0, 0.0713475991333587},
+		{50, 50, 0.9286524008666414, 1},
+	}
+	for _, tc := range cases {
+		lo, hi, ok := wilson(tc.successes, tc.trials, z)
+		if !ok || math.Abs(lo-tc.lo) > 1e-12 || math.Abs(hi-tc.hi) > 1e-12 {
+			t.Errorf("wilson(%d, %d) = (%v, %v, %v), want (%v, %v)", tc.successes, tc.trials, lo, hi, ok, tc.lo, tc.hi)
+		}
+	}
+	for _, tc := range [][2]int64{{0, 0}, {5, 4}, {-1, 10}} {
+		if _, _, ok := wilson(tc[0], tc[1], z); ok {
+			t.Errorf("wilson(%d, %d): expected undefined", tc[0], tc[1])
+		}
+	}
+}
+
+func TestRankLower(t *testing.T) {
+	s := NewInMemoryMetricsStore()
+	s.Add("lucky", 10, 1, amount("5"), 1)            // CTR 0.1, CVR 1, CPA 5
+	s.Add("steady", 10000, 300, amount("3000"), 150) // CTR 0.03, CVR 0.5, CPA 20
+	s.Add("no_clicks", 10000, 0, amount("100"), 0)   // CTR 0, no CVR
+	s.Add("bad_data", 100, 2, amount("10"), 5)       // more conversions than clicks
+
+	z := zScore(0.95)
+	if top := s.TopKByCTR(1); top[0].Key != "lucky" {
+		t.Fatalf("raw CTR leader: got %s", top[0].Key)
+	}
+	ctr := s.TopK(4, ctrRanking(ctrLowerBound(z)))
+	if ctr[0].Key != "steady" || ctr[1].Key != "lucky" || ctr[3].Key != "no_clicks" {
+		t.Errorf("lower-bound CTR order: got %s, %s, %s, %s", ctr[0].Key, ctr[1].Key, ctr[2].Key, ctr[3].Key)
+	}
+
+	// steady's CVR lower bound (about 0.44) beats lucky's (about 0.21)
+	// by enough to overturn its four times higher raw CPA: 3000 / (300
+	// * 0.44) ~ 23 against 5 / (1 * 0.21) ~ 24. bad_data ranks last.
+	cpa := s.TopK(3, cpaRanking(cpaAtCVRLowerBound(z)), hasConversions)
+	if cpa[0].Key != "steady" || cpa[1].Key != "lucky" || cpa[2].Key != "bad_data" {
+		t.Errorf("lower-bound CPA order: got %s, %s, %s", cpa[0].Key, cpa[1].Key, cpa[2].Key)
+	}
+}
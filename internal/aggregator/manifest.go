@@ -0,0 +1,272 @@
+package aggregator
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ManifestName is the file, next to the reports, that describes the run
+// which wrote them.
+const ManifestName = "manifest.json"
+
+// Manifest records what a file report writer published and how: every
+// report file with its SHA-256, row count and eligibility thresholds,
+// the inputs, the program version and flags, the row counts and when
+// the run started and finished. It is published together with the
+// reports, so it always describes the reports beside it; consumers can
+// check them with VerifyManifest before loading.
+type Manifest struct {
+	Version      string            `json:"version,omitempty"`
+	Flags        map[string]string `json:"flags,omitempty"`
+	StartedAt    time.Time         `json:"started_at"`
+	FinishedAt   time.Time         `json:"finished_at"`
+	RowsAccepted int64             `json:"rows_accepted"`
+	RowsRejected int64             `json:"rows_rejected"`
+	Violations   map[string]int64  `json:"violations,omitempty"`
+	Inputs       []ManifestInput   `json:"inputs"`
+	Reports      []ManifestReport  `json:"reports"`
+}
+
+// ManifestInput describes one input as the run read it. Size and
+// SHA256 cover the raw bytes of the file, compressed or not, hashed as
+// they were processed, and ModifiedAt is taken from the same open file;
+// all three are left out for standard input.
+type ManifestInput struct {
+	Name       string     `json:"name"`
+	Size       int64      `json:"size,omitempty"`
+	ModifiedAt *time.Time `json:"modified_at,omitempty"`
+	SHA256     string     `json:"sha256,omitempty"`
+}
+
+// ManifestReport describes one report file, named relative to the
+// manifest, with the eligibility thresholds its campaigns had to meet,
+// if any.
+type ManifestReport struct {
+	File        string       `json:"file"`
+	Size        int64        `json:"size"`
+	SHA256      string       `json:"sha256"`
+	Rows        int          `json:"rows"`
+	Eligibility *Eligibility `json:"eligibility,omitempty"`
+}
+
+// WithProvenance records the program version and the flags it was run
+// with in the manifest of a file report writer.
+func WithProvenance(version string, flags map[string]string) ReportOption {
+	return func(o *reportOptions) {
+		o.version = version
+		o.flags = flags
+	}
+}
+
+// manifest describes run and the staged reports.
+func (o reportOptions) manifest(run RunInfo, reports []ManifestReport) Manifest {
+	m := Manifest{
+		Version:      o.version,
+		Flags:        o.flags,
+		StartedAt:    run.Started.UTC(),
+		FinishedAt:   o.now().UTC(),
+		RowsAccepted: run.Stats.RowsAccepted,
+		RowsRejected: run.Stats.RowsRejected,
+		Violations:   run.Stats.Violations,
+		Inputs:       make([]ManifestInput, len(run.Inputs)),
+		Reports:      reports,
+	}
+	for i, name := range run.Inputs {
+		m.Inputs[i] = ManifestInput{Name: name}
+		if i < len(run.Files) {
+			m.Inputs[i] = run.Files[i]
+		}
+	}
+	return m
+}
+
+// hashedFile is an input file that hashes its raw bytes as they are
+// read. Sequential reads, the serial parser's or a decompressor's, feed
+// the hash directly. Parallel parsing reads only through ReadAt, out of
+// order, so the first ReadAt starts a goroutine that hashes the rest of
+// the file through the same handle while the workers parse it.
+type hashedFile struct {
+	*os.File
+	info fs.FileInfo
+
+	mu     sync.Mutex
+	h      hash.Hash
+	hashed int64 // length of the prefix of the file hashed so far
+	pos    int64 // file offset of the next Read
+
+	// stop is closed to stop the background hasher, and done when it
+	// has returned with err. Both are nil until it is started.
+	stop, done chan struct{}
+	stopOnce   sync.Once
+	err        error
+}
+
+func openHashedFile(path string) (*hashedFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open input: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("open input: %w", err)
+	}
+	return &hashedFile{File: f, info: info, h: sha256.New()}, nil
+}
+
+func (f *hashedFile) Read(p []byte) (int, error) {
+	n, err := f.File.Read(p)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.done == nil && f.pos <= f.hashed && f.hashed < f.pos+int64(n) {
+		f.h.Write(p[f.hashed-f.pos : n])
+		f.hashed = f.pos + int64(n)
+	}
+	f.pos += int64(n)
+	return n, err
+}
+
+func (f *hashedFile) Seek(offset int64, whence int) (int64, error) {
+	pos, err := f.File.Seek(offset, whence)
+	if err == nil {
+		f.mu.Lock()
+		f.pos = pos
+		f.mu.Unlock()
+	}
+	return pos, err
+}
+
+func (f *hashedFile) ReadAt(p []byte, off int64) (int, error) {
+	f.start()
+	return f.File.ReadAt(p, off)
+}
+
+// start starts the background hasher unless it is already running.
+// From then on it alone updates h and hashed.
+func (f *hashedFile) start() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.done != nil {
+		return
+	}
+	f.stop, f.done = make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(f.done)
+		buf := make([]byte, scanBufferSize)
+		for {
+			select {
+			case <-f.stop:
+				f.err = context.Canceled
+				return
+			default:
+			}
+			n, err := f.File.ReadAt(buf, f.hashed)
+			f.h.Write(buf[:n])
+			f.hashed += int64(n)
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				f.err = err
+				return
+			}
+		}
+	}()
+}
+
+// halt stops the background hasher, if started, and waits for it.
+func (f *hashedFile) halt() {
+	f.mu.Lock()
+	started := f.done != nil
+	f.mu.Unlock()
+	if started {
+		f.stopOnce.Do(func() { close(f.stop) })
+		<-f.done
+	}
+}
+
+func (f *hashedFile) Close() error {
+	f.halt()
+	return f.File.Close()
+}
+
+// describe finishes the hash and returns the manifest entry for the
+// file, named name. Whatever the processor did not read, such as bytes
+// after the end of a compressed stream, is hashed here; if ctx is done
+// first, describe returns ctx.Err().
+func (f *hashedFile) describe(ctx context.Context, name string) (ManifestInput, error) {
+	if err := ctx.Err(); err != nil {
+		return ManifestInput{}, err
+	}
+	f.start()
+	select {
+	case <-f.done:
+	case <-ctx.Done():
+		f.halt()
+		return ManifestInput{}, ctx.Err()
+	}
+	if f.err != nil {
+		return ManifestInput{}, fmt.Errorf("hash %s: %w", name, f.err)
+	}
+	mtime := f.info.ModTime().UTC()
+	return ManifestInput{Name: name, Size: f.hashed, ModifiedAt: &mtime, SHA256: hex.EncodeToString(f.h.Sum(nil))}, nil
+}
+
+// hashReader returns the hex SHA-256 of what remains in r and its
+// length.
+func hashReader(r io.Reader) (string, int64, error) {
+	h := sha256.New()
+	n, err := io.Copy(h, r)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+// VerifyManifest reads the manifest in dir and checks that every report
+// it lists is present with the recorded size and SHA-256.
+func VerifyManifest(dir string) (*Manifest, error) {
+	m, err := readManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, rep := range m.Reports {
+		f, err := os.Open(filepath.Join(dir, rep.File))
+		if err != nil {
+			return nil, err
+		}
+		sum, size, err := hashReader(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", rep.File, err)
+		}
+		if size != rep.Size || sum != rep.SHA256 {
+			return nil, fmt.Errorf("%s does not match %s: got %d bytes with sha256 %s, want %d bytes with sha256 %s",
+				rep.File, ManifestName, size, sum, rep.Size, rep.SHA256)
+		}
+	}
+	return m, nil
+}
+
+// readManifest reads the manifest in dir without checking the reports.
+func readManifest(dir string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ManifestName))
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("read %s: %w", ManifestName, err)
+	}
+	return &m, nil
+}
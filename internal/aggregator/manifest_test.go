@@ -0,0 +1,172 @@
+package aggregator
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileReportWriter_Manifest(t *testing.T) {
+	in := filepath.Join(t.TempDir(), "ad_data.csv")
+	input := "campaign_id,impressions,clicks,spend,conversions\ncamp1,1000,50,10.00,5\ncamp2,1000,5,1.00,0\ncamp3,bad,1,1.00,1\n"
+	if err := os.WriteFile(in, []byte(input), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2026, 10, 15, 8, 30, 0, 0, time.UTC)
+	if err := os.Chtimes(in, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	flags := map[string]string{"input": in, "on-error": "skip"}
+	w := NewFileReportWriter(dir, 10, fixedClock, WithProvenance("v1.4.0", flags))
+	p := NewCSVProcessor(WithErrorPolicy(ErrorPolicy{Mode: SkipOnError}))
+	before := time.Now().UTC()
+	if _, err := NewService(p, w).RunInputs(context.Background(), []Input{FileInput(in)}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	m, err := VerifyManifest(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if m.Version != "v1.4.0" || !reflect.DeepEqual(m.Flags, flags) {
+		t.Errorf("got version %q and flags %v", m.Version, m.Flags)
+	}
+	var raw map[string]any
+	data, err := os.ReadFile(filepath.Join(dir, ManifestName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		t.Fatal(err)
+	}
+	if raw["rows_accepted"] != 2.0 || raw["rows_rejected"] != 1.0 {
+		t.Errorf("got rows_accepted %v and rows_rejected %v, want 2 and 1", raw["rows_accepted"], raw["rows_rejected"])
+	}
+	if m.RowsAccepted != 2 || m.RowsRejected != 1 {
+		t.Errorf("got %d rows and %d rejected, want 2 and 1", m.RowsAccepted, m.RowsRejected)
+	}
+	if m.StartedAt.Before(before.Truncate(time.Second)) || !m.FinishedAt.Equal(time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("got started %v and finished %v", m.StartedAt, m.FinishedAt)
+	}
+
+	sum := sha256.Sum256([]byte(input))
+	wantInput := ManifestInput{Name: in, Size: int64(len(input)), ModifiedAt: &mtime, SHA256: hex.EncodeToString(sum[:])}
+	if len(m.Inputs) != 1 || !reflect.DeepEqual(m.Inputs[0], wantInput) {
+		t.Errorf("got inputs %+v, want %+v", m.Inputs, wantInput)
+	}
+
+	if len(m.Reports) != 2 {
+		t.Fatalf("got %d reports, want 2", len(m.Reports))
+	}
+	for _, rep := range m.Reports {
+		data, err := os.ReadFile(filepath.Join(dir, rep.File))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum := sha256.Sum256(data)
+		if rep.SHA256 != hex.EncodeToString(sum[:]) || rep.Size != int64(len(data)) {
+			t.Errorf("%s: recorded sha256 %s and size %d do not match the file", rep.File, rep.SHA256, rep.Size)
+		}
+		if rows := strings.Count(string(data), "\n") - 1; rep.Rows != rows {
+			t.Errorf("%s: got %d rows, want %d", rep.File, rep.Rows, rows)
+		}
+	}
+	if m.Reports[0].File != "top10_ctr.csv" || m.Reports[1].File != "top10_cpa.csv" {
+		t.Errorf("got reports %+v", m.Reports)
+	}
+}
+
+func TestService_ManifestHashesInputsAsRead(t *testing.T) {
+	for _, name := range []string{"ad_data.csv", "ad_data.csv.gz", "ad_data.csv.bz2", "ad_data.csv.zst"} {
+		raw, err := os.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			t.Fatalf("read fixture: %v", err)
+		}
+		sum := sha256.Sum256(raw)
+		for _, workers := range []int{1, 4} {
+			dir := t.TempDir()
+			in := FileInput(filepath.Join("testdata", name))
+			s := NewService(NewCSVProcessor(WithWorkers(workers)), NewFileReportWriter(dir, 10))
+			if _, err := s.RunInputs(context.Background(), []Input{in}); err != nil {
+				t.Fatalf("%s, workers=%d: unexpected error: %v", name, workers, err)
+			}
+			m, err := VerifyManifest(dir)
+			if err != nil {
+				t.Fatalf("%s, workers=%d: unexpected error: %v", name, workers, err)
+			}
+			got := m.Inputs[0]
+			if got.SHA256 != hex.EncodeToString(sum[:]) || got.Size != int64(len(raw)) {
+				t.Errorf("%s, workers=%d: got %d bytes with sha256 %s, want %d bytes with sha256 %x",
+					name, workers, got.Size, got.SHA256, len(raw), sum)
+			}
+		}
+	}
+}
+
+func TestHashedFile_DescribeCancelled(t *testing.T) {
+	f, err := openHashedFile(filepath.Join("testdata", "ad_data.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := f.describe(ctx, "ad_data.csv"); !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, want context.Canceled", err)
+	}
+}
+
+func TestFileReportWriter_ManifestStdin(t *testing.T) {
+	dir := t.TempDir()
+	stdin := Input{Name: "stdin", Open: func() (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader("campaign_id,impressions,clicks,spend,conversions\ncamp1,10,1,1.00,1\n")), nil
+	}}
+	if _, err := NewService(NewCSVProcessor(), NewFileReportWriter(dir, 10)).RunInputs(context.Background(), []Input{stdin}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, ManifestName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m struct {
+		Inputs []map[string]any `json:"inputs"`
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatal(err)
+	}
+	if want := []map[string]any{{"name": "stdin"}}; !reflect.DeepEqual(m.Inputs, want) {
+		t.Errorf("got inputs %v, want %v", m.Inputs, want)
+	}
+}
+
+func TestVerifyManifest_Mismatch(t *testing.T) {
+	store := NewInMemoryMetricsStore()
+	store.Add("camp1", 1000, 50, amount("10"), 5)
+	dir := t.TempDir()
+	if err := NewFileReportWriter(dir, 10).WriteReports(context.Background(), store, RunInfo{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "top10_cpa.csv"), []byte("truncated"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := VerifyManifest(dir)
+	if err == nil || !strings.HasPrefix(err.Error(), "top10_cpa.csv does not match manifest.json") {
+		t.Errorf("got error %v, want a top10_cpa.csv mismatch", err)
+	}
+
+	os.Remove(filepath.Join(dir, "top10_ctr.csv"))
+	if _, err := VerifyManifest(dir); !os.IsNotExist(err) {
+		t.Errorf("got error %v, want a missing file", err)
+	}
+}
@@ -1,16 +1,46 @@
 package aggregator
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
-// CampaignMetrics holds the running totals for a single campaign_id.
-// All fields are accumulated during the streaming pass; derived metrics
-// (CTR, CPA) are computed on demand after aggregation is complete.
+// GroupKey identifies one aggregation group: the values of the group-by
+// columns in order, joined by keySeparator. With the default single
+// campaign_id column a GroupKey is just the campaign ID.
+type GroupKey string
+
+const keySeparator = "\x00"
+
+// NewGroupKey builds the key for the given group-by column values,
+// which must not contain keySeparator; the processor rejects rows whose
+// key fields do.
+func NewGroupKey(values ...string) GroupKey {
+	return GroupKey(strings.Join(values, keySeparator))
+}
+
+// Values splits the key back into its group-by column values.
+func (k GroupKey) Values() []string {
+	return strings.Split(string(k), keySeparator)
+}
+
+func (k GroupKey) String() string {
+	return strings.Join(k.Values(), "/")
+}
+
+// CampaignMetrics holds the running totals for a single group key
+// (by default a campaign_id). All fields are accumulated during the
+// streaming pass; derived metrics (CTR, CPA, ...) are computed on demand
+// after aggregation is complete. TotalRevenue is only meaningful when
+// HasRevenue is set, i.e. when the input has a revenue column.
 type CampaignMetrics struct {
-	CampaignID       string
+	Key              GroupKey
 	TotalImpressions int64
 	TotalClicks      int64
-	TotalSpend       float64
+	TotalSpend       Money
 	TotalConversions int64
+	TotalRevenue     Money
+	HasRevenue       bool
 }
 
 // CTR returns the click-through rate (clicks / impressions).
@@ -29,11 +59,65 @@ func (m *CampaignMetrics) CPA() float64 {
 	if m.TotalConversions == 0 {
 		return 0
 	}
-	return m.TotalSpend / float64(m.TotalConversions)
+	return m.TotalSpend.Float64() / float64(m.TotalConversions)
+}
+
+// CPC returns cost per click (spend / clicks).
+// Returns 0 if there are no clicks.
+func (m *CampaignMetrics) CPC() float64 {
+	if m.TotalClicks == 0 {
+		return 0
+	}
+	return m.TotalSpend.Float64() / float64(m.TotalClicks)
+}
+
+// CPM returns cost per thousand impressions (spend / impressions * 1000).
+// Returns 0 if there are no impressions.
+func (m *CampaignMetrics) CPM() float64 {
+	if m.TotalImpressions == 0 {
+		return 0
+	}
+	return m.TotalSpend.Float64() / float64(m.TotalImpressions) * 1000
+}
+
+// CVR returns the conversion rate (conversions / clicks).
+// Returns 0 if there are no clicks.
+func (m *CampaignMetrics) CVR() float64 {
+	if m.TotalClicks == 0 {
+		return 0
+	}
+	return float64(m.TotalConversions) / float64(m.TotalClicks)
+}
+
+// ROAS returns the return on ad spend (revenue / spend).
+// Returns 0 if there is no spend or no revenue column.
+func (m *CampaignMetrics) ROAS() float64 {
+	if m.TotalSpend == 0 || !m.HasRevenue {
+		return 0
+	}
+	return m.TotalRevenue.Float64() / m.TotalSpend.Float64()
+}
+
+// Profit returns revenue minus spend, subtracted exactly and converted
+// to float64 once. Returns 0 if there is no revenue column.
+func (m *CampaignMetrics) Profit() float64 {
+	if !m.HasRevenue {
+		return 0
+	}
+	if p, ok := m.exactProfit(); ok {
+		return p.Float64()
+	}
+	return m.TotalRevenue.Float64() - m.TotalSpend.Float64()
+}
+
+// exactProfit returns revenue minus spend as Money, or false if the
+// difference is beyond ±MaxMoney.
+func (m *CampaignMetrics) exactProfit() (Money, bool) {
+	return subMoney(m.TotalRevenue, m.TotalSpend)
 }
 
 func (m *CampaignMetrics) String() string {
-	return fmt.Sprintf("campaign=%s imp=%d click=%d spend=%.2f conv=%d ctr=%.6f cpa=%.2f",
-		m.CampaignID, m.TotalImpressions, m.TotalClicks, m.TotalSpend,
+	return fmt.Sprintf("key=%s imp=%d click=%d spend=%s conv=%d ctr=%.6f cpa=%.2f",
+		m.Key, m.TotalImpressions, m.TotalClicks, m.TotalSpend,
 		m.TotalConversions, m.CTR(), m.CPA())
 }
@@ -0,0 +1,61 @@
+package aggregator
+
+import "testing"
+
+func TestCampaignMetrics_DerivedMetrics(t *testing.T) {
+	m := &CampaignMetrics{
+		TotalImpressions: 2000, TotalClicks: 100, TotalSpend: amount("50"),
+		TotalConversions: 5, TotalRevenue: amount("125"), HasRevenue: true,
+	}
+	for name, c := range map[string]struct{ got, want float64 }{
+		"CPC":    {m.CPC(), 0.5},
+		"CPM":    {m.CPM(), 25},
+		"CVR":    {m.CVR(), 0.05},
+		"ROAS":   {m.ROAS(), 2.5},
+		"Profit": {m.Profit(), 75},
+	} {
+		if c.got != c.want {
+			t.Errorf("%s: got %v, want %v", name, c.got, c.want)
+		}
+	}
+}
+
+func TestCampaignMetrics_ZeroDenominators(t *testing.T) {
+	cases := map[string]*CampaignMetrics{
+		"empty":      {},
+		"no revenue": {TotalImpressions: 10, TotalClicks: 2, TotalSpend: amount("5"), TotalRevenue: amount("99")},
+		"no spend":   {TotalRevenue: amount("10"), HasRevenue: true},
+	}
+	for name, m := range cases {
+		if name != "no revenue" && (m.CPC() != 0 || m.CPM() != 0 || m.CVR() != 0) {
+			t.Errorf("%s: CPC/CPM/CVR = %v/%v/%v, want 0", name, m.CPC(), m.CPM(), m.CVR())
+		}
+		if m.ROAS() != 0 {
+			t.Errorf("%s: ROAS = %v, want 0", name, m.ROAS())
+		}
+	}
+	if p := cases["no revenue"].Profit(); p != 0 {
+		t.Errorf("Profit without revenue column = %v, want 0", p)
+	}
+	if p := cases["no spend"].Profit(); p != 10 {
+		t.Errorf("Profit without spend = %v, want 10", p)
+	}
+}
+
+// TestCampaignMetrics_ProfitExact subtracts amounts whose float64
+// difference drifts: 0.3 - 0.1 is 0.19999999999999998 in float64.
+func TestCampaignMetrics_ProfitExact(t *testing.T) {
+	m := &CampaignMetrics{TotalSpend: amount("0.1"), TotalRevenue: amount("0.3"), HasRevenue: true}
+	if p := m.Profit(); p != 0.2 {
+		t.Errorf("Profit = %v, want 0.2", p)
+	}
+	if v := profitValue(m); v != amount("0.2") {
+		t.Errorf("profit column = %v, want exactly 0.2", v)
+	}
+
+	// Beyond ±MaxMoney the difference is only approximated.
+	m = &CampaignMetrics{TotalSpend: -MaxMoney, TotalRevenue: MaxMoney, HasRevenue: true}
+	if v, ok := profitValue(m).(float64); !ok || v != 2*MaxMoney.Float64() {
+		t.Errorf("profit column = %v, want the float64 approximation", profitValue(m))
+	}
+}
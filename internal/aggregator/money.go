@@ -0,0 +1,220 @@
+package aggregator
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Money is an exact amount of currency in micros, millionths of a unit.
+// Spend and revenue are parsed from the input text straight into Money
+// and summed as integers, so totals do not drift however many rows are
+// added; only derived metrics such as CPA are computed in float64.
+//
+// The range is ±MaxMoney, about ±9.2 trillion units. Parsing a larger
+// value or summing past it is an error rather than a wrap-around.
+type Money int64
+
+const (
+	// MoneyScale is the number of decimal places Money holds.
+	MoneyScale = 6
+	// MaxMoney is the largest amount; -MaxMoney is the smallest.
+	MaxMoney Money = math.MaxInt64
+
+	microsPerUnit = 1_000_000
+	// maxExactMicros bounds the amounts float64 holds exactly.
+	maxExactMicros = 1 << 53
+)
+
+// ErrMoneyOverflow reports an amount outside ±MaxMoney.
+var ErrMoneyOverflow = errors.New("amount out of range")
+
+// ParseMoney parses plain decimal text, such as "12.34", "-0.5" or
+// "1.5e3", into Money. Digits past the sixth decimal place are rounded
+// half to even. Use a NumberFormat for other notations.
+func ParseMoney(s string) (Money, error) {
+	return PlainNumbers.money(s)
+}
+
+// money reads s, a number in format f, as Money. The error names f.
+func (f NumberFormat) money(s string) (Money, error) {
+	text, err := f.normalize(s)
+	if err != nil {
+		return 0, err
+	}
+	m, err := parseMicros(text)
+	if err != nil {
+		return 0, fmt.Errorf("number format %s: %w", f, err)
+	}
+	return m, nil
+}
+
+// parseMicros converts the output of NumberFormat.normalize, an optional
+// sign, digits with an optional '.' and an optional exponent, to Money
+// without going through float64.
+func parseMicros(text string) (Money, error) {
+	neg := false
+	if text != "" && (text[0] == '-' || text[0] == '+') {
+		neg = text[0] == '-'
+		text = text[1:]
+	}
+	mantissa, expText, hasExp := strings.Cut(text, "e")
+	intPart, fracPart, _ := strings.Cut(mantissa, ".")
+	digits := strings.TrimLeft(intPart+fracPart, "0")
+	if digits == "" {
+		return 0, nil
+	}
+	// point is the number of digits left of the decimal point once the
+	// value is scaled to micros.
+	point := len(intPart) - (len(intPart+fracPart) - len(digits)) + MoneyScale
+	if hasExp {
+		exp, err := strconv.Atoi(expText)
+		switch {
+		case err != nil && strings.HasPrefix(expText, "-"):
+			return 0, nil // far below a micro
+		case err != nil || exp > 100:
+			return 0, ErrMoneyOverflow
+		case exp < -100:
+			return 0, nil
+		}
+		point += exp
+	}
+	if point > len(strconv.FormatInt(math.MaxInt64, 10)) {
+		return 0, ErrMoneyOverflow
+	}
+
+	var whole uint64
+	var rest string // digits below a micro
+	if point > 0 {
+		head := digits
+		if point < len(digits) {
+			head, rest = digits[:point], digits[point:]
+		} else {
+			head += strings.Repeat("0", point-len(digits))
+		}
+		var err error
+		if whole, err = strconv.ParseUint(head, 10, 64); err != nil {
+			return 0, ErrMoneyOverflow
+		}
+	} else {
+		rest = strings.Repeat("0", -point) + digits
+	}
+	// Round half to even on the first dropped digit.
+	if rest != "" && (rest[0] > '5' || rest[0] == '5' && (strings.TrimRight(rest[1:], "0") != "" || whole%2 == 1)) {
+		whole++
+	}
+	if whole > uint64(MaxMoney) {
+		return 0, ErrMoneyOverflow
+	}
+	if neg {
+		return -Money(whole), nil
+	}
+	return Money(whole), nil
+}
+
+// addMoney returns a+b, or false if the sum is outside ±MaxMoney.
+func addMoney(a, b Money) (Money, bool) {
+	sum := a + b
+	if (a > 0 && b > 0 && sum < 0) || (a < 0 && b < 0 && sum >= 0) || sum == math.MinInt64 {
+		return 0, false
+	}
+	return sum, true
+}
+
+// subMoney returns a-b, or false if the difference is outside
+// ±MaxMoney.
+func subMoney(a, b Money) (Money, bool) {
+	if b == math.MinInt64 {
+		return 0, false
+	}
+	return addMoney(a, -b)
+}
+
+// Float64 returns m in units as the float64 nearest to its exact value.
+func (m Money) Float64() float64 {
+	if -maxExactMicros <= m && m <= maxExactMicros {
+		// Both operands are exact, so the quotient is correctly rounded.
+		return float64(m) / microsPerUnit
+	}
+	f, _ := strconv.ParseFloat(m.String(), 64)
+	return f
+}
+
+// String returns the exact decimal value of m in units, without
+// trailing zeros: "12.5", "-0.000001", "3".
+func (m Money) String() string {
+	s := m.Format(MoneyScale)
+	if strings.Contains(s, ".") {
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+	return s
+}
+
+// Format returns m in units with digits decimal places, rounding half
+// away from zero when digits is below MoneyScale and padding with
+// zeros above it.
+func (m Money) Format(digits int) string {
+	digits = max(digits, 0)
+	shown := min(digits, MoneyScale)
+	abs := uint64(m)
+	if m < 0 {
+		abs = uint64(-m)
+	}
+	drop := pow10(MoneyScale - shown)
+	q := abs / drop // in units of 10^-shown
+	if r := abs % drop; drop > 1 && r*2 >= drop {
+		q++
+	}
+	scale := pow10(shown)
+
+	var b strings.Builder
+	if m < 0 && q != 0 {
+		b.WriteByte('-')
+	}
+	b.WriteString(strconv.FormatUint(q/scale, 10))
+	if digits > 0 {
+		b.WriteByte('.')
+		if shown > 0 {
+			frac := strconv.FormatUint(q%scale, 10)
+			b.WriteString(strings.Repeat("0", shown-len(frac)))
+			b.WriteString(frac)
+		}
+		b.WriteString(strings.Repeat("0", digits-shown))
+	}
+	return b.String()
+}
+
+func pow10(n int) uint64 {
+	p := uint64(1)
+	for ; n > 0; n-- {
+		p *= 10
+	}
+	return p
+}
+
+// MarshalJSON writes m as an exact JSON number.
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalJSON reads a JSON number exactly, as ParseMoney does.
+func (m *Money) UnmarshalJSON(b []byte) error {
+	v, err := ParseMoney(string(b))
+	if err != nil {
+		return fmt.Errorf("invalid amount %s: %w", b, err)
+	}
+	*m = v
+	return nil
+}
+
+// Set parses a flag value with ParseMoney, so Money can be a flag.Value.
+func (m *Money) Set(s string) error {
+	v, err := ParseMoney(s)
+	if err != nil {
+		return err
+	}
+	*m = v
+	return nil
+}
@@ -0,0 +1,286 @@
+package aggregator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// amount parses a test fixture with ParseMoney.
+func amount(s string) Money {
+	m, err := ParseMoney(s)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Money
+	}{
+		{"0", 0},
+		{"-0.00", 0},
+		{"12.34", 12_340_000},
+		{"-12.34", -12_340_000},
+		{"+0.1", 100_000},
+		{".5", 500_000},
+		{"5.", 5_000_000},
+		{"0.000001", 1},
+		{"000123.000456", 123_000_456},
+		{"1.5e3", 1_500_000_000},
+		{"15e-7", 2},           // 1.5 micros, half to even
+		{"25e-7", 2},           // 2.5 micros, half to even
+		{"0.0000015000001", 2}, // above the half
+		{"0.0000025000001", 3},
+		{"0.0000004999999", 0},
+		{"-0.0000005", 0},
+		{"-0.0000015", -2},
+		{"1e-400", 0},
+		{"0e999999999999", 0},
+		{"9223372036854.775807", MaxMoney},
+		{"-9223372036854.775807", -MaxMoney},
+		{"9223372036854.7758074", MaxMoney},
+		{"9.223372036854775807e12", MaxMoney},
+	}
+	for _, tt := range tests {
+		got, err := ParseMoney(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseMoney(%q) = %d, %v; want %d", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseMoneyOverflow(t *testing.T) {
+	for _, in := range []string{
+		"9223372036854.775808",
+		"9223372036854.7758075", // rounds up past the maximum
+		"-9223372036854.775808",
+		"10000000000000",
+		"1e13",
+		"1e999999999999",
+		"99999999999999999999999999",
+	} {
+		if _, err := ParseMoney(in); !errors.Is(err, ErrMoneyOverflow) {
+			t.Errorf("ParseMoney(%q): got %v, want ErrMoneyOverflow", in, err)
+		}
+	}
+}
+
+// TestParseMoneyMatchesDecimalText checks random amounts with up to six
+// decimals round-trip exactly through text, where float64 would not.
+func TestParseMoneyMatchesDecimalText(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		want := Money(rng.Int63n(int64(MaxMoney)))
+		if i%2 == 1 {
+			want = -want
+		}
+		got, err := ParseMoney(want.String())
+		if err != nil || got != want {
+			t.Fatalf("ParseMoney(%q) = %d, %v; want %d", want.String(), got, err, want)
+		}
+	}
+}
+
+func TestMoneyFormat(t *testing.T) {
+	tests := []struct {
+		m      Money
+		digits int
+		want   string
+	}{
+		{0, 2, "0.00"},
+		{12_345_678, 2, "12.35"},
+		{12_345_000, 2, "12.35"}, // half away from zero
+		{-12_345_000, 2, "-12.35"},
+		{12_344_999, 2, "12.34"},
+		{-4_000, 2, "0.00"}, // no negative zero
+		{-5_000, 2, "-0.01"},
+		{12_345_678, 0, "12"},
+		{12_500_000, 0, "13"},
+		{12_345_678, 6, "12.345678"},
+		{12_345_678, 8, "12.34567800"},
+		{1, 6, "0.000001"},
+		{MaxMoney, 6, "9223372036854.775807"},
+		{-MaxMoney, 2, "-9223372036854.78"},
+		{999_995_000, 2, "1000.00"}, // carry into the units
+	}
+	for _, tt := range tests {
+		if got := tt.m.Format(tt.digits); got != tt.want {
+			t.Errorf("Money(%d).Format(%d) = %q, want %q", tt.m, tt.digits, got, tt.want)
+		}
+	}
+	for m, want := range map[Money]string{0: "0", 12_500_000: "12.5", -1: "-0.000001", 3_000_000: "3"} {
+		if got := m.String(); got != want {
+			t.Errorf("Money(%d).String() = %q, want %q", m, got, want)
+		}
+	}
+}
+
+func TestMoneyFloat64(t *testing.T) {
+	for _, m := range []Money{0, 1, 12_340_000, -12_340_000, 1 << 53, 1<<53 + 1, MaxMoney, -MaxMoney} {
+		want, _ := strconv.ParseFloat(m.String(), 64)
+		if got := m.Float64(); got != want {
+			t.Errorf("Money(%d).Float64() = %v, want %v", m, got, want)
+		}
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	var e Eligibility
+	if err := e.MinSpend.UnmarshalJSON([]byte("1e+21")); !errors.Is(err, ErrMoneyOverflow) {
+		t.Errorf("got %v, want overflow", err)
+	}
+	if err := e.MinSpend.UnmarshalJSON([]byte("0.1")); err != nil || e.MinSpend != 100_000 {
+		t.Errorf("got %d, %v", e.MinSpend, err)
+	}
+	b, _ := e.MinSpend.MarshalJSON()
+	if string(b) != "0.1" {
+		t.Errorf("MarshalJSON = %s, want 0.1", b)
+	}
+}
+
+func TestAddMoney(t *testing.T) {
+	tests := []struct {
+		a, b Money
+		ok   bool
+	}{
+		{1, 2, true},
+		{MaxMoney, 0, true},
+		{MaxMoney, 1, false},
+		{MaxMoney, -MaxMoney, true},
+		{-MaxMoney, -1, false},
+		{-MaxMoney, 0, true},
+	}
+	for _, tt := range tests {
+		if _, ok := addMoney(tt.a, tt.b); ok != tt.ok {
+			t.Errorf("addMoney(%d, %d): ok = %v, want %v", tt.a, tt.b, ok, tt.ok)
+		}
+	}
+}
+
+func TestSubMoney(t *testing.T) {
+	tests := []struct {
+		a, b Money
+		want Money
+		ok   bool
+	}{
+		{amount("0.3"), amount("0.1"), amount("0.2"), true},
+		{0, MaxMoney, -MaxMoney, true},
+		{-1, MaxMoney, 0, false},
+		{MaxMoney, -1, 0, false},
+		{-MaxMoney, -MaxMoney, 0, true},
+	}
+	for _, tt := range tests {
+		if got, ok := subMoney(tt.a, tt.b); got != tt.want || ok != tt.ok {
+			t.Errorf("subMoney(%d, %d) = %d, %v, want %d, %v", tt.a, tt.b, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+// TestStoreSumsExactly adds amounts whose float64 sum drifts: a million
+// rows of 0.1 is 100000 exactly, while float64 gives 100000.00000133288.
+func TestStoreSumsExactly(t *testing.T) {
+	s := NewInMemoryMetricsStore()
+	var f float64
+	for i := 0; i < 1_000_000; i++ {
+		s.Add("camp", 1, 0, amount("0.1"), 0)
+		f += 0.1
+	}
+	if f == 100000 {
+		t.Fatal("float64 sum unexpectedly exact; the test proves nothing")
+	}
+	if got := s.TopKByCTR(1)[0].TotalSpend; got != amount("100000") {
+		t.Errorf("spend = %s, want 100000", got)
+	}
+}
+
+// TestStoreSumsAdversarial mixes huge and tiny amounts of both signs,
+// which float64 cannot sum in any order without losing the cents.
+func TestStoreSumsAdversarial(t *testing.T) {
+	rows := []string{"9007199254740.993", "0.01", "-9007199254740.993", "0.01", "1e12", "0.000001", "-1e12", "0.03"}
+	s := NewInMemoryMetricsStore()
+	var f float64
+	for _, r := range rows {
+		s.Add("camp", 1, 0, amount(r), 0)
+		v, _ := strconv.ParseFloat(r, 64)
+		f += v
+	}
+	m := s.TopKByCTR(1)[0]
+	if got := m.TotalSpend.Format(6); got != "0.050001" {
+		t.Errorf("spend = %s, want 0.050001 (float64 gives %v)", got, f)
+	}
+}
+
+func TestStoreOverflow(t *testing.T) {
+	s := NewInMemoryMetricsStore()
+	if err := s.Add("camp", 1, 1, MaxMoney, 1); err != nil {
+		t.Fatal(err)
+	}
+	err := s.Add("camp", 1, 1, 1, 1)
+	if !errors.Is(err, ErrMoneyOverflow) || !strings.Contains(err.Error(), "total spend of camp") {
+		t.Fatalf("got %v, want a spend overflow", err)
+	}
+	m := s.TopKByCTR(1)[0]
+	if m.TotalSpend != MaxMoney || m.TotalImpressions != 1 {
+		t.Errorf("a failed Add changed the group: %v", m)
+	}
+	if err := s.AddRevenue("camp", -MaxMoney); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddRevenue("camp", -1); !errors.Is(err, ErrMoneyOverflow) {
+		t.Errorf("got %v, want a revenue overflow", err)
+	}
+}
+
+func TestCSVProcessor_ExactSpend(t *testing.T) {
+	var b strings.Builder
+	b.WriteString("campaign_id,impressions,clicks,spend,conversions\n")
+	for i := 0; i < 100000; i++ {
+		fmt.Fprintf(&b, "camp%d,1,0,%s,0\n", i%3, []string{"0.1", "0.2", "0.3", "1e-6", "19.99"}[i%5])
+	}
+	input := b.String()
+	want := map[string]string{}
+	for _, workers := range []int{1, 4} {
+		store := NewInMemoryMetricsStore()
+		p := NewCSVProcessor(WithWorkers(workers))
+		if _, err := p.Process(context.Background(), strings.NewReader(input), store); err != nil {
+			t.Fatal(err)
+		}
+		var total Money
+		store.Each(func(m *CampaignMetrics) {
+			total += m.TotalSpend
+			if workers == 1 {
+				want[string(m.Key)] = m.TotalSpend.String()
+			} else if got := m.TotalSpend.String(); got != want[string(m.Key)] {
+				t.Errorf("workers=%d %s: spend %s, serial %s", workers, m.Key, got, want[string(m.Key)])
+			}
+		})
+		// 20000 rows of each amount: 20000 * 20.590001 = 411800.02.
+		if total != amount("411800.02") {
+			t.Errorf("workers=%d: total %s, want 411800.02", workers, total)
+		}
+	}
+}
+
+func TestCSVProcessor_SpendOverflowEndsRun(t *testing.T) {
+	input := "campaign_id,impressions,clicks,spend,conversions\n" +
+		"camp1,1,1,9000000000000,1\n" +
+		"camp1,1,1,9000000000000,1\n"
+	p := NewCSVProcessor(WithErrorPolicy(ErrorPolicy{Mode: SkipOnError}))
+	_, err := p.Process(context.Background(), strings.NewReader(input), NewInMemoryMetricsStore())
+	if !errors.Is(err, ErrMoneyOverflow) {
+		t.Fatalf("got %v, want an overflow error even when skipping bad rows", err)
+	}
+
+	input = "campaign_id,impressions,clicks,spend,conversions\ncamp1,1,1,1e13,1\n"
+	_, err = NewCSVProcessor().Process(context.Background(), strings.NewReader(input), NewInMemoryMetricsStore())
+	if err == nil || err.Error() != `line 2: bad spend "1e13": number format plain: amount out of range` {
+		t.Errorf("got %v", err)
+	}
+}
@@ -0,0 +1,268 @@
+package aggregator
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// NumberFormat is the policy for reading the money columns, spend and
+// revenue: which characters separate decimals and thousands, whether a
+// currency symbol may surround the number and whether scientific
+// notation is accepted. Count columns are always plain integers.
+type NumberFormat struct {
+	// Name identifies the format in row errors.
+	Name string
+	// Decimal separates the fraction, '.' when zero.
+	Decimal rune
+	// Thousands separates digit groups of the integer part, which must
+	// then be groups of three. Zero allows no separator.
+	Thousands rune
+	// Currency strips one currency symbol (any Unicode currency sign,
+	// such as $ or €) before or after the number.
+	Currency bool
+	// Scientific accepts an exponent such as 1.5e3.
+	Scientific bool
+}
+
+// Number format presets, selected by name with ParseNumberFormat.
+var (
+	// PlainNumbers is the default: 1234.56 or 1.23456e3, nothing else.
+	PlainNumbers = NumberFormat{Name: "plain", Decimal: '.', Scientific: true}
+	// USNumbers reads $1,234.56 and 1234.56.
+	USNumbers = NumberFormat{Name: "us", Decimal: '.', Thousands: ',', Currency: true}
+	// EUNumbers reads 1.234,56 € and 1234,56.
+	EUNumbers = NumberFormat{Name: "eu", Decimal: ',', Thousands: '.', Currency: true}
+)
+
+var numberPresets = []NumberFormat{PlainNumbers, USNumbers, EUNumbers}
+
+// String returns the name of f.
+func (f NumberFormat) String() string {
+	if f.Name == "" {
+		return "custom"
+	}
+	return f.Name
+}
+
+// ParseNumberFormat parses a --number-format value: a preset (plain, us
+// or eu), optionally followed by space-separated overrides, or the
+// overrides alone on top of plain:
+//
+//	decimal=<char>              the decimal separator
+//	thousands=<char>|space|none the thousands separator
+//	currency=on|off             strip a currency symbol
+//	scientific=on|off           accept exponents
+//
+// For example "eu currency=off" or "decimal=, thousands=space". The
+// value itself becomes the name of the format.
+func ParseNumberFormat(s string) (NumberFormat, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return NumberFormat{}, fmt.Errorf("invalid number format %q; want plain, us, eu or key=value overrides", s)
+	}
+	f := PlainNumbers
+	if !strings.Contains(fields[0], "=") {
+		preset, ok := lookupNumberPreset(fields[0])
+		if !ok {
+			return NumberFormat{}, fmt.Errorf("invalid number format %q: unknown preset %q; want plain, us or eu", s, fields[0])
+		}
+		f = preset
+		fields = fields[1:]
+	}
+	for _, field := range fields {
+		if err := f.set(field); err != nil {
+			return NumberFormat{}, fmt.Errorf("invalid number format %q: %w", s, err)
+		}
+	}
+	if f.Decimal == f.Thousands {
+		return NumberFormat{}, fmt.Errorf("invalid number format %q: decimal and thousands separators are both %q", s, f.Decimal)
+	}
+	f.Name = strings.Join(strings.Fields(s), " ")
+	return f, nil
+}
+
+func lookupNumberPreset(name string) (NumberFormat, bool) {
+	for _, f := range numberPresets {
+		if strings.EqualFold(f.Name, name) {
+			return f, true
+		}
+	}
+	return NumberFormat{}, false
+}
+
+// set applies one key=value override.
+func (f *NumberFormat) set(field string) error {
+	key, value, ok := strings.Cut(field, "=")
+	if !ok {
+		return fmt.Errorf("%q is not key=value", field)
+	}
+	switch strings.ToLower(key) {
+	case "decimal":
+		r, err := numberSeparator(value)
+		if err != nil {
+			return fmt.Errorf("decimal: %w", err)
+		}
+		f.Decimal = r
+	case "thousands":
+		switch strings.ToLower(value) {
+		case "none":
+			f.Thousands = 0
+			return nil
+		case "space":
+			f.Thousands = ' '
+			return nil
+		}
+		r, err := numberSeparator(value)
+		if err != nil {
+			return fmt.Errorf("thousands: %w", err)
+		}
+		f.Thousands = r
+	case "currency", "scientific":
+		var on bool
+		switch strings.ToLower(value) {
+		case "on", "true", "yes":
+			on = true
+		case "off", "false", "no":
+		default:
+			return fmt.Errorf("%s: want on or off, got %q", key, value)
+		}
+		if strings.EqualFold(key, "currency") {
+			f.Currency = on
+		} else {
+			f.Scientific = on
+		}
+	default:
+		return fmt.Errorf("unknown key %q; want decimal, thousands, currency or scientific", key)
+	}
+	return nil
+}
+
+// numberSeparator accepts a single character that cannot be part of a
+// number otherwise.
+func numberSeparator(s string) (rune, error) {
+	r, size := utf8.DecodeRuneInString(s)
+	switch {
+	case s == "" || size != len(s):
+		return 0, fmt.Errorf("want a single character, got %q", s)
+	case unicode.IsDigit(r) || r == '+' || r == '-' || r == 'e' || r == 'E' || isCurrencySymbol(r):
+		return 0, fmt.Errorf("%q cannot be a separator", r)
+	}
+	return r, nil
+}
+
+// normalize rewrites s, a number in format f, as plain decimal text
+// with an optional sign, '.' and exponent. The error names f and what
+// it does not allow.
+func (f NumberFormat) normalize(s string) (string, error) {
+	fail := func(format string, args ...any) (string, error) {
+		return "", fmt.Errorf("number format %s: %s", f, fmt.Sprintf(format, args...))
+	}
+	decimal := f.Decimal
+	if decimal == 0 {
+		decimal = '.'
+	}
+
+	s = strings.TrimSpace(s)
+	var sign string
+	takeSign := func() {
+		if sign == "" && s != "" && (s[0] == '-' || s[0] == '+') {
+			sign, s = s[:1], s[1:]
+		}
+	}
+	takeSign()
+	if f.Currency {
+		if r, size := utf8.DecodeRuneInString(s); isCurrencySymbol(r) {
+			s = strings.TrimSpace(s[size:])
+			takeSign()
+		} else if r, size := utf8.DecodeLastRuneInString(s); isCurrencySymbol(r) {
+			s = strings.TrimSpace(s[:len(s)-size])
+		}
+	}
+	if i := strings.IndexFunc(s, isCurrencySymbol); i >= 0 {
+		r, _ := utf8.DecodeRuneInString(s[i:])
+		if !f.Currency {
+			return fail("currency symbol %q is not allowed", r)
+		}
+		return fail("currency symbol %q must come before or after the number", r)
+	}
+
+	var b strings.Builder
+	b.WriteString(sign)
+	// Integer part, with thousands separators between groups of three.
+	digits, groups := 0, 0 // digits in the current group, separators seen
+	i := 0
+intPart:
+	for i < len(s) {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case '0' <= r && r <= '9':
+			b.WriteRune(r)
+			digits++
+		case f.Thousands != 0 && r == f.Thousands:
+			if digits == 0 || digits > 3 || groups > 0 && digits != 3 {
+				return fail("thousands separator %q must separate groups of three digits", r)
+			}
+			digits = 0
+			groups++
+		default:
+			break intPart
+		}
+		i += size
+	}
+	if groups > 0 && digits != 3 {
+		return fail("thousands separator %q must separate groups of three digits", f.Thousands)
+	}
+	intDigits := b.Len() - len(sign)
+	fracDigits := 0
+	if r, size := utf8.DecodeRuneInString(s[i:]); i < len(s) && r == decimal {
+		b.WriteByte('.')
+		for i += size; i < len(s) && '0' <= s[i] && s[i] <= '9'; i++ {
+			b.WriteByte(s[i])
+			fracDigits++
+		}
+	}
+	if intDigits+fracDigits == 0 {
+		return fail("no digits")
+	}
+	if i < len(s) && (s[i] == 'e' || s[i] == 'E') {
+		if !f.Scientific {
+			return fail("scientific notation is not allowed")
+		}
+		b.WriteByte('e')
+		i++
+		if i < len(s) && (s[i] == '-' || s[i] == '+') {
+			b.WriteByte(s[i])
+			i++
+		}
+		start := i
+		for ; i < len(s) && '0' <= s[i] && s[i] <= '9'; i++ {
+			b.WriteByte(s[i])
+		}
+		if i == start {
+			return fail("exponent has no digits")
+		}
+	}
+	if i < len(s) {
+		r, _ := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == '.' || r == ',' || r == ' ' || r == '\'':
+			return fail("unexpected %q (decimal separator %q, thousands separator %s)", r, decimal, f.thousandsName())
+		}
+		return fail("unexpected %q", r)
+	}
+	return b.String(), nil
+}
+
+func (f NumberFormat) thousandsName() string {
+	if f.Thousands == 0 {
+		return "none"
+	}
+	return strconv.QuoteRune(f.Thousands)
+}
+
+func isCurrencySymbol(r rune) bool {
+	return unicode.Is(unicode.Sc, r)
+}
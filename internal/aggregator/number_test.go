@@ -0,0 +1,96 @@
+package aggregator
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNumberFormat_Money(t *testing.T) {
+	tests := []struct {
+		format NumberFormat
+		in     string
+		want   string
+	}{
+		{PlainNumbers, "1234.56", "1234.56"},
+		{PlainNumbers, " -0.5 ", "-0.5"},
+		{PlainNumbers, "+.5", "0.5"},
+		{PlainNumbers, "5.", "5"},
+		{PlainNumbers, "1.5e3", "1500"},
+		{PlainNumbers, "15E-1", "1.5"},
+		{USNumbers, "$1,234.56", "1234.56"},
+		{USNumbers, "1234.56", "1234.56"},
+		{USNumbers, "-$1,000,000", "-1000000"},
+		{USNumbers, "$-12.50", "-12.5"},
+		{EUNumbers, "1.234,56", "1234.56"},
+		{EUNumbers, "1.234,56 €", "1234.56"},
+		{EUNumbers, "€ -0,99", "-0.99"},
+		{EUNumbers, "-2,5€", "-2.5"},
+		{NumberFormat{Name: "fr", Decimal: ',', Thousands: ' '}, "1 234 567,8", "1234567.8"},
+	}
+	for _, tt := range tests {
+		got, err := tt.format.money(tt.in)
+		if err != nil || got != amount(tt.want) {
+			t.Errorf("%s.money(%q) = %v, %v; want %v", tt.format, tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestNumberFormat_MoneyErrors(t *testing.T) {
+	tests := []struct {
+		format NumberFormat
+		in     string
+		want   string
+	}{
+		{PlainNumbers, "", "number format plain: no digits"},
+		{PlainNumbers, "$12", "number format plain: currency symbol '$' is not allowed"},
+		{PlainNumbers, "1,234.56", "number format plain: unexpected ','"},
+		{PlainNumbers, "NaN", "number format plain: no digits"},
+		{PlainNumbers, "Inf", "number format plain: no digits"},
+		{PlainNumbers, "0x1p3", "number format plain: unexpected 'x'"},
+		{PlainNumbers, "1e", "number format plain: exponent has no digits"},
+		{PlainNumbers, "1e400", "number format plain: amount out of range"},
+		{PlainNumbers, "--1", "number format plain: no digits"},
+		{USNumbers, "1.5e3", "number format us: scientific notation is not allowed"},
+		{USNumbers, "12,34", "number format us: thousands separator ',' must separate groups of three digits"},
+		{USNumbers, "1234,567", "number format us: thousands separator ',' must separate groups of three digits"},
+		{USNumbers, ",123", "number format us: thousands separator ',' must separate groups of three digits"},
+		{USNumbers, "1,234,", "number format us: thousands separator ',' must separate groups of three digits"},
+		{USNumbers, "$$1", "number format us: currency symbol '$' must come before or after the number"},
+		{USNumbers, "1.234,56", "number format us: unexpected ','"},
+		{USNumbers, "12.50 USD", "number format us: unexpected ' '"}, // codes are not stripped
+		{EUNumbers, "1,234.56", "number format eu: unexpected '.'"},
+		{EUNumbers, "1.23", "number format eu: thousands separator '.' must separate groups of three digits"},
+	}
+	for _, tt := range tests {
+		_, err := tt.format.money(tt.in)
+		if err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+			t.Errorf("%s.money(%q) error = %v, want %q", tt.format, tt.in, err, tt.want)
+		}
+	}
+}
+
+func TestParseNumberFormat(t *testing.T) {
+	tests := []struct {
+		in   string
+		want NumberFormat
+	}{
+		{"plain", PlainNumbers},
+		{"EU", NumberFormat{Name: "EU", Decimal: ',', Thousands: '.', Currency: true}},
+		{"eu  currency=off", NumberFormat{Name: "eu currency=off", Decimal: ',', Thousands: '.'}},
+		{"us thousands=none scientific=on", NumberFormat{Name: "us thousands=none scientific=on", Decimal: '.', Currency: true, Scientific: true}},
+		{"decimal=, thousands=space", NumberFormat{Name: "decimal=, thousands=space", Decimal: ',', Thousands: ' ', Scientific: true}},
+		{"decimal=, thousands='", NumberFormat{Name: "decimal=, thousands='", Decimal: ',', Thousands: '\'', Scientific: true}},
+	}
+	for _, tt := range tests {
+		got, err := ParseNumberFormat(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseNumberFormat(%q) = %+v, %v; want %+v", tt.in, got, err, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"", "uk", "eu currency", "eu currency=maybe", "decimal=,,", "decimal=1", "thousands=$", "color=red", "eu decimal=."} {
+		if _, err := ParseNumberFormat(bad); err == nil {
+			t.Errorf("ParseNumberFormat(%q): expected error", bad)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package aggregator
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+)
+
+// seekableReaderAt is the input shape required for parallel parsing:
+// random access for the workers, and Seek to discover the input bounds.
+// *os.File and *strings.Reader both satisfy it.
+type seekableReaderAt interface {
+	io.ReaderAt
+	io.Seeker
+}
+
+const scanBufferSize = 64 << 10
+
+// chunk is a byte range of the input that starts at a record boundary
+// and ends at the next chunk's start (or at EOF).
+type chunk struct {
+	start, end int64
+}
+
+// chunkResult is what a worker reports once its chunk has been parsed.
+// lines counts physical newlines so csv.ParseError positions can be
+// rebased onto the whole file.
+type chunkResult struct {
+	store  *InMemoryMetricsStore
+	parser *rowParser
+	lines  int
+	err    error
+}
+
+// processParallel splits the data section of src into newline-aligned
+// chunks, parses each chunk into a worker-local store and merges the
+// stores into store in input order. Errors are reported exactly as the
+// serial path would: rejected rows are replayed through the error
+// policy in input order, and line numbers are rebased by the record and
+// newline counts of the chunks before them.
+func (p *csvProcessor) processParallel(ctx context.Context, src seekableReaderAt, source string, store MetricsStore) (ProcessStats, error) {
+	base, err := src.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return ProcessStats{}, fmt.Errorf("seek input: %w", err)
+	}
+	end, err := src.Seek(0, io.SeekEnd)
+	if err != nil {
+		return ProcessStats{}, fmt.Errorf("seek input: %w", err)
+	}
+
+	hr := p.newReader(io.NewSectionReader(src, base, end-base))
+	header, headerRows, err := p.readHeader(hr)
+	if err != nil {
+		return ProcessStats{}, err
+	}
+	colIndex, err := p.mapHeader(header)
+	if err != nil {
+		return ProcessStats{}, err
+	}
+	dataStart := base + hr.InputOffset()
+	headerLines, err := countNewlines(io.NewSectionReader(src, base, dataStart-base))
+	if err != nil {
+		return ProcessStats{}, fmt.Errorf("read header: %w", err)
+	}
+
+	chunks, err := splitChunks(src, dataStart, end, p.workers)
+	if err != nil {
+		return ProcessStats{}, err
+	}
+
+	results := make([]chunkResult, len(chunks))
+	var wg sync.WaitGroup
+	for i, c := range chunks {
+		wg.Add(1)
+		go func(i int, c chunk) {
+			defer wg.Done()
+			results[i] = p.parseChunk(ctx, src, c, len(header), colIndex)
+		}(i, c)
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return ProcessStats{}, err
+	}
+
+	if p.policy.Mode == SkipOnError && len(chunks) > 1 {
+		for _, res := range results {
+			if res.parser.quoteErr {
+				// A skipped quoting error means the quote parity used to
+				// place later boundaries may be wrong; start over serially.
+				slog.Debug("malformed quoting in input, parsing serially")
+				p.progress.restart(p.seen.RowsAccepted + p.seen.RowsRejected)
+				return p.processSerial(ctx, io.NewSectionReader(src, base, end-base), source, store)
+			}
+		}
+	}
+
+	merged := p.newRowParser(source)
+	lineNum, physLines := headerRows, headerLines
+	for _, res := range results {
+		for _, rr := range res.parser.deferred {
+			rebaseError(rr.err, lineNum, physLines)
+			if err := merged.reject(rr.err, rr.raw); err != nil {
+				return merged.stats, err
+			}
+		}
+		merged.stats.RowsAccepted += res.parser.stats.RowsAccepted
+		for rule, n := range res.parser.stats.Violations {
+			merged.stats.countViolations(rule, n)
+		}
+		if res.err != nil {
+			return merged.stats, rebaseError(res.err, lineNum, physLines)
+		}
+		lineNum += int(res.parser.stats.RowsAccepted + res.parser.stats.RowsRejected)
+		physLines += res.lines
+	}
+	for _, res := range results {
+		if err := res.store.mergeInto(store); err != nil {
+			return merged.stats, err
+		}
+	}
+
+	slog.Debug("merged parallel chunks", "chunks", len(chunks))
+	return merged.stats, nil
+}
+
+// parseChunk parses one chunk into a fresh store. Record numbers in
+// errors and deferred rejects are relative to the chunk start.
+func (p *csvProcessor) parseChunk(ctx context.Context, src io.ReaderAt, c chunk, fields int, colIndex columnIndex) chunkResult {
+	res := chunkResult{store: NewInMemoryMetricsStore()}
+	cr := &newlineCounter{r: p.progress.reader(io.NewSectionReader(src, c.start, c.end-c.start))}
+
+	res.parser = p.newRowParser("")
+	reader := p.newRecordReader(res.parser, cr)
+	reader.FieldsPerRecord = fields
+
+	res.parser.store = res.store
+	res.parser.col = colIndex
+	res.parser.deferRejects = true
+	res.err = res.parser.readAll(ctx, reader, 0)
+	res.lines = cr.n
+	return res
+}
+
+// rebaseError shifts a chunk-relative error onto whole-file positions.
+func rebaseError(err error, lineOffset, physOffset int) error {
+	var le *lineError
+	if errors.As(err, &le) {
+		le.line += lineOffset
+	}
+	var pe *csv.ParseError
+	if errors.As(err, &pe) {
+		pe.StartLine += physOffset
+		pe.Line += physOffset
+	}
+	return err
+}
+
+// splitChunks divides [start, end) into at most n chunks whose
+// boundaries fall just after a newline that is outside any quoted
+// field. Each raw range is scanned concurrently for its quote count and
+// for the first newline at each local quote parity; a prefix sum of the
+// quote parities then tells which of the two candidates is a real
+// record boundary. Ranges without a usable newline are folded into the
+// preceding chunk.
+func splitChunks(src io.ReaderAt, start, end int64, n int) ([]chunk, error) {
+	size := end - start
+	if n < 1 || size < int64(n) {
+		n = 1
+	}
+	step := size / int64(n)
+
+	scans := make([]rangeScan, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		lo := start + int64(i)*step
+		hi := lo + step
+		if i == n-1 {
+			hi = end
+		}
+		wg.Add(1)
+		go func(i int, lo, hi int64) {
+			defer wg.Done()
+			scans[i], errs[i] = scanRange(src, lo, hi)
+		}(i, lo, hi)
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return nil, fmt.Errorf("split input: %w", err)
+	}
+
+	bounds := []int64{start}
+	parity := 0
+	for i := 1; i < n; i++ {
+		parity = (parity + scans[i-1].quotes) % 2
+		if nl := scans[i].newline[parity]; nl >= 0 {
+			bounds = append(bounds, nl+1)
+		}
+	}
+	bounds = append(bounds, end)
+
+	chunks := make([]chunk, 0, len(bounds)-1)
+	for i := 0; i+1 < len(bounds); i++ {
+		if bounds[i] < bounds[i+1] {
+			chunks = append(chunks, chunk{start: bounds[i], end: bounds[i+1]})
+		}
+	}
+	return chunks, nil
+}
+
+// rangeScan summarises one raw byte range for splitChunks. newline[p]
+// is the absolute offset of the first '\n' reached after an even (p=0)
+// or odd (p=1) number of quotes within the range, or -1 if none.
+type rangeScan struct {
+	quotes  int
+	newline [2]int64
+}
+
+func scanRange(src io.ReaderAt, lo, hi int64) (rangeScan, error) {
+	s := rangeScan{newline: [2]int64{-1, -1}}
+	buf := make([]byte, scanBufferSize)
+	for off := lo; off < hi; {
+		want := hi - off
+		if want > int64(len(buf)) {
+			want = int64(len(buf))
+		}
+		n, err := src.ReadAt(buf[:want], off)
+		if n == 0 && err != nil {
+			return s, err
+		}
+		b := buf[:n]
+		if s.newline[0] >= 0 && s.newline[1] >= 0 {
+			s.quotes += bytes.Count(b, []byte{'"'})
+		} else {
+			for i, c := range b {
+				switch c {
+				case '"':
+					s.quotes++
+				case '\n':
+					if p := s.quotes % 2; s.newline[p] < 0 {
+						s.newline[p] = off + int64(i)
+					}
+				}
+			}
+		}
+		off += int64(n)
+	}
+	return s, nil
+}
+
+// newlineCounter counts '\n' bytes passing through r.
+type newlineCounter struct {
+	r io.Reader
+	n int
+}
+
+func (c *newlineCounter) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += bytes.Count(p[:n], []byte{'\n'})
+	return n, err
+}
+
+func countNewlines(r io.Reader) (int, error) {
+	c := &newlineCounter{r: r}
+	_, err := io.Copy(io.Discard, c)
+	return c.n, err
+}
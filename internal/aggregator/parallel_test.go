@@ -0,0 +1,246 @@
+package aggregator
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// generateInput builds a CSV with quoted campaign IDs that contain
+// commas and embedded newlines, so chunk boundaries have to respect
+// quoting. Spend values are arbitrary cent amounts such as 0.07 and
+// 12.10, which no binary float holds exactly.
+func generateInput(rows int) string {
+	var b strings.Builder
+	b.WriteString("campaign_id,impressions,clicks,spend,conversions\n")
+	for i := 0; i < rows; i++ {
+		id := fmt.Sprintf("camp%d", i%37)
+		if i%5 == 0 {
+			id = fmt.Sprintf("\"camp,%d\nline\"", i%11)
+		}
+		cents := (i*7919 + 7) % 10000
+		fmt.Fprintf(&b, "%s,%d,%d,%d.%02d,%d\n", id, 1000+i, i%50, cents/100, cents%100, i%7)
+	}
+	return b.String()
+}
+
+func processAll(t *testing.T, p Processor, r io.Reader) []*CampaignMetrics {
+	t.Helper()
+	store := NewInMemoryMetricsStore()
+	if _, err := p.Process(context.Background(), r, store); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return store.TopKByCTR(1 << 20)
+}
+
+func TestCSVProcessor_ParallelMatchesSerial(t *testing.T) {
+	input := generateInput(5000)
+	want := processAll(t, NewCSVProcessor(), strings.NewReader(input))
+
+	for _, workers := range []int{2, 3, 8, 64} {
+		got := processAll(t, NewCSVProcessor(WithWorkers(workers)), strings.NewReader(input))
+		if len(got) != len(want) {
+			t.Fatalf("workers=%d: got %d campaigns, want %d", workers, len(got), len(want))
+		}
+		for _, w := range want {
+			g := findByCampaignID(got, string(w.Key))
+			if g == nil {
+				t.Fatalf("workers=%d: campaign %q missing", workers, w.Key)
+			}
+			if *g != *w {
+				t.Errorf("workers=%d: got %v, want %v", workers, g, w)
+			}
+		}
+	}
+}
+
+func TestCSVProcessor_ParallelErrorLineNumbers(t *testing.T) {
+	cases := map[string]string{
+		"bad value":   "camp_x,1000,oops,1.00,1\n",
+		"field count": "camp_x,1000,10\n",
+		"bare quote":  "camp\"x,1000,10,1.00,1\n",
+	}
+	for name, badRow := range cases {
+		t.Run(name, func(t *testing.T) {
+			input := generateInput(3000) + badRow + generateInput(100)[len(expectedHeaderLine()):]
+
+			_, serialErr := NewCSVProcessor().Process(context.Background(), strings.NewReader(input), NewInMemoryMetricsStore())
+			if serialErr == nil {
+				t.Fatal("expected serial error")
+			}
+			for _, workers := range []int{2, 4, 16} {
+				_, err := NewCSVProcessor(WithWorkers(workers)).Process(context.Background(), strings.NewReader(input), NewInMemoryMetricsStore())
+				if err == nil || err.Error() != serialErr.Error() {
+					t.Errorf("workers=%d: got error %v, want %v", workers, err, serialErr)
+				}
+			}
+		})
+	}
+}
+
+func TestCSVProcessor_ParallelNonSeekableFallsBack(t *testing.T) {
+	input := generateInput(200)
+	want := processAll(t, NewCSVProcessor(), strings.NewReader(input))
+	got := processAll(t, NewCSVProcessor(WithWorkers(4)), io.MultiReader(strings.NewReader(input)))
+	if len(got) != len(want) {
+		t.Fatalf("got %d campaigns, want %d", len(got), len(want))
+	}
+}
+
+func TestCSVProcessor_ParallelHeaderOnly(t *testing.T) {
+	got := processAll(t, NewCSVProcessor(WithWorkers(4)), strings.NewReader(expectedHeaderLine()))
+	if len(got) != 0 {
+		t.Errorf("expected 0 campaigns, got %d", len(got))
+	}
+}
+
+func TestSplitChunks_QuoteAware(t *testing.T) {
+	input := generateInput(500)
+	r := strings.NewReader(input)
+	start := int64(len(expectedHeaderLine()))
+
+	chunks, err := splitChunks(r, start, int64(len(input)), 16)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(chunks) < 2 {
+		t.Fatalf("expected several chunks, got %d", len(chunks))
+	}
+	if chunks[0].start != start || chunks[len(chunks)-1].end != int64(len(input)) {
+		t.Errorf("chunks do not cover the input: %v", chunks)
+	}
+	for i, c := range chunks {
+		if i > 0 && c.start != chunks[i-1].end {
+			t.Errorf("chunk %d does not start where chunk %d ends", i, i-1)
+		}
+		// Every chunk must hold an even number of quotes, i.e. never
+		// cut through a quoted field.
+		if n := strings.Count(input[c.start:c.end], `"`); n%2 != 0 {
+			t.Errorf("chunk %d splits a quoted field", i)
+		}
+	}
+}
+
+func expectedHeaderLine() string {
+	return "campaign_id,impressions,clicks,spend,conversions\n"
+}
+
+func TestCSVProcessor_ParallelRejectsMatchSerial(t *testing.T) {
+	var b strings.Builder
+	b.WriteString(expectedHeaderLine())
+	for i := 0; i < 2000; i++ {
+		switch i % 97 {
+		case 13:
+			b.WriteString("camp1,oops,1,1.00,1\n")
+		case 42:
+			b.WriteString("camp2,1,1\n")
+		default:
+			fmt.Fprintf(&b, "camp%d,100,%d,1.50,1\n", i%9, i%10)
+		}
+	}
+	input := b.String()
+
+	run := func(workers int, policy ErrorPolicy) (string, ProcessStats, error) {
+		var rejects strings.Builder
+		p := NewCSVProcessor(WithWorkers(workers), WithErrorPolicy(policy), WithRejects(NewCSVRejectWriter(&rejects)))
+		stats, err := p.Process(context.Background(), strings.NewReader(input), NewInMemoryMetricsStore())
+		return rejects.String(), stats, err
+	}
+
+	for _, policy := range []ErrorPolicy{
+		{Mode: SkipOnError},
+		{Mode: SkipOnError, MaxErrors: maxErrors(20)},
+		{Mode: FailOnError},
+	} {
+		wantRejects, wantStats, wantErr := run(1, policy)
+		for _, workers := range []int{2, 5, 16} {
+			gotRejects, gotStats, gotErr := run(workers, policy)
+			if fmt.Sprint(gotErr) != fmt.Sprint(wantErr) {
+				t.Errorf("%+v workers=%d: got error %v, want %v", policy, workers, gotErr, wantErr)
+			}
+			if gotErr == nil && !reflect.DeepEqual(gotStats, wantStats) {
+				t.Errorf("%+v workers=%d: got stats %+v, want %+v", policy, workers, gotStats, wantStats)
+			}
+			if gotRejects != wantRejects {
+				t.Errorf("%+v workers=%d: rejects differ:\ngot:\n%s\nwant:\n%s", policy, workers, gotRejects, wantRejects)
+			}
+		}
+	}
+}
+
+func TestCSVProcessor_ParallelSkipMalformedQuotes(t *testing.T) {
+	input := generateInput(1000) + "camp\"x,1,1,1.00,1\n" + generateInput(1000)[len(expectedHeaderLine()):]
+	policy := WithErrorPolicy(ErrorPolicy{Mode: SkipOnError})
+
+	serial := NewInMemoryMetricsStore()
+	wantStats, err := NewCSVProcessor(policy).Process(context.Background(), strings.NewReader(input), serial)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	parallel := NewInMemoryMetricsStore()
+	gotStats, err := NewCSVProcessor(policy, WithWorkers(4)).Process(context.Background(), strings.NewReader(input), parallel)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(gotStats, wantStats) {
+		t.Errorf("got stats %+v, want %+v", gotStats, wantStats)
+	}
+	if len(parallel.TopKByCTR(1<<20)) != len(serial.TopKByCTR(1<<20)) {
+		t.Error("parallel and serial campaign counts differ")
+	}
+}
+
+func TestCSVProcessor_ParallelMergesRevenue(t *testing.T) {
+	var b strings.Builder
+	b.WriteString("campaign_id,impressions,clicks,spend,conversions,revenue\n")
+	for i := 0; i < 3000; i++ {
+		fmt.Fprintf(&b, "camp%d,1000,%d,%.2f,%d,%.2f\n", i%13, i%50, float64(i%40)*0.25, i%7, float64(i%20)*0.5)
+	}
+	want := processAll(t, NewCSVProcessor(), strings.NewReader(b.String()))
+	got := processAll(t, NewCSVProcessor(WithWorkers(4)), strings.NewReader(b.String()))
+	for _, w := range want {
+		g := findByCampaignID(got, string(w.Key))
+		if g == nil || *g != *w {
+			t.Errorf("got %+v, want %+v", g, w)
+		}
+	}
+}
+
+func TestCSVProcessor_ParallelWithoutHeader(t *testing.T) {
+	input := strings.SplitN(generateInput(3000), "\n", 2)[1]
+	input = strings.ReplaceAll(input, ",", "|")
+	opts := []CSVOption{WithDelimiter('|'), WithoutHeader(DefaultInputColumns(DefaultGroupBy))}
+	want := processAll(t, NewCSVProcessor(opts...), strings.NewReader(input))
+	got := processAll(t, NewCSVProcessor(append(opts, WithWorkers(4))...), strings.NewReader(input))
+	if len(got) != len(want) {
+		t.Fatalf("got %d campaigns, want %d", len(got), len(want))
+	}
+	for _, w := range want {
+		if g := findByCampaignID(got, string(w.Key)); g == nil || *g != *w {
+			t.Errorf("got %v, want %v", g, w)
+		}
+	}
+}
+
+func TestCSVProcessor_ParallelErrorLineWithoutHeader(t *testing.T) {
+	input := strings.SplitN(generateInput(2000), "\n", 2)[1] + "camp_x,1000,oops,1.00,1\n"
+	p := NewCSVProcessor(WithWorkers(4), WithoutHeader(DefaultInputColumns(DefaultGroupBy)))
+	_, err := p.Process(context.Background(), strings.NewReader(input), NewInMemoryMetricsStore())
+	if err == nil || !strings.Contains(err.Error(), "line 2001:") {
+		t.Fatalf("expected an error on line 2001, got %v", err)
+	}
+}
+
+func TestCSVProcessor_ParallelLazyQuotesFallsBack(t *testing.T) {
+	// A stray quote would flip the quote parity of the chunk splitter;
+	// lazy quotes therefore parse serially and still see every row.
+	input := generateInput(2000) + "camp \"x,1000,10,1.00,1\n"
+	want := processAll(t, NewCSVProcessor(WithLazyQuotes()), strings.NewReader(input))
+	got := processAll(t, NewCSVProcessor(WithLazyQuotes(), WithWorkers(4)), strings.NewReader(input))
+	if len(got) != len(want) || findByCampaignID(got, `camp "x`) == nil {
+		t.Errorf("got %d campaigns, want %d including camp \"x", len(got), len(want))
+	}
+}
@@ -0,0 +1,83 @@
+package aggregator
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrorMode selects how the processor treats rows that fail to parse.
+type ErrorMode int
+
+const (
+	// FailOnError aborts the run on the first bad row.
+	FailOnError ErrorMode = iota
+	// SkipOnError rejects bad rows and keeps going until the error
+	// budget is exhausted.
+	SkipOnError
+)
+
+// ParseErrorMode maps the --on-error flag values to an ErrorMode.
+func ParseErrorMode(s string) (ErrorMode, error) {
+	switch s {
+	case "fail":
+		return FailOnError, nil
+	case "skip":
+		return SkipOnError, nil
+	}
+	return FailOnError, fmt.Errorf("invalid error mode %q; want skip or fail", s)
+}
+
+// ErrorPolicy controls how many bad rows a run tolerates. The budget
+// fields only apply in SkipOnError mode; nil sets no limit, and zero
+// allows no bad row at all. MaxErrors is enforced as rows are read,
+// MaxErrorRatio once the whole input has been consumed.
+type ErrorPolicy struct {
+	Mode          ErrorMode
+	MaxErrors     *int64
+	MaxErrorRatio *float64
+}
+
+// ParseErrorBudget parses an error budget given either as an absolute
+// row count ("100") or as a percentage of all rows read ("0.5%"). Only
+// the limit given is set; "0" and "0%" allow no bad rows.
+func ParseErrorBudget(s string) (maxErrors *int64, maxRatio *float64, err error) {
+	if pct, ok := strings.CutSuffix(s, "%"); ok {
+		v, err := strconv.ParseFloat(pct, 64)
+		if err != nil || v < 0 || v > 100 {
+			return nil, nil, fmt.Errorf("invalid error budget %q; want a percentage between 0%% and 100%%", s)
+		}
+		ratio := v / 100
+		return nil, &ratio, nil
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return nil, nil, fmt.Errorf("invalid error budget %q; want a row count or a percentage", s)
+	}
+	return &n, nil, nil
+}
+
+// check decides whether the run may continue after the rejected-th bad
+// row, described by le.
+func (p ErrorPolicy) check(rejected int64, le *lineError) error {
+	if p.Mode == FailOnError {
+		return le
+	}
+	if p.MaxErrors != nil && rejected > *p.MaxErrors {
+		return fmt.Errorf("too many rejected rows (max %d): %w", *p.MaxErrors, le)
+	}
+	return nil
+}
+
+// checkRatio applies the percentage budget to the final counts.
+func (p ErrorPolicy) checkRatio(stats ProcessStats) error {
+	total := stats.RowsAccepted + stats.RowsRejected
+	if p.Mode == FailOnError || p.MaxErrorRatio == nil || total == 0 {
+		return nil
+	}
+	if ratio := float64(stats.RowsRejected) / float64(total); ratio > *p.MaxErrorRatio {
+		return fmt.Errorf("too many rejected rows: %d of %d (%.2f%%, max %.2f%%)",
+			stats.RowsRejected, total, ratio*100, *p.MaxErrorRatio*100)
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package aggregator
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseErrorMode(t *testing.T) {
+	if m, err := ParseErrorMode("skip"); err != nil || m != SkipOnError {
+		t.Errorf("skip: got %v, %v", m, err)
+	}
+	if m, err := ParseErrorMode("fail"); err != nil || m != FailOnError {
+		t.Errorf("fail: got %v, %v", m, err)
+	}
+	if _, err := ParseErrorMode("ignore"); err == nil {
+		t.Error("expected error for unknown mode")
+	}
+}
+
+func TestParseErrorBudget(t *testing.T) {
+	cases := []struct {
+		in       string
+		wantN    *int64
+		wantRate *float64
+		wantErr  bool
+	}{
+		{in: "100", wantN: maxErrors(100)},
+		{in: "0", wantN: maxErrors(0)},
+		{in: "2.5%", wantRate: maxRatio(0.025)},
+		{in: "100%", wantRate: maxRatio(1)},
+		{in: "0%", wantRate: maxRatio(0)},
+		{in: "-1", wantErr: true},
+		{in: "150%", wantErr: true},
+		{in: "lots", wantErr: true},
+	}
+	for _, tc := range cases {
+		n, rate, err := ParseErrorBudget(tc.in)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%q: unexpected error state: %v", tc.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(n, tc.wantN) || !reflect.DeepEqual(rate, tc.wantRate) {
+			t.Errorf("%q: got (%v, %v), want (%v, %v)", tc.in, n, rate, tc.wantN, tc.wantRate)
+		}
+	}
+}
+
+func maxErrors(n int64) *int64 { return &n }
+
+func maxRatio(r float64) *float64 { return &r }
@@ -1,123 +1,699 @@
 package aggregator
 
 import (
+	"bytes"
+	"context"
 	"encoding/csv"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"slices"
 	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
-var expectedHeader = []string{
-	"campaign_id", "impressions", "clicks", "spend", "conversions",
+// metricColumns are the numeric input columns summed per group.
+var metricColumns = []string{"impressions", "clicks", "spend", "conversions"}
+
+// revenueColumn is an optional metric column; when present it is summed
+// per group for ROAS and profit.
+const revenueColumn = "revenue"
+
+// DefaultGroupBy aggregates by campaign alone.
+var DefaultGroupBy = []string{"campaign_id"}
+
+type csvProcessor struct {
+	groupBy []string
+	aliases map[string]string // normalized input name -> column name
+	workers int
+
+	// Input dialect; see newReader. positional names the fields of
+	// headerless inputs.
+	comma      rune
+	comment    rune
+	lazyQuotes bool
+	positional []string
+	number     NumberFormat
+	validation Validation
+
+	policy   ErrorPolicy
+	rejects  RejectWriter
+	progress *progressTracker
+
+	// State carried across Process calls, so that several inputs read
+	// into one store share a set of columns and an error budget.
+	firstHeader []string
+	columns     *columnIndex
+	seen        ProcessStats
+}
+
+// CSVOption configures the processor returned by NewCSVProcessor.
+type CSVOption func(*csvProcessor)
+
+// WithWorkers sets the number of goroutines used to parse the input.
+// Values above 1 enable chunked parallel parsing when the reader
+// passed to Process supports random access (io.ReaderAt + io.Seeker);
+// other readers are parsed serially.
+func WithWorkers(n int) CSVOption {
+	return func(p *csvProcessor) {
+		p.workers = n
+	}
+}
+
+// WithGroupBy sets the columns whose values form the aggregation key,
+// in order. The default is DefaultGroupBy.
+func WithGroupBy(columns []string) CSVOption {
+	return func(p *csvProcessor) {
+		p.groupBy = columns
+	}
+}
+
+// WithColumnMap renames input columns before they are matched: each key
+// is an input header name and its value the column it stands for, such
+// as "cost" for "spend". Several names may map to the same column, so
+// one map can serve inputs from different sources. Use ParseColumnMap
+// to build it from a flag.
+func WithColumnMap(m map[string]string) CSVOption {
+	return func(p *csvProcessor) {
+		p.aliases = make(map[string]string, len(m))
+		for from, to := range m {
+			p.aliases[normalizeColumn(from)] = normalizeColumn(to)
+		}
+	}
+}
+
+// WithDelimiter sets the field delimiter, such as '\t' for TSV. The
+// default is a comma. Use ParseDelimiter to read it from a flag.
+func WithDelimiter(r rune) CSVOption {
+	return func(p *csvProcessor) {
+		p.comma = r
+	}
+}
+
+// WithComment skips lines starting with r, such as '#'. Comment lines
+// are neither rows nor records, so error line numbers, which count
+// records, skip them. Inputs with comments are parsed serially, since a
+// quote inside a comment would mislead the chunk splitter of parallel
+// parsing.
+func WithComment(r rune) CSVOption {
+	return func(p *csvProcessor) {
+		p.comment = r
+	}
+}
+
+// WithLazyQuotes accepts quotes inside unquoted fields and unescaped
+// quotes inside quoted fields instead of rejecting the row. Such inputs
+// are parsed serially, since stray quotes defeat the quote-aware chunk
+// splitter of parallel parsing.
+func WithLazyQuotes() CSVOption {
+	return func(p *csvProcessor) {
+		p.lazyQuotes = true
+	}
+}
+
+// WithoutHeader reads inputs that have no header row: columns names the
+// fields of every row in order and is matched like a header. Names
+// that match no column, including empty ones, mark ignored fields.
+// Every row must have len(columns) fields.
+func WithoutHeader(columns []string) CSVOption {
+	return func(p *csvProcessor) {
+		p.positional = columns
+	}
+}
+
+// WithNumberFormat sets how the spend and revenue columns are read, such
+// as EUNumbers for 1.234,56. The default is PlainNumbers. Use
+// ParseNumberFormat to read it from a flag.
+func WithNumberFormat(f NumberFormat) CSVOption {
+	return func(p *csvProcessor) {
+		p.number = f
+	}
+}
+
+// WithValidation sets the severity of validation rules, which check
+// every parsed row; rules v does not name keep their default severity.
+// Use ParseValidation to read it from a flag.
+func WithValidation(v Validation) CSVOption {
+	return func(p *csvProcessor) {
+		p.validation = v
+	}
+}
+
+// DefaultInputColumns is the positional layout assumed for headerless
+// inputs when none is given: the group-by columns, then impressions,
+// clicks, spend and conversions.
+func DefaultInputColumns(groupBy []string) []string {
+	return append(slices.Clone(groupBy), metricColumns...)
+}
+
+// WithErrorPolicy sets how rows that fail to parse are handled. The
+// default is FailOnError.
+func WithErrorPolicy(policy ErrorPolicy) CSVOption {
+	return func(p *csvProcessor) {
+		p.policy = policy
+	}
 }
 
-type csvProcessor struct{}
+// WithRejects sends every rejected row to w. The processor flushes w
+// before Process returns.
+func WithRejects(w RejectWriter) CSVOption {
+	return func(p *csvProcessor) {
+		p.rejects = w
+	}
+}
 
-func NewCSVProcessor() Processor {
-	return &csvProcessor{}
+func NewCSVProcessor(opts ...CSVOption) Processor {
+	p := &csvProcessor{groupBy: DefaultGroupBy, workers: 1, number: PlainNumbers}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
 }
 
 // Process streams the CSV from r line-by-line and accumulates
 // metrics into store. Memory usage is proportional to the
 // number of distinct campaign IDs, not the input size.
-func (p *csvProcessor) Process(r io.Reader, store MetricsStore) error {
+//
+// Process may be called once per input when several inputs feed the
+// same store. Every input must then map to the same columns as the
+// first one, and the error budget applies to all inputs combined. If r
+// has a Name method (as *os.File does) the name is recorded with
+// rejected rows.
+//
+// Process checks ctx between batches of rows and returns ctx.Err() once
+// it is done; the store then holds a partial aggregate.
+func (p *csvProcessor) Process(ctx context.Context, r io.Reader, store MetricsStore) (stats ProcessStats, err error) {
+	if p.rejects != nil {
+		defer func() {
+			if ferr := p.rejects.Flush(); ferr != nil && err == nil {
+				err = ferr
+			}
+		}()
+	}
+
+	if p.progress != nil {
+		stop := p.progress.start(sourceName(r), inputSize(r), p.seen.RowsAccepted+p.seen.RowsRejected)
+		defer func() {
+			p.progress.finish(p.seen.RowsAccepted+p.seen.RowsRejected, store)
+			stop()
+		}()
+	}
+
+	if p.workers > 1 {
+		src, ok := r.(seekableReaderAt)
+		switch {
+		case !ok:
+			slog.Debug("input is not seekable, parsing serially", "workers", p.workers)
+			stats, err = p.processSerial(ctx, r, sourceName(r), store)
+		case p.comment != 0 || p.lazyQuotes:
+			slog.Debug("comments or lazy quotes enabled, parsing serially", "workers", p.workers)
+			stats, err = p.processSerial(ctx, r, sourceName(r), store)
+		default:
+			stats, err = p.processParallel(ctx, src, sourceName(r), store)
+		}
+	} else {
+		stats, err = p.processSerial(ctx, r, sourceName(r), store)
+	}
+	p.seen.add(stats)
+	if err != nil {
+		return stats, err
+	}
+	if err := p.policy.checkRatio(p.seen); err != nil {
+		return stats, err
+	}
+
+	slog.Debug("parsed csv input", "accepted", stats.RowsAccepted, "rejected", stats.RowsRejected)
+	return stats, nil
+}
+
+// sourceName returns the name of r if it has one.
+func sourceName(r io.Reader) string {
+	if n, ok := r.(interface{ Name() string }); ok {
+		return n.Name()
+	}
+	return ""
+}
+
+func (p *csvProcessor) processSerial(ctx context.Context, r io.Reader, source string, store MetricsStore) (ProcessStats, error) {
+	rp := p.newRowParser(source)
+	reader := p.newRecordReader(rp, p.progress.reader(r))
+	header, lineNum, err := p.readHeader(reader)
+	if err != nil {
+		return ProcessStats{}, err
+	}
+	colIndex, err := p.mapHeader(header)
+	if err != nil {
+		return ProcessStats{}, err
+	}
+
+	rp.store = store
+	rp.col = colIndex
+	err = rp.readAll(ctx, reader, lineNum)
+	return rp.stats, err
+}
+
+// newReader returns a csv.Reader for r in the configured dialect.
+func (p *csvProcessor) newReader(r io.Reader) *csv.Reader {
 	reader := csv.NewReader(r)
 	reader.ReuseRecord = true
+	if p.comma != 0 {
+		reader.Comma = p.comma
+	}
+	reader.Comment = p.comment
+	reader.LazyQuotes = p.lazyQuotes
+	return reader
+}
 
+// newRecordReader returns a csv.Reader over r for rp to drain. When
+// rejected rows are written, it reads through a recordTap so that rp
+// can recover their text.
+func (p *csvProcessor) newRecordReader(rp *rowParser, r io.Reader) *csv.Reader {
+	if p.rejects != nil {
+		rp.tap = newRecordTap(r)
+		r = rp.tap
+	}
+	return p.newReader(r)
+}
+
+// readHeader reads the header row of reader, or returns the positional
+// columns of a headerless input, along with the number of lines
+// consumed.
+func (p *csvProcessor) readHeader(reader *csv.Reader) ([]string, int, error) {
+	if p.positional != nil {
+		reader.FieldsPerRecord = len(p.positional)
+		return p.positional, 0, nil
+	}
 	header, err := reader.Read()
 	if err != nil {
-		return fmt.Errorf("read header: %w", err)
+		return nil, 0, fmt.Errorf("read header: %w", err)
+	}
+	return header, 1, nil
+}
+
+// ParseDelimiter maps a --delimiter value to a rune: a single character,
+// or one of the names comma, tab, semicolon and pipe. "\t" is a tab.
+func ParseDelimiter(s string) (rune, error) {
+	switch strings.ToLower(s) {
+	case "comma":
+		return ',', nil
+	case "tab", `\t`:
+		return '\t', nil
+	case "semicolon":
+		return ';', nil
+	case "pipe":
+		return '|', nil
 	}
-	colIndex, err := mapColumns(header)
+	r, err := parseSeparator(s)
 	if err != nil {
-		return err
+		return 0, fmt.Errorf("invalid delimiter %q: %w", s, err)
 	}
+	return r, nil
+}
 
-	lineNum := 1 // header already read
+// ParseComment maps a --comment value, a single character, to a rune.
+func ParseComment(s string) (rune, error) {
+	r, err := parseSeparator(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid comment character %q: %w", s, err)
+	}
+	return r, nil
+}
+
+// parseSeparator accepts a single character that encoding/csv allows
+// as a delimiter or comment character.
+func parseSeparator(s string) (rune, error) {
+	r, size := utf8.DecodeRuneInString(s)
+	switch {
+	case s == "" || size != len(s):
+		return 0, errors.New("want a single character")
+	case r == '"' || r == '\r' || r == '\n' || r == utf8.RuneError || unicode.IsSpace(r) && r != '\t':
+		return 0, errors.New("quotes, line breaks and spaces are not allowed")
+	}
+	return r, nil
+}
+
+// rowParser turns csv records into store updates and applies the error
+// policy to rows that fail to parse. Parallel workers set deferRejects
+// so rejected rows are buffered until their line numbers can be
+// rebased, and replayed through a non-deferring rowParser at merge time.
+type rowParser struct {
+	store    MetricsStore
+	col      columnIndex
+	number   NumberFormat
+	rules    []activeRule
+	policy   ErrorPolicy
+	rejects  RejectWriter
+	tap      *recordTap
+	progress *progressTracker
+	source   string
+	stats    ProcessStats
+	// rejectedBefore counts rows rejected by earlier inputs, which
+	// count against the same error budget.
+	rejectedBefore int64
+
+	deferRejects bool
+	deferred     []rejectedRow
+	// quoteErr records that a malformed quoted field was skipped, which
+	// invalidates the quote-parity chunk boundaries of parallel parsing.
+	quoteErr bool
+}
+
+// newRowParser returns a parser carrying p's policy and reject sink.
+func (p *csvProcessor) newRowParser(source string) *rowParser {
+	return &rowParser{
+		number:         p.number,
+		rules:          p.validation.activeRules(),
+		policy:         p.policy,
+		rejects:        p.rejects,
+		progress:       p.progress,
+		source:         source,
+		rejectedBefore: p.seen.RowsRejected,
+	}
+}
 
-	for {
+type rejectedRow struct {
+	err *lineError
+	raw []byte
+}
+
+// cancelCheckRows is how many records readAll parses between checks of
+// its context.
+const cancelCheckRows = 1024
+
+// readAll drains reader. lineNum is the number of the last record
+// already consumed, so rows are numbered from lineNum+1. It returns
+// ctx.Err() once ctx is done, and reports progress at the same
+// checkpoints.
+func (rp *rowParser) readAll(ctx context.Context, reader *csv.Reader, lineNum int) error {
+	for n := 0; ; n++ {
+		if n%cancelCheckRows == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if n > 0 {
+				rp.progress.checkpoint(cancelCheckRows, rp.store)
+			}
+		}
 		record, err := reader.Read()
 		if err == io.EOF {
-			break
+			return nil
 		}
+		lineNum++
+		raw := rp.raw(reader, err)
 		if err != nil {
-			return fmt.Errorf("line %d: %w", lineNum+1, err)
+			var pe *csv.ParseError
+			if !errors.As(err, &pe) {
+				return &lineError{line: lineNum, err: err}
+			}
+			if pe.Err == csv.ErrBareQuote || pe.Err == csv.ErrQuote {
+				rp.quoteErr = true
+			}
+			if err := rp.reject(&lineError{line: lineNum, err: err}, raw); err != nil {
+				return err
+			}
+			continue
 		}
-		lineNum++
 
-		if err := accumulateRow(store, record, colIndex, lineNum); err != nil {
-			return err
+		if err := rp.accumulate(record, lineNum); err != nil {
+			var le *lineError
+			if !errors.As(err, &le) {
+				return err
+			}
+			if err := rp.reject(le, raw); err != nil {
+				return err
+			}
+			continue
 		}
+		rp.stats.RowsAccepted++
 	}
+}
 
-	slog.Debug("parsed csv input", "rows", lineNum-1)
-	return nil
+// raw returns the input text of the record reader has just read, with
+// error err, or nil if rejected rows are not written. It must be called
+// after every Read so the tap can let go of what it holds.
+func (rp *rowParser) raw(reader *csv.Reader, err error) []byte {
+	if rp.tap == nil {
+		return nil
+	}
+	var start int
+	var pe *csv.ParseError
+	if errors.As(err, &pe) {
+		start = pe.StartLine
+	} else if err == nil {
+		start, _ = reader.FieldPos(0)
+	}
+	return rp.tap.record(start, reader.InputOffset())
+}
+
+func (rp *rowParser) reject(le *lineError, raw []byte) error {
+	rp.stats.RowsRejected++
+	if rp.deferRejects {
+		rp.deferred = append(rp.deferred, rejectedRow{err: le, raw: bytes.Clone(raw)})
+	} else if rp.rejects != nil {
+		if err := rp.rejects.Reject(rp.source, le.line, le.err.Error(), string(raw)); err != nil {
+			return fmt.Errorf("write rejects: %w", err)
+		}
+	}
+	return rp.policy.check(rp.rejectedBefore+rp.stats.RowsRejected, le)
+}
+
+// lineError attributes a parse failure to an input record. The line is
+// the 1-based record number counting the header as line 1.
+type lineError struct {
+	line int
+	err  error
+}
+
+func (e *lineError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.line, e.err)
+}
+
+func (e *lineError) Unwrap() error {
+	return e.err
 }
 
 type columnIndex struct {
-	campaignID  int
+	keys        []int
+	keyNames    []string
 	impressions int
 	clicks      int
 	spend       int
 	conversions int
+	revenue     int // -1 without a revenue column
 }
 
-func mapColumns(header []string) (columnIndex, error) {
-	idx := columnIndex{-1, -1, -1, -1, -1}
-	for i, name := range header {
-		switch name {
-		case "campaign_id":
-			idx.campaignID = i
-		case "impressions":
-			idx.impressions = i
-		case "clicks":
-			idx.clicks = i
-		case "spend":
-			idx.spend = i
-		case "conversions":
-			idx.conversions = i
+// ParseGroupBy parses a comma-separated --group-by value and checks that
+// the columns are distinct dimension columns rather than metrics.
+func ParseGroupBy(s string) ([]string, error) {
+	var columns []string
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		norm := normalizeColumn(name)
+		switch {
+		case name == "":
+			return nil, fmt.Errorf("invalid group-by %q: empty column name", s)
+		case isMetricColumn(norm):
+			return nil, fmt.Errorf("invalid group-by %q: cannot group by metric column %s", s, name)
+		case seen[norm]:
+			return nil, fmt.Errorf("invalid group-by %q: duplicate column %s", s, name)
 		}
+		seen[norm] = true
+		columns = append(columns, name)
 	}
-	if idx.campaignID < 0 || idx.impressions < 0 || idx.clicks < 0 ||
-		idx.spend < 0 || idx.conversions < 0 {
-		return idx, fmt.Errorf("missing required columns; need %v, got %v", expectedHeader, header)
+	return columns, nil
+}
+
+// ParseColumnMap parses a comma-separated --column-map value of
+// input=column pairs, such as "cmp_id=campaign_id,cost=spend". Every
+// column must be a metric column, revenue or one of groupBy.
+func ParseColumnMap(s string, groupBy []string) (map[string]string, error) {
+	m := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		from, to, ok := strings.Cut(pair, "=")
+		from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+		switch {
+		case !ok || from == "" || to == "":
+			return nil, fmt.Errorf("invalid column map %q: want input=column pairs, got %q", s, pair)
+		case !isMetricColumn(normalizeColumn(to)) && !slices.ContainsFunc(groupBy, func(g string) bool { return normalizeColumn(g) == normalizeColumn(to) }):
+			want := append(slices.Clone(groupBy), metricColumns...)
+			return nil, fmt.Errorf("invalid column map %q: unknown column %s; want one of %s or %s", s, to, strings.Join(want, ", "), revenueColumn)
+		}
+		if prev, dup := m[normalizeColumn(from)]; dup && prev != to {
+			return nil, fmt.Errorf("invalid column map %q: %s is mapped to both %s and %s", s, from, prev, to)
+		}
+		m[normalizeColumn(from)] = to
+	}
+	return m, nil
+}
+
+func isMetricColumn(name string) bool {
+	return slices.Contains(metricColumns, name) || name == revenueColumn
+}
+
+// normalizeColumn is the form in which header cells, group-by columns
+// and column map entries are compared: trimmed and lower case.
+func normalizeColumn(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// mapHeader maps header with mapColumns and checks that it has the same
+// columns as the first input read by p, in any order. The index returned
+// is for this input only.
+func (p *csvProcessor) mapHeader(header []string) (columnIndex, error) {
+	idx, err := mapColumns(header, p.groupBy, p.aliases)
+	if err != nil {
+		return idx, err
+	}
+	if p.columns == nil {
+		p.columns = &idx
+		p.firstHeader = slices.Clone(header)
+	} else if !idx.sameColumns(*p.columns) {
+		return idx, fmt.Errorf("header %v does not have the columns of the first input %v", header, p.firstHeader)
+	}
+	return idx, nil
+}
+
+// mapColumns locates the group-by and metric columns, and the optional
+// revenue column, in header. Header cells match ignoring case and
+// surrounding whitespace, after a leading byte order mark is stripped
+// and aliases (keyed by normalized name) are applied. Any other columns
+// are ignored; two cells matching the same column are an error.
+func mapColumns(header, groupBy []string, aliases map[string]string) (columnIndex, error) {
+	idx := columnIndex{
+		keys:        make([]int, len(groupBy)),
+		keyNames:    groupBy,
+		impressions: -1,
+		clicks:      -1,
+		spend:       -1,
+		conversions: -1,
+		revenue:     -1,
+	}
+	for i := range idx.keys {
+		idx.keys[i] = -1
+	}
+	slots := map[string]*int{
+		"impressions": &idx.impressions,
+		"clicks":      &idx.clicks,
+		"spend":       &idx.spend,
+		"conversions": &idx.conversions,
+		revenueColumn: &idx.revenue,
+	}
+	for k, name := range groupBy {
+		slots[normalizeColumn(name)] = &idx.keys[k]
+	}
+
+	for i, cell := range header {
+		if i == 0 {
+			cell = strings.TrimPrefix(cell, "\uFEFF")
+		}
+		name := normalizeColumn(cell)
+		if alias, ok := aliases[name]; ok {
+			name = alias
+		}
+		slot, ok := slots[name]
+		if !ok {
+			continue
+		}
+		if *slot >= 0 {
+			return idx, fmt.Errorf("columns %q and %q both match %s", header[*slot], cell, name)
+		}
+		*slot = i
+	}
+
+	var missing []string
+	for _, name := range append(slices.Clone(groupBy), metricColumns...) {
+		if *slots[normalizeColumn(name)] < 0 {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		need := append(slices.Clone(groupBy), metricColumns...)
+		return idx, fmt.Errorf("missing required columns %v (need %v, got %q)", missing, need, header)
 	}
 	return idx, nil
 }
 
-func accumulateRow(
-	store MetricsStore,
-	record []string,
-	col columnIndex,
-	lineNum int,
-) error {
-	campaignID := record[col.campaignID]
-	if campaignID == "" {
-		return fmt.Errorf("line %d: empty campaign_id", lineNum)
+// sameColumns reports whether col and other map the same columns,
+// wherever they are. The group-by and metric columns are always mapped,
+// so only the optional revenue column can differ.
+func (col columnIndex) sameColumns(other columnIndex) bool {
+	return (col.revenue >= 0) == (other.revenue >= 0)
+}
+
+// groupKey builds the aggregation key for record. An empty campaign_id
+// is an error; other dimensions may be empty. A NUL byte in any key
+// field is an error too, since it separates the values in a GroupKey.
+func (col columnIndex) groupKey(record []string) (GroupKey, error) {
+	for i, k := range col.keys {
+		if record[k] == "" && normalizeColumn(col.keyNames[i]) == "campaign_id" {
+			return "", errors.New("empty campaign_id")
+		}
+		if strings.Contains(record[k], keySeparator) {
+			return "", fmt.Errorf("%s contains a NUL byte", col.keyNames[i])
+		}
+	}
+	if len(col.keys) == 1 {
+		return GroupKey(record[col.keys[0]]), nil
+	}
+	values := make([]string, len(col.keys))
+	for i, k := range col.keys {
+		values[i] = record[k]
 	}
+	return NewGroupKey(values...), nil
+}
 
-	impressions, err := strconv.ParseInt(record[col.impressions], 10, 64)
+// accumulate parses record, checks it against the validation rules and
+// adds it to the store.
+func (rp *rowParser) accumulate(record []string, lineNum int) error {
+	col := rp.col
+	key, err := col.groupKey(record)
 	if err != nil {
-		return fmt.Errorf("line %d: bad impressions %q: %w", lineNum, record[col.impressions], err)
+		return &lineError{line: lineNum, err: err}
+	}
+	var v rowValues
+	v.impressions, err = strconv.ParseInt(record[col.impressions], 10, 64)
+	if err != nil {
+		return &lineError{line: lineNum, err: fmt.Errorf("bad impressions %q: %w", record[col.impressions], err)}
 	}
 
-	clicks, err := strconv.ParseInt(record[col.clicks], 10, 64)
+	v.clicks, err = strconv.ParseInt(record[col.clicks], 10, 64)
 	if err != nil {
-		return fmt.Errorf("line %d: bad clicks %q: %w", lineNum, record[col.clicks], err)
+		return &lineError{line: lineNum, err: fmt.Errorf("bad clicks %q: %w", record[col.clicks], err)}
 	}
 
-	spend, err := strconv.ParseFloat(record[col.spend], 64)
+	v.spend, err = rp.number.money(record[col.spend])
 	if err != nil {
-		return fmt.Errorf("line %d: bad spend %q: %w", lineNum, record[col.spend], err)
+		return &lineError{line: lineNum, err: fmt.Errorf("bad spend %q: %w", record[col.spend], err)}
 	}
 
-	conversions, err := strconv.ParseInt(record[col.conversions], 10, 64)
+	v.conversions, err = strconv.ParseInt(record[col.conversions], 10, 64)
 	if err != nil {
-		return fmt.Errorf("line %d: bad conversions %q: %w", lineNum, record[col.conversions], err)
+		return &lineError{line: lineNum, err: fmt.Errorf("bad conversions %q: %w", record[col.conversions], err)}
 	}
 
-	store.Add(campaignID, impressions, clicks, spend, conversions)
+	if col.revenue >= 0 {
+		v.hasRevenue = true
+		v.revenue, err = rp.number.money(record[col.revenue])
+		if err != nil {
+			return &lineError{line: lineNum, err: fmt.Errorf("bad revenue %q: %w", record[col.revenue], err)}
+		}
+	}
 
+	if err := validate(rp.rules, &v, &rp.stats); err != nil {
+		return &lineError{line: lineNum, err: err}
+	}
+
+	// An overflowing total is not a bad row: the run cannot produce
+	// exact totals, so the error is returned as is and ends it.
+	if err := rp.store.Add(key, v.impressions, v.clicks, v.spend, v.conversions); err != nil {
+		return err
+	}
+	if v.hasRevenue {
+		return rp.store.AddRevenue(key, v.revenue)
+	}
 	return nil
 }
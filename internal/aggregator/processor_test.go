@@ -1,6 +1,8 @@
 package aggregator
 
 import (
+	"context"
+	"errors"
 	"strings"
 	"testing"
 )
@@ -12,7 +14,7 @@ camp1,500,25,50.00,5
 `
 	p := NewCSVProcessor()
 	store := NewInMemoryMetricsStore()
-	if err := p.Process(strings.NewReader(input), store); err != nil {
+	if _, err := p.Process(context.Background(), strings.NewReader(input), store); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
@@ -31,8 +33,8 @@ camp1,500,25,50.00,5
 	if m.TotalClicks != 75 {
 		t.Errorf("clicks: got %d, want 75", m.TotalClicks)
 	}
-	if m.TotalSpend != 150.0 {
-		t.Errorf("spend: got %f, want 150.0", m.TotalSpend)
+	if m.TotalSpend != amount("150.0") {
+		t.Errorf("spend: got %s, want 150", m.TotalSpend)
 	}
 	if m.TotalConversions != 15 {
 		t.Errorf("conversions: got %d, want 15", m.TotalConversions)
@@ -47,7 +49,7 @@ camp3,3000,150,300.00,30
 `
 	p := NewCSVProcessor()
 	store := NewInMemoryMetricsStore()
-	if err := p.Process(strings.NewReader(input), store); err != nil {
+	if _, err := p.Process(context.Background(), strings.NewReader(input), store); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
@@ -68,7 +70,7 @@ camp1,1000,50
 `
 	p := NewCSVProcessor()
 	store := NewInMemoryMetricsStore()
-	err := p.Process(strings.NewReader(input), store)
+	_, err := p.Process(context.Background(), strings.NewReader(input), store)
 	if err == nil {
 		t.Fatal("expected error for missing columns")
 	}
@@ -80,7 +82,7 @@ camp1,not_a_number,50,100.00,10
 `
 	p := NewCSVProcessor()
 	store := NewInMemoryMetricsStore()
-	err := p.Process(strings.NewReader(input), store)
+	_, err := p.Process(context.Background(), strings.NewReader(input), store)
 	if err == nil {
 		t.Fatal("expected error for bad impressions value")
 	}
@@ -92,7 +94,7 @@ func TestCSVProcessor_EmptyCampaignID(t *testing.T) {
 `
 	p := NewCSVProcessor()
 	store := NewInMemoryMetricsStore()
-	err := p.Process(strings.NewReader(input), store)
+	_, err := p.Process(context.Background(), strings.NewReader(input), store)
 	if err == nil {
 		t.Fatal("expected error for empty campaign_id")
 	}
@@ -102,7 +104,7 @@ func TestCSVProcessor_HeaderOnly(t *testing.T) {
 	input := "campaign_id,impressions,clicks,spend,conversions\n"
 	p := NewCSVProcessor()
 	store := NewInMemoryMetricsStore()
-	if err := p.Process(strings.NewReader(input), store); err != nil {
+	if _, err := p.Process(context.Background(), strings.NewReader(input), store); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 	if n := len(store.TopKByCTR(100)); n != 0 {
@@ -116,7 +118,7 @@ camp1,1000,100,500.00,50
 `
 	p := NewCSVProcessor()
 	store := NewInMemoryMetricsStore()
-	if err := p.Process(strings.NewReader(input), store); err != nil {
+	if _, err := p.Process(context.Background(), strings.NewReader(input), store); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
@@ -131,3 +133,531 @@ camp1,1000,100,500.00,50
 		t.Errorf("CPA: got %f, want %f", m.CPA(), wantCPA)
 	}
 }
+
+const lenientInput = `campaign_id,impressions,clicks,spend,conversions
+camp1,1000,50,100.00,10
+camp1,bad,50,100.00,10
+,1000,50,100.00,10
+camp2,2000,100,200.00,20
+camp2,2000,100
+`
+
+func TestCSVProcessor_SkipRejectsBadRows(t *testing.T) {
+	var buf strings.Builder
+	p := NewCSVProcessor(
+		WithErrorPolicy(ErrorPolicy{Mode: SkipOnError}),
+		WithRejects(NewCSVRejectWriter(&buf)),
+	)
+	store := NewInMemoryMetricsStore()
+	stats, err := p.Process(context.Background(), strings.NewReader(lenientInput), store)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stats.RowsAccepted != 2 || stats.RowsRejected != 3 {
+		t.Errorf("stats: got %+v, want 2 accepted, 3 rejected", stats)
+	}
+
+	all := store.TopKByCTR(100)
+	if m := findByCampaignID(all, "camp1"); m == nil || m.TotalImpressions != 1000 {
+		t.Errorf("camp1: got %v, want 1000 impressions", m)
+	}
+
+	want := `source,line,reason,record
+,3,"bad impressions ""bad"": strconv.ParseInt: parsing ""bad"": invalid syntax","camp1,bad,50,100.00,10"
+,4,empty campaign_id,",1000,50,100.00,10"
+,6,record on line 6: wrong number of fields,"camp2,2000,100"
+`
+	if buf.String() != want {
+		t.Errorf("rejects:\ngot:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestCSVProcessor_FailIsDefault(t *testing.T) {
+	p := NewCSVProcessor()
+	stats, err := p.Process(context.Background(), strings.NewReader(lenientInput), NewInMemoryMetricsStore())
+	if err == nil || !strings.HasPrefix(err.Error(), "line 3: bad impressions") {
+		t.Fatalf("expected line 3 error, got %v", err)
+	}
+	if stats.RowsRejected != 1 {
+		t.Errorf("expected 1 rejected row, got %d", stats.RowsRejected)
+	}
+}
+
+func TestCSVProcessor_ErrorBudget(t *testing.T) {
+	cases := []struct {
+		name    string
+		policy  ErrorPolicy
+		wantErr string
+	}{
+		{"no limit", ErrorPolicy{Mode: SkipOnError}, ""},
+		{"count within budget", ErrorPolicy{Mode: SkipOnError, MaxErrors: maxErrors(3)}, ""},
+		{"count exceeded", ErrorPolicy{Mode: SkipOnError, MaxErrors: maxErrors(2)}, "too many rejected rows (max 2): line 6:"},
+		{"count zero", ErrorPolicy{Mode: SkipOnError, MaxErrors: maxErrors(0)}, "too many rejected rows (max 0): line "},
+		{"ratio within budget", ErrorPolicy{Mode: SkipOnError, MaxErrorRatio: maxRatio(0.6)}, ""},
+		{"ratio exceeded", ErrorPolicy{Mode: SkipOnError, MaxErrorRatio: maxRatio(0.5)}, "too many rejected rows: 3 of 5"},
+		{"ratio zero", ErrorPolicy{Mode: SkipOnError, MaxErrorRatio: maxRatio(0)}, "too many rejected rows: 3 of 5"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := NewCSVProcessor(WithErrorPolicy(tc.policy))
+			_, err := p.Process(context.Background(), strings.NewReader(lenientInput), NewInMemoryMetricsStore())
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.HasPrefix(err.Error(), tc.wantErr) {
+				t.Fatalf("expected error starting with %q, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestCSVProcessor_GroupByMultipleColumns(t *testing.T) {
+	input := `date,campaign_id,country,impressions,clicks,spend,conversions,platform
+2026-10-16,camp1,US,1000,50,100.00,10,ios
+2026-10-16,camp1,DE,500,25,50.00,5,ios
+2026-10-17,camp1,US,200,10,20.00,2,android
+`
+	p := NewCSVProcessor(WithGroupBy([]string{"campaign_id", "country"}))
+	store := NewInMemoryMetricsStore()
+	if _, err := p.Process(context.Background(), strings.NewReader(input), store); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	all := store.TopKByCTR(100)
+	if len(all) != 2 {
+		t.Fatalf("expected 2 groups, got %d", len(all))
+	}
+	us := findByKey(all, NewGroupKey("camp1", "US"))
+	if us == nil {
+		t.Fatal("camp1/US not found")
+	}
+	if us.TotalImpressions != 1200 || us.TotalClicks != 60 {
+		t.Errorf("camp1/US: got %v", us)
+	}
+	if got := us.Key.Values(); len(got) != 2 || got[0] != "camp1" || got[1] != "US" {
+		t.Errorf("key values: got %q", got)
+	}
+}
+
+func TestCSVProcessor_GroupByMissingColumn(t *testing.T) {
+	p := NewCSVProcessor(WithGroupBy([]string{"campaign_id", "country"}))
+	_, err := p.Process(context.Background(), strings.NewReader(lenientInput), NewInMemoryMetricsStore())
+	if err == nil || !strings.Contains(err.Error(), "need [campaign_id country impressions") {
+		t.Fatalf("expected missing column error, got %v", err)
+	}
+}
+
+func TestCSVProcessor_GroupByWithoutCampaignID(t *testing.T) {
+	input := `country,impressions,clicks,spend,conversions
+US,1000,50,100.00,10
+,500,25,50.00,5
+`
+	p := NewCSVProcessor(WithGroupBy([]string{"country"}))
+	store := NewInMemoryMetricsStore()
+	if _, err := p.Process(context.Background(), strings.NewReader(input), store); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := len(store.TopKByCTR(100)); n != 2 {
+		t.Errorf("expected 2 groups (empty country allowed), got %d", n)
+	}
+}
+
+func TestParseGroupBy(t *testing.T) {
+	got, err := ParseGroupBy("campaign_id, country")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got) != 2 || got[0] != "campaign_id" || got[1] != "country" {
+		t.Errorf("got %q", got)
+	}
+	for _, bad := range []string{"", "campaign_id,", "country,country", "campaign_id,spend"} {
+		if _, err := ParseGroupBy(bad); err == nil {
+			t.Errorf("%q: expected error", bad)
+		}
+	}
+}
+
+func TestCSVProcessor_Revenue(t *testing.T) {
+	input := `campaign_id,impressions,clicks,spend,conversions,revenue
+camp1,1000,100,50.00,5,100.00
+camp1,1000,100,50.00,5,25.50
+camp2,1000,10,5.00,0,0
+camp3,1000,10,5.00,0,oops
+`
+	p := NewCSVProcessor(WithErrorPolicy(ErrorPolicy{Mode: SkipOnError}))
+	store := NewInMemoryMetricsStore()
+	stats, err := p.Process(context.Background(), strings.NewReader(input), store)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stats.RowsRejected != 1 {
+		t.Errorf("rejected: got %d, want 1", stats.RowsRejected)
+	}
+	rows := store.TopKByCTR(100)
+	if len(rows) != 2 {
+		t.Fatalf("got %d campaigns, want 2 (a row with bad revenue adds nothing)", len(rows))
+	}
+	m := findByCampaignID(rows, "camp1")
+	if !m.HasRevenue || m.TotalRevenue != amount("125.5") {
+		t.Errorf("camp1 revenue: got %v (has=%v), want 125.5", m.TotalRevenue, m.HasRevenue)
+	}
+	if m := findByCampaignID(rows, "camp2"); !m.HasRevenue {
+		t.Error("camp2: zero revenue should still mark the group as having revenue")
+	}
+}
+
+func TestCSVProcessor_NoRevenueColumn(t *testing.T) {
+	input := `campaign_id,impressions,clicks,spend,conversions
+camp1,1000,100,50.00,5
+`
+	store := NewInMemoryMetricsStore()
+	if _, err := NewCSVProcessor().Process(context.Background(), strings.NewReader(input), store); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if m := findByCampaignID(store.TopKByCTR(1), "camp1"); m.HasRevenue {
+		t.Error("HasRevenue set without a revenue column")
+	}
+}
+
+func TestCSVProcessor_HeaderMatching(t *testing.T) {
+	input := "\uFEFF Campaign_ID ,IMPRESSIONS,Clicks ,spend,Conversions\ncamp1,1000,50,100.00,10\n"
+	store := NewInMemoryMetricsStore()
+	if _, err := NewCSVProcessor().Process(context.Background(), strings.NewReader(input), store); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	m := findByCampaignID(store.TopKByCTR(1), "camp1")
+	if m == nil || m.TotalImpressions != 1000 || m.TotalConversions != 10 {
+		t.Errorf("got %v", m)
+	}
+}
+
+func TestCSVProcessor_ColumnMap(t *testing.T) {
+	columnMap, err := ParseColumnMap("cmp_id=campaign_id, IMPR=impressions,cost=spend,spend_usd=spend,conv=conversions", DefaultGroupBy)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	p := NewCSVProcessor(WithColumnMap(columnMap))
+	store := NewInMemoryMetricsStore()
+	input := "cmp_id,impr,clicks,cost,conv,notes\ncamp1,1000,50,100.00,10,x\n"
+	if _, err := p.Process(context.Background(), strings.NewReader(input), store); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// A second input with another alias for spend at the same position.
+	input = "cmp_id,impr,clicks,spend_usd,conv,notes\ncamp1,1000,50,50.00,10,y\n"
+	if _, err := p.Process(context.Background(), strings.NewReader(input), store); err != nil {
+		t.Fatalf("second input: %v", err)
+	}
+	m := findByCampaignID(store.TopKByCTR(1), "camp1")
+	if m == nil || m.TotalSpend != amount("150") || m.TotalImpressions != 2000 {
+		t.Errorf("got %v", m)
+	}
+}
+
+func TestCSVProcessor_AmbiguousColumns(t *testing.T) {
+	p := NewCSVProcessor(WithColumnMap(map[string]string{"cost": "spend"}))
+	input := "campaign_id,impressions,clicks,spend,conversions,cost\ncamp1,1,1,1,1,1\n"
+	_, err := p.Process(context.Background(), strings.NewReader(input), NewInMemoryMetricsStore())
+	if err == nil || !strings.Contains(err.Error(), `columns "spend" and "cost" both match spend`) {
+		t.Fatalf("expected ambiguity error, got %v", err)
+	}
+}
+
+func TestCSVProcessor_MissingColumnsListed(t *testing.T) {
+	p := NewCSVProcessor(WithGroupBy([]string{"campaign_id", "country"}))
+	input := "campaign_id,impr,clicks,spend\ncamp1,1,1,1\n"
+	_, err := p.Process(context.Background(), strings.NewReader(input), NewInMemoryMetricsStore())
+	if err == nil || !strings.HasPrefix(err.Error(), "missing required columns [country impressions conversions] (") {
+		t.Fatalf("expected the exact missing columns, got %v", err)
+	}
+}
+
+func TestParseColumnMap(t *testing.T) {
+	got, err := ParseColumnMap("Cost = spend,geo=Country", []string{"campaign_id", "country"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got) != 2 || got["cost"] != "spend" || got["geo"] != "Country" {
+		t.Errorf("got %v", got)
+	}
+	for bad, want := range map[string]string{
+		"cost":                   "want input=column pairs",
+		"cost=":                  "want input=column pairs",
+		"cost=spend,,":           "want input=column pairs",
+		"cost=price":             "unknown column price",
+		"cost=spend,COST=click":  "unknown column click",
+		"cost=spend,COST=clicks": "mapped to both spend and clicks",
+	} {
+		if _, err := ParseColumnMap(bad, DefaultGroupBy); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: got %v, want error containing %q", bad, err, want)
+		}
+	}
+}
+
+func TestCSVProcessor_Delimiters(t *testing.T) {
+	for name, comma := range map[string]rune{"tsv": '\t', "semicolon": ';', "pipe": '|'} {
+		input := strings.NewReplacer(",", string(comma)).Replace(`campaign_id,impressions,clicks,spend,conversions
+camp1,1000,50,"1,5",10
+`)
+		store := NewInMemoryMetricsStore()
+		p := NewCSVProcessor(WithDelimiter(comma), WithErrorPolicy(ErrorPolicy{Mode: SkipOnError}))
+		stats, err := p.Process(context.Background(), strings.NewReader(input), store)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+		// The quoted "1<delim>5" is one field, and not a valid spend.
+		if stats.RowsRejected != 1 {
+			t.Errorf("%s: rejected %d rows, want 1", name, stats.RowsRejected)
+		}
+	}
+
+	input := "campaign_id;impressions;clicks;spend;conversions\ncamp1;1000;50;1.5;10\n"
+	store := NewInMemoryMetricsStore()
+	if _, err := NewCSVProcessor(WithDelimiter(';')).Process(context.Background(), strings.NewReader(input), store); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if m := findByCampaignID(store.TopKByCTR(1), "camp1"); m == nil || m.TotalSpend != amount("1.5") {
+		t.Errorf("got %v", m)
+	}
+}
+
+func TestCSVProcessor_CommentLines(t *testing.T) {
+	input := `# exported 2024-01-01
+campaign_id,impressions,clicks,spend,conversions
+camp1,1000,50,100.00,10
+# a note
+camp1,bad,50,100.00,10
+`
+	var rejects strings.Builder
+	p := NewCSVProcessor(WithComment('#'), WithErrorPolicy(ErrorPolicy{Mode: SkipOnError}), WithRejects(NewCSVRejectWriter(&rejects)))
+	stats, err := p.Process(context.Background(), strings.NewReader(input), NewInMemoryMetricsStore())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stats.RowsAccepted != 1 || stats.RowsRejected != 1 {
+		t.Errorf("got %+v, want 1 accepted and 1 rejected", stats)
+	}
+	if !strings.Contains(rejects.String(), ",3,") {
+		t.Errorf("expected the bad row as line 3 (comments are not numbered):\n%s", rejects.String())
+	}
+	if !strings.HasSuffix(rejects.String(), `,"camp1,bad,50,100.00,10"`+"\n") {
+		t.Errorf("expected the bad row without the comment before it:\n%s", rejects.String())
+	}
+}
+
+func TestCSVProcessor_RejectsKeepRawText(t *testing.T) {
+	input := "campaign_id\timpressions\tclicks\tspend\tconversions\r\n" +
+		"camp1\t1000\t50\t100.00\t10\r\n" +
+		"\"camp1\"\tbad\t50\t100.00\t10\r\n" +
+		"camp\"2\t1000\t50\t100.00\t10\r\n" +
+		"\"multi\r\nline\"\t-5\t1\t1.00\t1\r\n" +
+		"camp3\t1000\t50\t100.00\t10\r\n"
+	want := "source,line,reason,record\n" +
+		",3,\"bad impressions \"\"bad\"\": strconv.ParseInt: parsing \"\"bad\"\": invalid syntax\",\"\"\"camp1\"\"\tbad\t50\t100.00\t10\"\n" +
+		",4,\"parse error on line 4, column 5: bare \"\" in non-quoted-field\",\"camp\"\"2\t1000\t50\t100.00\t10\"\n" +
+		",5,rule negative_impressions: impressions -5 < 0,\"\"\"multi\r\nline\"\"\t-5\t1\t1.00\t1\"\n"
+	for _, workers := range []int{1, 4} {
+		var rejects strings.Builder
+		p := NewCSVProcessor(
+			WithDelimiter('\t'),
+			WithWorkers(workers),
+			WithErrorPolicy(ErrorPolicy{Mode: SkipOnError}),
+			WithRejects(NewCSVRejectWriter(&rejects)),
+		)
+		stats, err := p.Process(context.Background(), strings.NewReader(input), NewInMemoryMetricsStore())
+		if err != nil {
+			t.Fatalf("workers=%d: unexpected error: %v", workers, err)
+		}
+		if stats.RowsAccepted != 2 || stats.RowsRejected != 3 {
+			t.Errorf("workers=%d: got %+v, want 2 accepted and 3 rejected", workers, stats)
+		}
+		if rejects.String() != want {
+			t.Errorf("workers=%d: rejects:\ngot:\n%q\nwant:\n%q", workers, rejects.String(), want)
+		}
+	}
+}
+
+func TestCSVProcessor_LazyQuotes(t *testing.T) {
+	input := `campaign_id,impressions,clicks,spend,conversions
+camp "A",1000,50,100.00,10
+`
+	if _, err := NewCSVProcessor().Process(context.Background(), strings.NewReader(input), NewInMemoryMetricsStore()); err == nil {
+		t.Fatal("expected a bare quote error without lazy quotes")
+	}
+	store := NewInMemoryMetricsStore()
+	if _, err := NewCSVProcessor(WithLazyQuotes()).Process(context.Background(), strings.NewReader(input), store); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if findByCampaignID(store.TopKByCTR(1), `camp "A"`) == nil {
+		t.Errorf("campaign with a stray quote not found")
+	}
+}
+
+func TestCSVProcessor_WithoutHeader(t *testing.T) {
+	input := `camp1,US,1000,50,100.00,10
+camp1,US,1000,50,100.00
+camp2,DE,500,5,10.00,1
+`
+	var rejects strings.Builder
+	p := NewCSVProcessor(
+		WithoutHeader([]string{"campaign_id", "", "impr", "clicks", "spend", "conversions"}),
+		WithColumnMap(map[string]string{"impr": "impressions"}),
+		WithErrorPolicy(ErrorPolicy{Mode: SkipOnError}),
+		WithRejects(NewCSVRejectWriter(&rejects)),
+	)
+	store := NewInMemoryMetricsStore()
+	stats, err := p.Process(context.Background(), strings.NewReader(input), store)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stats.RowsAccepted != 2 || stats.RowsRejected != 1 {
+		t.Errorf("got %+v, want 2 accepted and 1 rejected", stats)
+	}
+	if !strings.Contains(rejects.String(), ",2,") {
+		t.Errorf("expected the short row as line 2 (no header line):\n%s", rejects.String())
+	}
+	if m := findByCampaignID(store.TopKByCTR(10), "camp2"); m == nil || m.TotalImpressions != 500 {
+		t.Errorf("got %v", m)
+	}
+}
+
+func TestCSVProcessor_WithoutHeaderMissingColumns(t *testing.T) {
+	p := NewCSVProcessor(WithoutHeader([]string{"campaign_id", "impressions"}))
+	_, err := p.Process(context.Background(), strings.NewReader("camp1,1\n"), NewInMemoryMetricsStore())
+	if err == nil || !strings.Contains(err.Error(), "missing required columns [clicks spend conversions]") {
+		t.Fatalf("expected missing columns error, got %v", err)
+	}
+}
+
+func TestCSVProcessor_NumberFormat(t *testing.T) {
+	input := `campaign_id;impressions;clicks;spend;conversions;revenue
+camp1;1000;50;"1.234,50 €";10;"2.000"
+camp1;1000;50;0,50;10;"1,5"
+camp1;1000;50;$1,234.50;10;0
+`
+	store := NewInMemoryMetricsStore()
+	p := NewCSVProcessor(WithDelimiter(';'), WithNumberFormat(EUNumbers), WithErrorPolicy(ErrorPolicy{Mode: SkipOnError}))
+	stats, err := p.Process(context.Background(), strings.NewReader(input), store)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stats.RowsAccepted != 2 || stats.RowsRejected != 1 {
+		t.Errorf("got %+v, want 2 accepted and 1 rejected", stats)
+	}
+	m := findByCampaignID(store.TopKByCTR(1), "camp1")
+	if m == nil || m.TotalSpend != amount("1235") || m.TotalRevenue != amount("2001.5") {
+		t.Errorf("got %v, want spend 1235 and revenue 2001.5", m)
+	}
+
+	_, err = NewCSVProcessor(WithDelimiter(';')).Process(context.Background(), strings.NewReader(input), NewInMemoryMetricsStore())
+	want := `line 2: bad spend "1.234,50 €": number format plain: currency symbol '€' is not allowed`
+	if err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+}
+
+func TestParseDelimiter(t *testing.T) {
+	for in, want := range map[string]rune{",": ',', "tab": '\t', `\t`: '\t', "\t": '\t', "TAB": '\t', "semicolon": ';', ";": ';', "pipe": '|', "|": '|', "comma": ','} {
+		got, err := ParseDelimiter(in)
+		if err != nil || got != want {
+			t.Errorf("ParseDelimiter(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	for _, bad := range []string{"", ";;", `"`, "\n", " ", "�"} {
+		if _, err := ParseDelimiter(bad); err == nil {
+			t.Errorf("ParseDelimiter(%q): expected error", bad)
+		}
+	}
+	if r, err := ParseComment("#"); err != nil || r != '#' {
+		t.Errorf("ParseComment(#) = %q, %v", r, err)
+	}
+	if _, err := ParseComment("//"); err == nil {
+		t.Error("ParseComment(//): expected error")
+	}
+}
+
+// endlessRows is an input that never ends. It cancels its context once
+// it has produced rows rows.
+type endlessRows struct {
+	rows   int
+	cancel context.CancelFunc
+	header bool
+}
+
+func (e *endlessRows) Read(p []byte) (int, error) {
+	line := "camp1,100,10,1.00,1\n"
+	if !e.header {
+		e.header = true
+		line = "campaign_id,impressions,clicks,spend,conversions\n"
+	}
+	if e.rows--; e.rows == 0 {
+		e.cancel()
+	}
+	return copy(p, line), nil
+}
+
+func TestCSVProcessor_Cancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	in := &endlessRows{rows: 5000, cancel: cancel}
+	stats, err := NewCSVProcessor().Process(ctx, in, NewInMemoryMetricsStore())
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want context.Canceled", err)
+	}
+	if stats.RowsAccepted > 5000+cancelCheckRows {
+		t.Errorf("parsed %d rows after cancellation, want at most %d", stats.RowsAccepted, 5000+cancelCheckRows)
+	}
+}
+
+func TestCSVProcessor_CancelParallel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	store := NewInMemoryMetricsStore()
+	_, err := NewCSVProcessor(WithWorkers(4)).Process(ctx, strings.NewReader(generateInput(5000)), store)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want context.Canceled", err)
+	}
+	if n := len(store.TopKByCTR(1 << 20)); n != 0 {
+		t.Errorf("got %d campaigns merged after cancellation, want 0", n)
+	}
+}
+
+func TestCSVProcessor_NULInKey(t *testing.T) {
+	input := "campaign_id,country,impressions,clicks,spend,conversions\n" +
+		"a\x00b,c,100,10,1.00,1\n" +
+		"a,b\x00c,100,10,1.00,1\n" +
+		"a,b,100,10,1.00,1\n"
+	var rejects strings.Builder
+	store := NewInMemoryMetricsStore()
+	p := NewCSVProcessor(
+		WithGroupBy([]string{"campaign_id", "country"}),
+		WithErrorPolicy(ErrorPolicy{Mode: SkipOnError}),
+		WithRejects(NewCSVRejectWriter(&rejects)),
+	)
+	stats, err := p.Process(context.Background(), strings.NewReader(input), store)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stats.RowsAccepted != 1 || stats.RowsRejected != 2 {
+		t.Errorf("got %+v, want 1 accepted and 2 rejected", stats)
+	}
+	for _, m := range store.TopKByCTR(10) {
+		if got := m.Key.Values(); len(got) != 2 {
+			t.Errorf("key %q has %d values, want 2", m.Key, len(got))
+		}
+	}
+	for _, reason := range []string{"campaign_id contains a NUL byte", "country contains a NUL byte"} {
+		if !strings.Contains(rejects.String(), reason) {
+			t.Errorf("rejects lack %q:\n%s", reason, rejects.String())
+		}
+	}
+
+	// Reports index key values by group-by column, so they must line up.
+	var out strings.Builder
+	w := NewStreamReportWriter(&out, 10, WithKeyColumns([]string{"campaign_id", "country"}), WithFormat(FormatJSON))
+	if err := w.WriteReports(context.Background(), store, RunInfo{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
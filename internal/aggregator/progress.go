@@ -0,0 +1,304 @@
+package aggregator
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultProgressInterval is the time between progress reports when
+// WithProgress is given no interval.
+const DefaultProgressInterval = time.Second
+
+// Progress is a snapshot of a running Process call.
+type Progress struct {
+	// Input names the input being read, "" if it has no name.
+	Input string
+	// BytesRead counts the bytes of Input read so far, after
+	// decompression. BytesTotal is its size, or 0 when it is not known
+	// up front, as for compressed or piped input.
+	BytesRead  int64
+	BytesTotal int64
+	// Rows counts the rows read by the processor so far, accepted or
+	// rejected, across all inputs.
+	Rows int64
+	// Campaigns is the number of distinct groups seen so far. While
+	// workers parse in parallel it is a lower bound.
+	Campaigns int
+	// Elapsed is the time since the first input was started, and
+	// InputElapsed since Input was.
+	Elapsed      time.Duration
+	InputElapsed time.Duration
+	// Done is set on the last snapshot of an input.
+	Done bool
+}
+
+// RowsPerSecond returns the average row rate so far.
+func (p Progress) RowsPerSecond() float64 {
+	if p.Elapsed <= 0 {
+		return 0
+	}
+	return float64(p.Rows) / p.Elapsed.Seconds()
+}
+
+// Fraction returns the share of Input read so far, or false if its
+// size is not known.
+func (p Progress) Fraction() (float64, bool) {
+	if p.BytesTotal <= 0 {
+		return 0, false
+	}
+	return min(float64(p.BytesRead)/float64(p.BytesTotal), 1), true
+}
+
+// ETA estimates the time left on Input from its byte rate so far, or
+// returns false if its size is not known or nothing has been read yet.
+func (p Progress) ETA() (time.Duration, bool) {
+	f, ok := p.Fraction()
+	if !ok || f == 0 {
+		return 0, false
+	}
+	return time.Duration(float64(p.InputElapsed) * (1 - f) / f), true
+}
+
+// ProgressReporter receives progress snapshots, every interval while an
+// input is read and once more when it is done. Report is not called
+// concurrently.
+type ProgressReporter interface {
+	Report(Progress)
+}
+
+// WithProgress reports progress to r every interval while Process runs,
+// and when each input is done. A zero interval means
+// DefaultProgressInterval.
+func WithProgress(r ProgressReporter, interval time.Duration) CSVOption {
+	return func(p *csvProcessor) {
+		if interval <= 0 {
+			interval = DefaultProgressInterval
+		}
+		p.progress = &progressTracker{reporter: r, interval: interval}
+	}
+}
+
+// progressTracker counts the work done by the parsers of a processor.
+// Parsers update it at their cancellation checkpoints, from any
+// goroutine, and a ticker reads it. A nil tracker ignores updates.
+type progressTracker struct {
+	reporter ProgressReporter
+	interval time.Duration
+	started  time.Time
+
+	bytes     atomic.Int64
+	rows      atomic.Int64
+	campaigns atomic.Int64
+}
+
+// start begins reporting on an input of total bytes, after rows rows
+// of earlier inputs. The returned function stops the ticker and sends
+// the final snapshot.
+func (t *progressTracker) start(input string, total, rows int64) (stop func()) {
+	if t == nil {
+		return func() {}
+	}
+	now := time.Now()
+	if t.started.IsZero() {
+		t.started = now
+	}
+	t.restart(rows)
+
+	done, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		tick := time.NewTicker(t.interval)
+		defer tick.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-tick.C:
+				t.reporter.Report(t.snapshot(input, total, now, false))
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+		t.reporter.Report(t.snapshot(input, total, now, true))
+	}
+}
+
+// restart discards the progress made on the current input, which is
+// about to be read again from the start.
+func (t *progressTracker) restart(rows int64) {
+	if t == nil {
+		return
+	}
+	t.bytes.Store(0)
+	t.rows.Store(rows)
+}
+
+func (t *progressTracker) snapshot(input string, total int64, inputStarted time.Time, done bool) Progress {
+	now := time.Now()
+	return Progress{
+		Input:        input,
+		BytesRead:    t.bytes.Load(),
+		BytesTotal:   total,
+		Rows:         t.rows.Load(),
+		Campaigns:    int(t.campaigns.Load()),
+		Elapsed:      now.Sub(t.started),
+		InputElapsed: now.Sub(inputStarted),
+		Done:         done,
+	}
+}
+
+// reader counts the bytes read through r.
+func (t *progressTracker) reader(r io.Reader) io.Reader {
+	if t == nil {
+		return r
+	}
+	return &progressReader{r: r, n: &t.bytes}
+}
+
+// checkpoint records rows more rows and the group count of store, which
+// may be a worker-local store.
+func (t *progressTracker) checkpoint(rows int64, store MetricsStore) {
+	if t == nil {
+		return
+	}
+	t.rows.Add(rows)
+	if l, ok := store.(interface{ Len() int }); ok {
+		n := int64(l.Len())
+		for old := t.campaigns.Load(); n > old && !t.campaigns.CompareAndSwap(old, n); old = t.campaigns.Load() {
+		}
+	}
+}
+
+// finish sets the exact totals once an input's parsers are done.
+func (t *progressTracker) finish(rows int64, store MetricsStore) {
+	if t == nil {
+		return
+	}
+	t.rows.Store(rows)
+	if l, ok := store.(interface{ Len() int }); ok {
+		t.campaigns.Store(int64(l.Len()))
+	}
+}
+
+type progressReader struct {
+	r io.Reader
+	n *atomic.Int64
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.n.Add(int64(n))
+	return n, err
+}
+
+// inputSize returns the size of r if it is a regular file or an
+// in-memory reader, or 0.
+func inputSize(r io.Reader) int64 {
+	switch v := r.(type) {
+	case interface{ Stat() (fs.FileInfo, error) }:
+		if info, err := v.Stat(); err == nil && info.Mode().IsRegular() {
+			return info.Size()
+		}
+	case interface{ Size() int64 }:
+		return v.Size()
+	}
+	return 0
+}
+
+// NewTerminalProgress renders progress as a single line on w, a
+// terminal, rewritten in place on every report and ended when an input
+// is done:
+//
+//	ad_data.csv: 512.0 MiB / 1.0 GiB (50.0%), 13421772 rows, 1342177 rows/s, 50000 campaigns, ETA 10s
+func NewTerminalProgress(w io.Writer) ProgressReporter {
+	return terminalProgress{w}
+}
+
+type terminalProgress struct {
+	w io.Writer
+}
+
+func (t terminalProgress) Report(p Progress) {
+	// \r returns to the start of the line and \x1b[K clears the rest of
+	// it, in case the new text is shorter.
+	end := ""
+	if p.Done {
+		end = "\n"
+	}
+	fmt.Fprintf(t.w, "\r%s\x1b[K%s", p, end)
+}
+
+// String formats p as NewTerminalProgress shows it.
+func (p Progress) String() string {
+	s := formatBytes(p.BytesRead)
+	if f, ok := p.Fraction(); ok {
+		s += fmt.Sprintf(" / %s (%.1f%%)", formatBytes(p.BytesTotal), f*100)
+	}
+	if p.Input != "" {
+		s = p.Input + ": " + s
+	}
+	s += fmt.Sprintf(", %d rows, %.0f rows/s, %d campaigns", p.Rows, p.RowsPerSecond(), p.Campaigns)
+	switch eta, ok := p.ETA(); {
+	case p.Done:
+		s += fmt.Sprintf(", done in %s", p.InputElapsed.Round(time.Second/10))
+	case ok:
+		s += fmt.Sprintf(", ETA %s", eta.Round(time.Second))
+	}
+	return s
+}
+
+// formatBytes shows n in binary units: 512 B, 1.5 KiB, 2.0 GiB.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// NewLogProgress logs every report to l as an info record named
+// "progress", with the fields of Progress as attributes; the size,
+// percentage and ETA are left out when the size is not known.
+func NewLogProgress(l *slog.Logger) ProgressReporter {
+	return logProgress{l}
+}
+
+type logProgress struct {
+	l *slog.Logger
+}
+
+func (lp logProgress) Report(p Progress) {
+	attrs := []slog.Attr{
+		slog.String("input", p.Input),
+		slog.Int64("bytes", p.BytesRead),
+	}
+	if f, ok := p.Fraction(); ok {
+		attrs = append(attrs,
+			slog.Int64("total_bytes", p.BytesTotal),
+			slog.Float64("percent", float64(int(f*1000))/10),
+		)
+	}
+	attrs = append(attrs,
+		slog.Int64("rows", p.Rows),
+		slog.Int64("rows_per_sec", int64(p.RowsPerSecond())),
+		slog.Int("campaigns", p.Campaigns),
+		slog.Duration("elapsed", p.Elapsed.Round(time.Millisecond)),
+	)
+	if eta, ok := p.ETA(); ok && !p.Done {
+		attrs = append(attrs, slog.Duration("eta", eta.Round(time.Second)))
+	}
+	attrs = append(attrs, slog.Bool("done", p.Done))
+	lp.l.LogAttrs(context.Background(), slog.LevelInfo, "progress", attrs...)
+}
@@ -0,0 +1,156 @@
+package aggregator
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+// progressRecorder keeps every snapshot it is sent.
+type progressRecorder struct {
+	reports []Progress
+}
+
+func (r *progressRecorder) Report(p Progress) {
+	r.reports = append(r.reports, p)
+}
+
+func (r *progressRecorder) last(t *testing.T) Progress {
+	t.Helper()
+	if len(r.reports) == 0 {
+		t.Fatal("no progress reported")
+	}
+	return r.reports[len(r.reports)-1]
+}
+
+func TestCSVProcessor_Progress(t *testing.T) {
+	input := generateInput(5000)
+	for _, workers := range []int{1, 4} {
+		rec := &progressRecorder{}
+		store := NewInMemoryMetricsStore()
+		p := NewCSVProcessor(WithWorkers(workers), WithProgress(rec, time.Millisecond))
+		if _, err := p.Process(context.Background(), strings.NewReader(input), store); err != nil {
+			t.Fatalf("workers=%d: unexpected error: %v", workers, err)
+		}
+		got := rec.last(t)
+		if !got.Done {
+			t.Errorf("workers=%d: last report is not done: %+v", workers, got)
+		}
+		if got.Rows != 5000 || got.Campaigns != store.Len() {
+			t.Errorf("workers=%d: got %d rows and %d campaigns, want 5000 and %d", workers, got.Rows, got.Campaigns, store.Len())
+		}
+		if got.BytesTotal != int64(len(input)) || got.BytesRead == 0 || got.BytesRead > got.BytesTotal {
+			t.Errorf("workers=%d: got %d of %d bytes, want up to %d", workers, got.BytesRead, got.BytesTotal, len(input))
+		}
+		for _, r := range rec.reports[:len(rec.reports)-1] {
+			if r.Done || r.Rows > got.Rows {
+				t.Errorf("workers=%d: bad intermediate report %+v", workers, r)
+			}
+		}
+	}
+}
+
+func TestCSVProcessor_ProgressAcrossInputs(t *testing.T) {
+	rec := &progressRecorder{}
+	p := NewCSVProcessor(WithProgress(rec, time.Hour))
+	store := NewInMemoryMetricsStore()
+	for _, input := range []string{generateInput(100), generateInput(250)} {
+		if _, err := p.Process(context.Background(), strings.NewReader(input), store); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	// With an hour between ticks, only the final report of each input
+	// is sent.
+	if len(rec.reports) != 2 {
+		t.Fatalf("got %d reports, want 2", len(rec.reports))
+	}
+	if rec.reports[0].Rows != 100 || rec.reports[1].Rows != 350 {
+		t.Errorf("got rows %d and %d, want 100 and 350", rec.reports[0].Rows, rec.reports[1].Rows)
+	}
+	if rec.reports[1].Elapsed < rec.reports[1].InputElapsed {
+		t.Errorf("run elapsed %v is shorter than input elapsed %v", rec.reports[1].Elapsed, rec.reports[1].InputElapsed)
+	}
+}
+
+func TestProgress_Estimates(t *testing.T) {
+	p := Progress{
+		Input:        "ad_data.csv",
+		BytesRead:    512 << 20,
+		BytesTotal:   1 << 30,
+		Rows:         2_000_000,
+		Campaigns:    5000,
+		Elapsed:      20 * time.Second,
+		InputElapsed: 10 * time.Second,
+	}
+	if got := p.RowsPerSecond(); got != 100_000 {
+		t.Errorf("rows/s: got %v, want 100000", got)
+	}
+	if f, ok := p.Fraction(); !ok || f != 0.5 {
+		t.Errorf("fraction: got %v, %v, want 0.5", f, ok)
+	}
+	if eta, ok := p.ETA(); !ok || eta != 10*time.Second {
+		t.Errorf("ETA: got %v, %v, want 10s", eta, ok)
+	}
+	want := "ad_data.csv: 512.0 MiB / 1.0 GiB (50.0%), 2000000 rows, 100000 rows/s, 5000 campaigns, ETA 10s"
+	if got := p.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	p.BytesTotal = 0
+	if _, ok := p.ETA(); ok {
+		t.Error("expected no ETA without a size")
+	}
+	want = "ad_data.csv: 512.0 MiB, 2000000 rows, 100000 rows/s, 5000 campaigns"
+	if got := p.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	for n, want := range map[int64]string{
+		0:             "0 B",
+		1023:          "1023 B",
+		1536:          "1.5 KiB",
+		5 << 20:       "5.0 MiB",
+		3 << 40:       "3.0 TiB",
+		(1 << 30) - 1: "1024.0 MiB",
+	} {
+		if got := formatBytes(n); got != want {
+			t.Errorf("%d: got %q, want %q", n, got, want)
+		}
+	}
+}
+
+func TestTerminalProgress(t *testing.T) {
+	var b strings.Builder
+	r := NewTerminalProgress(&b)
+	r.Report(Progress{BytesRead: 10, Rows: 1})
+	r.Report(Progress{BytesRead: 20, Rows: 2, Done: true})
+	want := "\r10 B, 1 rows, 0 rows/s, 0 campaigns\x1b[K" +
+		"\r20 B, 2 rows, 0 rows/s, 0 campaigns, done in 0s\x1b[K\n"
+	if b.String() != want {
+		t.Errorf("got %q, want %q", b.String(), want)
+	}
+}
+
+func TestLogProgress(t *testing.T) {
+	var b strings.Builder
+	logger := slog.New(slog.NewTextHandler(&b, &slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}))
+	r := NewLogProgress(logger)
+	r.Report(Progress{Input: "a.csv", BytesRead: 250, BytesTotal: 1000, Rows: 10, Campaigns: 3, Elapsed: time.Second, InputElapsed: time.Second})
+	r.Report(Progress{Input: "-", BytesRead: 250, Rows: 10, Elapsed: 2 * time.Second, Done: true})
+	want := "level=INFO msg=progress input=a.csv bytes=250 total_bytes=1000 percent=25 rows=10 rows_per_sec=10 campaigns=3 elapsed=1s eta=3s done=false\n" +
+		"level=INFO msg=progress input=- bytes=250 rows=10 rows_per_sec=5 campaigns=0 elapsed=2s done=true\n"
+	if b.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
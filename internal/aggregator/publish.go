@@ -0,0 +1,206 @@
+package aggregator
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// reportFileMode is the permission of published report files.
+const reportFileMode = 0o644
+
+// stagedFile is a complete file waiting under a temporary name in the
+// directory of path until it is published, with its size and hex
+// SHA-256.
+type stagedFile struct {
+	tmp, path string
+	size      int64
+	sha256    string
+}
+
+// stageFile writes the output of encode to a hidden temporary file next
+// to path and syncs it to disk, so that publishing it is a rename. The
+// temporary file is removed again if anything fails.
+func stageFile(path string, encode func(io.Writer) error) (stagedFile, error) {
+	dir, name := filepath.Split(path)
+	f, err := os.CreateTemp(dir, "."+name+".tmp-*")
+	if err != nil {
+		return stagedFile{}, fmt.Errorf("create %s: %w", path, err)
+	}
+	h := sha256.New()
+	cw := &countingWriter{w: io.MultiWriter(f, h)}
+	err = encode(cw)
+	if err == nil {
+		err = f.Chmod(reportFileMode)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return stagedFile{}, fmt.Errorf("write %s: %w", path, err)
+	}
+	return stagedFile{tmp: f.Name(), path: path, size: cw.n, sha256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// publishFiles renames every staged file onto its path and syncs dir so
+// the renames survive a crash. Each rename atomically replaces the
+// previous file, so readers see an old or a new report, never a
+// truncated one. The previous files are kept under hidden backup names
+// until every rename has succeeded; if one fails, the renames already
+// done are rolled back from the backups, so the directory keeps the
+// complete previous set, and the files not yet renamed are discarded.
+// Once every rename has succeeded the new set is live and cannot be
+// rolled back, so a failure to sync dir is only logged as a warning
+// that the renames may not survive a crash.
+func publishFiles(dir string, files []stagedFile) error {
+	backups := make([]backupFile, 0, len(files))
+	for i, f := range files {
+		b, err := backup(f.path)
+		if err == nil {
+			err = os.Rename(f.tmp, f.path)
+			if err != nil {
+				b.remove()
+			}
+		}
+		if err != nil {
+			discardFiles(files[i:])
+			restoreBackups(backups)
+			return fmt.Errorf("publish %s: %w", f.path, err)
+		}
+		backups = append(backups, b)
+	}
+	for _, b := range backups {
+		b.remove()
+	}
+	if err := syncDir(dir); err != nil {
+		slog.Warn("published reports may not survive a crash", "error", err)
+	}
+	return nil
+}
+
+// backupFile is a hidden copy of a file about to be replaced. saved is
+// empty if there was no file at path.
+type backupFile struct {
+	path, saved string
+}
+
+// backup keeps the current contents of path under a hidden name in the
+// same directory, as a hard link where the file system supports it and
+// as a copy otherwise. path itself stays in place until it is replaced.
+func backup(path string) (backupFile, error) {
+	src, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return backupFile{path: path}, nil
+	}
+	if err != nil {
+		return backupFile{}, fmt.Errorf("back up %s: %w", path, err)
+	}
+	defer src.Close()
+
+	dir, name := filepath.Split(path)
+	f, err := os.CreateTemp(dir, "."+name+".bak-*")
+	if err != nil {
+		return backupFile{}, fmt.Errorf("back up %s: %w", path, err)
+	}
+	saved := f.Name()
+	f.Close()
+	if os.Remove(saved) == nil && os.Link(path, saved) == nil {
+		return backupFile{path: path, saved: saved}, nil
+	}
+
+	f, err = os.OpenFile(saved, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, reportFileMode)
+	if err == nil {
+		_, err = io.Copy(f, src)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		os.Remove(saved)
+		return backupFile{}, fmt.Errorf("back up %s: %w", path, err)
+	}
+	return backupFile{path: path, saved: saved}, nil
+}
+
+func (b backupFile) remove() {
+	if b.saved != "" {
+		os.Remove(b.saved)
+	}
+}
+
+// restoreBackups puts back the files replaced since backups were taken,
+// newest first, and removes the files that did not exist before.
+func restoreBackups(backups []backupFile) {
+	for i := len(backups) - 1; i >= 0; i-- {
+		b := backups[i]
+		if b.saved == "" {
+			os.Remove(b.path)
+			continue
+		}
+		os.Rename(b.saved, b.path)
+	}
+}
+
+// removeStale removes the reports listed in the previous manifest of
+// dir, prev, that the new manifest next does not list, so that a run
+// writing fewer reports than the last does not leave old ones behind.
+// Files that no manifest lists are left alone.
+func removeStale(dir string, prev *Manifest, next Manifest) {
+	if prev == nil {
+		return
+	}
+	keep := make(map[string]bool, len(next.Reports))
+	for _, rep := range next.Reports {
+		keep[rep.File] = true
+	}
+	for _, rep := range prev.Reports {
+		if keep[rep.File] || rep.File != filepath.Base(rep.File) || rep.File == ManifestName {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, rep.File)); err != nil && !os.IsNotExist(err) {
+			slog.Warn("could not remove stale report", "path", filepath.Join(dir, rep.File), "error", err)
+		}
+	}
+}
+
+// discardFiles removes staged files that will not be published.
+func discardFiles(files []stagedFile) {
+	for _, f := range files {
+		os.Remove(f.tmp)
+	}
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("sync %s: %w", dir, err)
+	}
+	err = d.Sync()
+	if cerr := d.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("sync %s: %w", dir, err)
+	}
+	return nil
+}
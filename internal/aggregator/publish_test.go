@@ -0,0 +1,173 @@
+package aggregator
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestStageFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "top10_ctr.csv")
+	f, err := stageFile(path, func(w io.Writer) error {
+		_, err := io.WriteString(w, "new\n")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if filepath.Dir(f.tmp) != filepath.Clean(dir) || !strings.HasPrefix(filepath.Base(f.tmp), ".top10_ctr.csv.tmp-") {
+		t.Errorf("got temporary file %s, want a hidden file in %s", f.tmp, dir)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("report published before publishFiles: %v", err)
+	}
+
+	if err := publishFiles(dir, []stagedFile{f}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || string(data) != "new\n" {
+		t.Errorf("got %q, %v, want the staged content", data, err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != reportFileMode {
+		t.Errorf("got mode %v, %v, want %v", info.Mode().Perm(), err, os.FileMode(reportFileMode))
+	}
+	assertOnlyFiles(t, dir, "top10_ctr.csv")
+}
+
+func TestStageFile_EncodeError(t *testing.T) {
+	dir := t.TempDir()
+	_, err := stageFile(filepath.Join(dir, "top10_ctr.csv"), func(w io.Writer) error {
+		io.WriteString(w, "partial")
+		return errors.New("disk full")
+	})
+	if err == nil || !strings.Contains(err.Error(), "disk full") {
+		t.Fatalf("got error %v, want disk full", err)
+	}
+	assertOnlyFiles(t, dir)
+}
+
+func TestFileReportWriter_KeepsPreviousReportsOnFailure(t *testing.T) {
+	dir := t.TempDir()
+	old := NewInMemoryMetricsStore()
+	old.Add("old", 1000, 50, amount("100"), 5)
+	w := NewFileReportWriter(dir, 10)
+	if err := w.WriteReports(context.Background(), old, RunInfo{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	before := readDir(t, dir)
+
+	store := NewInMemoryMetricsStore()
+	store.Add("new", 1000, 50, amount("100"), 5)
+	// The first report is staged before the run is cancelled.
+	ctx := &cancelAfter{Context: context.Background(), n: 1}
+	if err := w.WriteReports(ctx, store, RunInfo{}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want context.Canceled", err)
+	}
+	after := readDir(t, dir)
+	if len(after) != len(before) {
+		t.Fatalf("got files %v, want %v", after, before)
+	}
+	for name, data := range before {
+		if after[name] != data {
+			t.Errorf("%s changed:\ngot:\n%s\nwant:\n%s", name, after[name], data)
+		}
+	}
+}
+
+func TestPublishFiles_RollsBackOnFailure(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.csv"), []byte("old a\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// A non-empty directory cannot be replaced by a file, so publishing
+	// b.csv fails after a.csv and c.csv have been renamed.
+	if err := os.MkdirAll(filepath.Join(dir, "b.csv", "x"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	var files []stagedFile
+	for _, name := range []string{"a.csv", "c.csv", "b.csv"} {
+		f, err := stageFile(filepath.Join(dir, name), func(w io.Writer) error {
+			_, err := io.WriteString(w, "new\n")
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, f)
+	}
+
+	if err := publishFiles(dir, files); err == nil || !strings.Contains(err.Error(), "b.csv") {
+		t.Fatalf("got error %v, want a b.csv failure", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "a.csv")); err != nil || string(data) != "old a\n" {
+		t.Errorf("a.csv: got %q, %v, want the previous content", data, err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if got := strings.Join(names, ","); got != "a.csv,b.csv" {
+		t.Errorf("got files %s, want a.csv,b.csv", got)
+	}
+}
+
+func TestFileReportWriter_RemovesStaleReports(t *testing.T) {
+	dir := t.TempDir()
+	store := NewInMemoryMetricsStore()
+	store.Add("camp1", 1000, 50, amount("100"), 5)
+	if err := NewFileReportWriter(dir, 10).WriteReports(context.Background(), store, RunInfo{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("mine\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := NewFileReportWriter(dir, 5).WriteReports(context.Background(), store, RunInfo{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertOnlyFiles(t, dir, ManifestName, "notes.txt", "top5_cpa.csv", "top5_ctr.csv")
+	if _, err := VerifyManifest(dir); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+// readDir returns the content of every file in dir by name.
+func readDir(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string, len(entries))
+	for _, e := range entries {
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[e.Name()] = string(data)
+	}
+	return files
+}
+
+func assertOnlyFiles(t *testing.T, dir string, names ...string) {
+	t.Helper()
+	var got []string
+	for name := range readDir(t, dir) {
+		got = append(got, name)
+	}
+	sort.Strings(got)
+	if strings.Join(got, ",") != strings.Join(names, ",") {
+		t.Errorf("got files %v, want %v", got, names)
+	}
+}
@@ -0,0 +1,89 @@
+package aggregator
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// RejectWriter receives the rows dropped under SkipOnError (and the row
+// that aborts a run under FailOnError). source names the input the row
+// came from, if known. raw is the text of the row exactly as it appears
+// in the input, without its line ending; a quoted field can make it
+// span several lines.
+type RejectWriter interface {
+	Reject(source string, line int, reason string, raw string) error
+	Flush() error
+}
+
+type csvRejectWriter struct {
+	cw          *csv.Writer
+	wroteHeader bool
+}
+
+// NewCSVRejectWriter writes rejected rows to w as CSV with the columns
+// source, line, reason and record, where record is the original row as
+// read, in the input's own delimiter and quoting.
+func NewCSVRejectWriter(w io.Writer) RejectWriter {
+	return &csvRejectWriter{cw: csv.NewWriter(w)}
+}
+
+func (w *csvRejectWriter) Reject(source string, line int, reason string, raw string) error {
+	if !w.wroteHeader {
+		if err := w.cw.Write([]string{"source", "line", "reason", "record"}); err != nil {
+			return err
+		}
+		w.wroteHeader = true
+	}
+	return w.cw.Write([]string{source, strconv.Itoa(line), reason, raw})
+}
+
+func (w *csvRejectWriter) Flush() error {
+	w.cw.Flush()
+	if err := w.cw.Error(); err != nil {
+		return fmt.Errorf("flush rejects: %w", err)
+	}
+	return nil
+}
+
+// recordTap sits between an input and its csv.Reader and keeps the
+// bytes read until the records holding them are done with, so that a
+// rejected row can be written out as it appeared in the input.
+type recordTap struct {
+	r    io.Reader
+	buf  []byte
+	base int64 // input offset of buf[0]
+	line int   // input line number of buf[0]
+}
+
+func newRecordTap(r io.Reader) *recordTap {
+	return &recordTap{r: r, line: 1}
+}
+
+func (t *recordTap) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	t.buf = append(t.buf, p[:n]...)
+	return n, err
+}
+
+// record discards the input up to offset end, where the last record
+// read ends, and returns the text of that record, which starts on line
+// start. Lines before it, blank or comments that the csv.Reader
+// skipped, and its line ending are left out. The result is only valid
+// until the next Read.
+func (t *recordTap) record(start int, end int64) []byte {
+	raw := t.buf[:end-t.base]
+	t.buf, t.base = t.buf[end-t.base:], end
+	for ; t.line < start; t.line++ {
+		i := bytes.IndexByte(raw, '\n')
+		if i < 0 {
+			break
+		}
+		raw = raw[i+1:]
+	}
+	t.line += bytes.Count(raw, []byte{'\n'})
+	raw = bytes.TrimSuffix(raw, []byte{'\n'})
+	return bytes.TrimSuffix(raw, []byte{'\r'})
+}
@@ -1,88 +1,672 @@
 package aggregator
 
 import (
+	"context"
 	"encoding/csv"
 	"fmt"
 	"io"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
+	"time"
 )
 
-type fileReportWriter struct {
-	outputDir string
-	topK      int
+// Format is a report output encoding.
+type Format string
+
+const (
+	FormatCSV     Format = "csv"
+	FormatJSON    Format = "json"
+	FormatJSONL   Format = "jsonl"
+	FormatParquet Format = "parquet"
+)
+
+// ParseFormat maps the --format flag values to a Format.
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(s); f {
+	case FormatCSV, FormatJSON, FormatJSONL, FormatParquet:
+		return f, nil
+	}
+	return "", fmt.Errorf("invalid format %q; want csv, json, jsonl or parquet", s)
+}
+
+// RankMode selects the value reports are ranked by.
+type RankMode string
+
+const (
+	// RankRaw ranks by the observed CTR and CPA.
+	RankRaw RankMode = "raw"
+	// RankSmoothed ranks by empirical-Bayes estimates that pull small
+	// campaigns towards the population, so a handful of lucky clicks or
+	// one cheap conversion no longer tops a report.
+	RankSmoothed RankMode = "smoothed"
+	// RankLower ranks CTR by its lower Wilson bound, and CPA by the CPA
+	// implied by the lower Wilson bound of the conversion rate, so
+	// rankings favour rates that are reliably high.
+	RankLower RankMode = "lower"
+)
+
+// ParseRankMode maps the --rank-by flag values to a RankMode.
+func ParseRankMode(s string) (RankMode, error) {
+	switch m := RankMode(s); m {
+	case RankRaw, RankSmoothed, RankLower:
+		return m, nil
+	}
+	return "", fmt.Errorf("invalid rank mode %q; want raw, smoothed or lower", s)
+}
+
+// reportOptions holds the settings shared by every ReportWriter.
+type reportOptions struct {
+	topK        int
+	keyColumns  []string
+	format      Format
+	eligibility Eligibility
+	rankBy      RankMode
+	confidence  float64
+	moneyDigits int
+	reports     []string
+	extra       []string
+	metrics     []CustomMetric
+	ranks       []CustomRank
+	specs       []ReportSpec
+	now         func() time.Time
+	version     string
+	flags       map[string]string
+	csvComments bool
+}
+
+// ReportOption configures a ReportWriter.
+type ReportOption func(*reportOptions)
+
+// WithKeyColumns names the group-by columns, one report column each.
+// It must match the group-by used to fill the store. The default is
+// DefaultGroupBy.
+func WithKeyColumns(columns []string) ReportOption {
+	return func(o *reportOptions) {
+		o.keyColumns = columns
+	}
+}
+
+// WithFormat selects the output encoding. The default is FormatCSV.
+func WithFormat(f Format) ReportOption {
+	return func(o *reportOptions) {
+		o.format = f
+	}
+}
+
+// WithEligibility drops campaigns below the given volumes from every
+// report before ranking. The thresholds are recorded in the output.
+func WithEligibility(e Eligibility) ReportOption {
+	return func(o *reportOptions) {
+		o.eligibility = e
+	}
+}
+
+// WithEligibilityComments writes the eligibility thresholds of CSV
+// reports as a leading "# eligibility: ..." comment line, for readers
+// that skip comments. It is off by default because other CSV readers
+// take that line for the header; the thresholds are always recorded in
+// the manifest and in JSON and Parquet metadata.
+func WithEligibilityComments() ReportOption {
+	return func(o *reportOptions) {
+		o.csvComments = true
+	}
+}
+
+// WithRankBy selects the ranking. With RankSmoothed the reports gain
+// CTR_smoothed and CPA_smoothed columns next to the raw values; with
+// RankLower they gain the interval columns of WithConfidence, at
+// DefaultConfidence unless a level is set. The default is RankRaw.
+func WithRankBy(m RankMode) ReportOption {
+	return func(o *reportOptions) {
+		o.rankBy = m
+	}
+}
+
+// WithConfidence adds Wilson score interval columns at the given
+// two-sided confidence level: CTR_lower and CTR_upper after CTR, and
+// CVR_lower and CVR_upper for the conversion rate (conversions /
+// clicks) at the end. Zero, the default, leaves them out.
+func WithConfidence(level float64) ReportOption {
+	return func(o *reportOptions) {
+		o.confidence = level
+	}
+}
+
+// WithReports selects the metrics to write a top-K report for, each
+// named top{K}_{metric}: ctr, cpa, cpc, cpm, cvr, roas or profit. Every
+// report shows the column of its metric. The default is DefaultReports.
+func WithReports(names []string) ReportOption {
+	return func(o *reportOptions) {
+		o.reports = names
+	}
+}
+
+// WithColumns adds derived columns (cpc, cpm, cvr, roas, profit, or
+// revenue for total_revenue) to every report.
+func WithColumns(names []string) ReportOption {
+	return func(o *reportOptions) {
+		o.extra = names
+	}
+}
+
+// WithMetrics adds a column per custom metric to every report, after
+// the built-in metrics. Names must pass ValidateCustomMetrics.
+func WithMetrics(metrics []CustomMetric) ReportOption {
+	return func(o *reportOptions) {
+		o.metrics = metrics
+	}
+}
+
+// WithRanks adds a top-K report per rank, after those of WithReports.
+// Ranks of metrics not given to WithMetrics are ignored.
+func WithRanks(ranks []CustomRank) ReportOption {
+	return func(o *reportOptions) {
+		o.ranks = ranks
+	}
+}
+
+// WithMoneyPrecision sets the decimal places of the money columns,
+// total_spend and total_revenue, in CSV; the default is 2. Amounts are
+// exact to MoneyScale places and rounded half away from zero for
+// display. JSON always shows them exactly.
+func WithMoneyPrecision(digits int) ReportOption {
+	return func(o *reportOptions) {
+		o.moneyDigits = digits
+	}
 }
 
-func NewFileReportWriter(outputDir string, topK int) ReportWriter {
+func newReportOptions(topK int, opts []ReportOption) reportOptions {
 	if topK <= 0 {
 		topK = 10
 	}
-	return &fileReportWriter{outputDir: outputDir, topK: topK}
+	o := reportOptions{topK: topK, keyColumns: DefaultGroupBy, format: FormatCSV, rankBy: RankRaw, reports: DefaultReports, moneyDigits: spendColumn.digits, now: time.Now}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// report is one ranked table, named after its output file stem. Its
+// columns follow the key columns in every row. Reports from a
+// ReportConfig carry their resolved spec, and a format when it differs
+// from the writer's.
+type report struct {
+	name        string
+	rows        []*CampaignMetrics
+	columns     []reportColumn
+	eligibility Eligibility
+	format      Format
+	spec        *ReportSpec
+}
+
+// fileMetadata narrows the run metadata to rep for its own file.
+func (rep report) fileMetadata(meta reportMetadata) reportMetadata {
+	meta.Eligibility = nil
+	if !rep.eligibility.IsZero() {
+		e := rep.eligibility
+		meta.Eligibility = &e
+	}
+	if rep.spec != nil {
+		meta.TopK = rep.spec.TopK
+		meta.ReportConfigs = []ReportSpec{*rep.spec}
+	}
+	return meta
+}
+
+// buildReports ranks store into the selected reports, or the reports of
+// WithReportConfig when set. It also returns the prior fitted from store
+// when smoothed values are ranked by or shown; otherwise nil. The rank
+// mode applies to CTR and CPA; other metrics rank raw.
+func (o reportOptions) buildReports(store MetricsStore) ([]report, *empiricalPrior) {
+	var prior *empiricalPrior
+	if o.rankBy == RankSmoothed || o.selectsColumn(smoothedColumnNames...) {
+		p := fitPrior(store)
+		prior = &p
+	}
+	shown := prior
+	if o.rankBy != RankSmoothed {
+		shown = nil
+	}
+	columns := o.columns(shown)
+	if o.specs != nil {
+		return o.specReports(store, prior, columns), prior
+	}
+
+	reps := make([]report, 0, len(o.reports)+len(o.ranks))
+	for _, name := range o.reports {
+		m, ok := lookupMetric(name)
+		if !ok {
+			continue
+		}
+		rank := valueRanking(o.rankValue(m, prior), m.desc, m.volume)
+		reps = append(reps, report{
+			name:        fmt.Sprintf("top%d_%s", o.topK, name),
+			rows:        topK(store, o.topK, rank, m.eligible, o.eligibility),
+			columns:     columns,
+			eligibility: o.eligibility,
+		})
+	}
+	for _, r := range o.ranks {
+		if rep, ok := o.customReport(store, r, columns); ok {
+			reps = append(reps, rep)
+		}
+	}
+	return reps, prior
 }
 
-func (w *fileReportWriter) WriteReports(store MetricsStore) error {
+// rankValue returns the value m is ranked by: the raw metric or, for CTR
+// and CPA, the smoothed estimate or interval bound of the rank mode.
+func (o reportOptions) rankValue(m metric, prior *empiricalPrior) func(*CampaignMetrics) float64 {
+	switch {
+	case o.rankBy == RankSmoothed && m.name == "ctr":
+		return prior.ctr
+	case o.rankBy == RankSmoothed && m.name == "cpa":
+		return prior.cpa
+	case o.rankBy == RankLower && m.name == "ctr":
+		return ctrLowerBound(zScore(o.intervalLevel()))
+	case o.rankBy == RankLower && m.name == "cpa":
+		return cpaAtCVRLowerBound(zScore(o.intervalLevel()))
+	}
+	return m.value
+}
+
+// topK returns the top k groups of store under rank among those accepted
+// by defined (when not nil) and meeting e.
+func topK(store MetricsStore, k int, rank Ranking, defined Filter, e Eligibility) []*CampaignMetrics {
+	var filters []Filter
+	if defined != nil {
+		filters = append(filters, defined)
+	}
+	if !e.IsZero() {
+		filters = append(filters, e.Allows)
+	}
+	return store.TopK(k, rank, filters...)
+}
+
+// metadata describes the run for formats that carry an envelope.
+func (o reportOptions) metadata(run RunInfo, prior *empiricalPrior) reportMetadata {
+	inputs := run.Inputs
+	if inputs == nil {
+		inputs = []string{}
+	}
+	meta := reportMetadata{
+		Inputs:       inputs,
+		RowsAccepted: run.Stats.RowsAccepted,
+		RowsRejected: run.Stats.RowsRejected,
+		Violations:   run.Stats.Violations,
+		GeneratedAt:  o.now().UTC(),
+		TopK:         o.topK,
+	}
+	if !o.eligibility.IsZero() {
+		e := o.eligibility
+		meta.Eligibility = &e
+	}
+	if o.rankBy != RankRaw {
+		meta.RankBy = o.rankBy
+	}
+	meta.Prior = prior
+	meta.Confidence = o.intervalLevel()
+	if meta.Confidence == 0 && o.selectsColumn(boundColumnNames...) {
+		meta.Confidence = DefaultConfidence
+	}
+	meta.Metrics = o.metrics
+	meta.Ranks = o.ranks
+	return meta
+}
+
+// reportEncoder serialises reports in one output format. The file
+// writer calls encodeFile once per report; the stream writer passes
+// every report to encodeStream as a single document.
+type reportEncoder interface {
+	encodeFile(w io.Writer, rep report, meta reportMetadata) error
+	encodeStream(w io.Writer, reps []report, meta reportMetadata) error
+}
+
+func (o reportOptions) encoder(f Format) reportEncoder {
+	switch f {
+	case FormatJSON:
+		return jsonEncoder{keyColumns: o.keyColumns}
+	case FormatJSONL:
+		return jsonEncoder{keyColumns: o.keyColumns, lines: true}
+	case FormatParquet:
+		return parquetEncoder{keyColumns: o.keyColumns}
+	}
+	return csvEncoder{keyColumns: o.keyColumns, comments: o.csvComments}
+}
+
+// reportFormat returns the format rep is written in: its own, or the
+// format of the run.
+func (o reportOptions) reportFormat(rep report) Format {
+	if rep.format != "" {
+		return rep.format
+	}
+	return o.format
+}
+
+type fileReportWriter struct {
+	reportOptions
+	outputDir string
+}
+
+func NewFileReportWriter(outputDir string, topK int, opts ...ReportOption) ReportWriter {
+	return &fileReportWriter{
+		reportOptions: newReportOptions(topK, opts),
+		outputDir:     outputDir,
+	}
+}
+
+// WriteReports writes one file per report and a manifest.json
+// describing them. The set is published all or nothing: every file is
+// first written to a temporary file in the output directory and synced,
+// and only once all of them are complete are they renamed over the
+// previous ones, the manifest last. If ctx is done or any file fails
+// before then, the previous reports are left untouched, and if a rename
+// fails, those already done are rolled back. Once the new set is in
+// place, reports listed in the previous manifest but not in the new one
+// are removed; other files in the directory are never touched.
+func (w *fileReportWriter) WriteReports(ctx context.Context, store MetricsStore, run RunInfo) error {
+	reps, prior := w.buildReports(store)
+	for _, rep := range reps {
+		if format := w.reportFormat(rep); isManifestFile(rep.name, format) {
+			return fmt.Errorf("report %s: file name %s.%s is reserved for the run manifest", rep.name, rep.name, format)
+		}
+	}
 	if err := os.MkdirAll(w.outputDir, 0o755); err != nil {
 		return fmt.Errorf("create output dir: %w", err)
 	}
 
-	ctrData := store.TopKByCTR(w.topK)
-	ctrPath := filepath.Join(w.outputDir, fmt.Sprintf("top%d_ctr.csv", w.topK))
-	if err := writeMetricsFile(ctrPath, reportHeader, ctrData, fullRow); err != nil {
-		return err
+	meta := w.metadata(run, prior)
+	staged := make([]stagedFile, 0, len(reps)+1)
+	described := make([]ManifestReport, 0, len(reps))
+	for _, rep := range reps {
+		if err := ctx.Err(); err != nil {
+			discardFiles(staged)
+			return err
+		}
+		format := w.reportFormat(rep)
+		enc := w.encoder(format)
+		path := filepath.Join(w.outputDir, rep.name+"."+string(format))
+		fileMeta := rep.fileMetadata(meta)
+		f, err := stageFile(path, func(f io.Writer) error {
+			return enc.encodeFile(f, rep, fileMeta)
+		})
+		if err != nil {
+			discardFiles(staged)
+			return err
+		}
+		staged = append(staged, f)
+		described = append(described, ManifestReport{
+			File:        filepath.Base(path),
+			Size:        f.size,
+			SHA256:      f.sha256,
+			Rows:        len(rep.rows),
+			Eligibility: fileMeta.Eligibility,
+		})
+		slog.Debug("wrote report", "path", path, "campaigns", len(rep.rows))
 	}
-	slog.Debug("wrote report", "path", ctrPath, "campaigns", len(ctrData))
 
-	cpaData := store.TopKByCPA(w.topK)
-	cpaPath := filepath.Join(w.outputDir, fmt.Sprintf("top%d_cpa.csv", w.topK))
-	if err := writeMetricsFile(cpaPath, reportHeader, cpaData, fullRow); err != nil {
+	manifest := w.manifest(run, described)
+	f, err := stageFile(filepath.Join(w.outputDir, ManifestName), func(f io.Writer) error {
+		return writeJSON(f, manifest)
+	})
+	if err == nil {
+		staged = append(staged, f)
+		err = ctx.Err()
+	}
+	if err != nil {
+		discardFiles(staged)
 		return err
 	}
-	slog.Debug("wrote report", "path", cpaPath, "campaigns", len(cpaData))
 
+	prev, _ := readManifest(w.outputDir)
+	if err := publishFiles(w.outputDir, staged); err != nil {
+		return err
+	}
+	removeStale(w.outputDir, prev, manifest)
 	return nil
 }
 
-func writeMetricsFile(
-	path string,
-	header []string,
-	rows []*CampaignMetrics,
-	toRow func(*CampaignMetrics) []string,
-) error {
-	f, err := os.Create(path)
-	if err != nil {
-		return fmt.Errorf("create %s: %w", path, err)
+// reportColumn is one computed column following the key columns. value
+// returns an int64, a float64 or, for nullable columns, nil when the
+// value is undefined (an empty CSV field, JSON null or Parquet null).
+// Money columns return Money, shown exactly in JSON and as a double in
+// Parquet.
+type reportColumn struct {
+	name     string
+	float    bool // float64 or Money values; int64 otherwise
+	money    bool // Money values
+	nullable bool
+	digits   int // decimal places of float values in CSV
+	value    func(m *CampaignMetrics) any
+}
+
+var (
+	impressionsColumn = reportColumn{name: "total_impressions", value: func(m *CampaignMetrics) any { return m.TotalImpressions }}
+	clicksColumn      = reportColumn{name: "total_clicks", value: func(m *CampaignMetrics) any { return m.TotalClicks }}
+	spendColumn       = reportColumn{name: "total_spend", float: true, money: true, digits: 2, value: func(m *CampaignMetrics) any { return m.TotalSpend }}
+	conversionsColumn = reportColumn{name: "total_conversions", value: func(m *CampaignMetrics) any { return m.TotalConversions }}
+	ctrColumn         = reportColumn{name: "CTR", float: true, digits: 4, value: func(m *CampaignMetrics) any { return m.CTR() }}
+	cpaColumn         = cpaLikeColumn("CPA", (*CampaignMetrics).CPA)
+)
+
+// baseColumns follow the group-by columns in every report.
+var baseColumns = []reportColumn{
+	impressionsColumn, clicksColumn, spendColumn, conversionsColumn, ctrColumn, cpaColumn,
+}
+
+// intervalLevel returns the confidence level of the interval columns,
+// or 0 when they are not shown.
+func (o reportOptions) intervalLevel() float64 {
+	if o.confidence == 0 && o.rankBy == RankLower {
+		return DefaultConfidence
 	}
-	defer f.Close()
+	return o.confidence
+}
 
-	if err := writeCSV(f, header, rows, toRow); err != nil {
-		return fmt.Errorf("write %s: %w", path, err)
+// columns returns baseColumns with the optional columns inserted: the
+// smoothed estimates of prior (when not nil) next to the raw CTR and
+// CPA, the interval bounds when a confidence level is set, and the
+// derived and custom metric columns before the CVR bounds. Money
+// columns show o.moneyDigits decimal places.
+func (o reportOptions) columns(prior *empiricalPrior) []reportColumn {
+	columns := slices.Clone(o.layout(prior))
+	for i := range columns {
+		if columns[i].money {
+			columns[i].digits = o.moneyDigits
+		}
 	}
-	return nil
+	return columns
 }
 
-var reportHeader = []string{
-	"campaign_id", "total_impressions", "total_clicks",
-	"total_spend", "total_conversions", "CTR", "CPA",
+func (o reportOptions) layout(prior *empiricalPrior) []reportColumn {
+	level := o.intervalLevel()
+	extra := o.extraColumns()
+	for _, m := range o.metrics {
+		extra = append(extra, customColumn(m))
+	}
+	if prior == nil && level == 0 && len(extra) == 0 {
+		return baseColumns
+	}
+	var ctrBounds, cvrBounds []reportColumn
+	if level > 0 {
+		ctrBounds, cvrBounds = intervalColumns(zScore(level))
+	}
+
+	columns := []reportColumn{impressionsColumn, clicksColumn, spendColumn, conversionsColumn, ctrColumn}
+	if prior != nil {
+		p := *prior
+		columns = append(columns, reportColumn{
+			name: "CTR_smoothed", float: true, digits: 4,
+			value: func(m *CampaignMetrics) any { return p.ctr(m) },
+		})
+	}
+	columns = append(columns, ctrBounds...)
+	columns = append(columns, cpaColumn)
+	if prior != nil {
+		columns = append(columns, cpaLikeColumn("CPA_smoothed", prior.cpa))
+	}
+	columns = append(columns, extra...)
+	return append(columns, cvrBounds...)
+}
+
+// cpaLikeColumn is a per-conversion value, null without conversions.
+func cpaLikeColumn(name string, value func(*CampaignMetrics) float64) reportColumn {
+	return reportColumn{
+		name: name, float: true, nullable: true, digits: 2,
+		value: func(m *CampaignMetrics) any {
+			if m.TotalConversions == 0 {
+				return nil
+			}
+			return value(m)
+		},
+	}
 }
 
-func fullRow(m *CampaignMetrics) []string {
-	cpa := ""
-	if m.TotalConversions > 0 {
-		cpa = strconv.FormatFloat(m.CPA(), 'f', 2, 64)
+// csvHeader returns the key column names followed by columns.
+func csvHeader(keyColumns []string, columns []reportColumn) []string {
+	header := slices.Clone(keyColumns)
+	for _, c := range columns {
+		header = append(header, c.name)
 	}
-	return []string{
-		m.CampaignID,
-		strconv.FormatInt(m.TotalImpressions, 10),
-		strconv.FormatInt(m.TotalClicks, 10),
-		strconv.FormatFloat(m.TotalSpend, 'f', 2, 64),
-		strconv.FormatInt(m.TotalConversions, 10),
-		strconv.FormatFloat(m.CTR(), 'f', 4, 64),
-		cpa,
+	return header
+}
+
+// csvRow formats m with fixed decimals per column.
+func csvRow(columns []reportColumn) func(*CampaignMetrics) []string {
+	return func(m *CampaignMetrics) []string {
+		row := m.Key.Values()
+		for _, c := range columns {
+			switch v := c.value(m).(type) {
+			case int64:
+				row = append(row, strconv.FormatInt(v, 10))
+			case float64:
+				row = append(row, strconv.FormatFloat(v, 'f', c.digits, 64))
+			case Money:
+				row = append(row, v.Format(c.digits))
+			default:
+				row = append(row, "")
+			}
+		}
+		return row
 	}
 }
 
+// streamColumns returns the union of the columns of reps, in order of
+// first appearance, for formats that stream every report as one table.
+// A column missing from some report is nullable.
+func streamColumns(reps []report) []reportColumn {
+	if len(reps) == 0 {
+		return baseColumns
+	}
+	var columns []reportColumn
+	for _, rep := range reps {
+		for _, c := range rep.columns {
+			if !slices.ContainsFunc(columns, func(u reportColumn) bool { return u.name == c.name }) {
+				columns = append(columns, c)
+			}
+		}
+	}
+	for i, c := range columns {
+		for _, rep := range reps {
+			if !slices.ContainsFunc(rep.columns, func(r reportColumn) bool { return r.name == c.name }) {
+				columns[i].nullable = true
+			}
+		}
+	}
+	return columns
+}
+
+// alignColumns returns the columns of rep matching union by name, with
+// a null column for each one rep does not show.
+func (rep report) alignColumns(union []reportColumn) []reportColumn {
+	aligned := make([]reportColumn, len(union))
+	for i, u := range union {
+		j := slices.IndexFunc(rep.columns, func(c reportColumn) bool { return c.name == u.name })
+		if j < 0 {
+			u.value = func(*CampaignMetrics) any { return nil }
+			aligned[i] = u
+			continue
+		}
+		aligned[i] = rep.columns[j]
+	}
+	return aligned
+}
+
+// csvEncoder writes plain CSV tables with formatted numbers. A stream
+// holding several reports becomes one table with a leading report
+// column. CSV has no metadata envelope; with comments set, eligibility
+// thresholds are written as a leading "# eligibility: ..." comment
+// line, and in a stream, reports with their own thresholds add a
+// "# eligibility <report>: ..." line each.
+type csvEncoder struct {
+	keyColumns []string
+	comments   bool
+}
+
+func (e csvEncoder) encodeFile(w io.Writer, rep report, meta reportMetadata) error {
+	if e.comments {
+		if err := writeEligibilityComment(w, meta.Eligibility); err != nil {
+			return err
+		}
+	}
+	return writeCSV(w, csvHeader(e.keyColumns, rep.columns), rep.rows, csvRow(rep.columns))
+}
+
+func (e csvEncoder) encodeStream(w io.Writer, reps []report, meta reportMetadata) error {
+	if e.comments {
+		if err := writeStreamEligibilityComments(w, reps, meta.Eligibility); err != nil {
+			return err
+		}
+	}
+	columns := streamColumns(reps)
+	cw := csv.NewWriter(w)
+	if err := cw.Write(append([]string{"report"}, csvHeader(e.keyColumns, columns)...)); err != nil {
+		return fmt.Errorf("write header: %w", err)
+	}
+	for _, rep := range reps {
+		toRow := csvRow(rep.alignColumns(columns))
+		for _, m := range rep.rows {
+			if err := cw.Write(append([]string{rep.name}, toRow(m)...)); err != nil {
+				return fmt.Errorf("write row: %w", err)
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeEligibilityComment(w io.Writer, e *Eligibility) error {
+	if e == nil {
+		return nil
+	}
+	if _, err := fmt.Fprintf(w, "# eligibility: %s\n", e); err != nil {
+		return fmt.Errorf("write eligibility: %w", err)
+	}
+	return nil
+}
+
+func writeStreamEligibilityComments(w io.Writer, reps []report, e *Eligibility) error {
+	if err := writeEligibilityComment(w, e); err != nil {
+		return err
+	}
+	var run Eligibility
+	if e != nil {
+		run = *e
+	}
+	for _, rep := range reps {
+		if rep.eligibility == run {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "# eligibility %s: %s\n", rep.name, rep.eligibility); err != nil {
+			return fmt.Errorf("write eligibility: %w", err)
+		}
+	}
+	return nil
+}
+
 func writeCSV(
 	w io.Writer,
 	header []string,
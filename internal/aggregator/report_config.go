@@ -0,0 +1,368 @@
+package aggregator
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// ReportConfig declares the reports of a run, replacing the reports
+// chosen with WithReports and WithRanks. It is read from a JSON or YAML
+// --config file:
+//
+//	{
+//	  "metrics": [{"name": "eCPC", "expr": "spend/clicks"}],
+//	  "reports": [
+//	    {"name": "best_ctr", "metric": "ctr", "top_k": 20,
+//	     "filters": {"min_impressions": 1000}},
+//	    {"name": "cheap_clicks", "metric": "eCPC", "order": "asc",
+//	     "columns": ["total_clicks", "total_spend", "eCPC"],
+//	     "precision": 3, "format": "json"}
+//	  ]
+//	}
+type ReportConfig struct {
+	Metrics []CustomMetric `json:"metrics,omitempty"`
+	Reports []ReportSpec   `json:"reports"`
+}
+
+// ReportSpec is one report of a ReportConfig, written to name.<format>.
+//
+// Metric is a built-in metric (ctr, cpa, cpc, cpm, cvr, roas, profit)
+// or a custom metric of the config. Order defaults to the natural
+// direction of a built-in metric and is required for a custom one. TopK
+// defaults to the writer's K, Format to its format. Filters add to the
+// run's eligibility thresholds, the stricter value winning. Columns
+// lists the columns after the key columns by name, ignoring case; by
+// default they are the columns of the run. Precision sets the decimal
+// places of every float column in CSV.
+type ReportSpec struct {
+	Name      string       `json:"name"`
+	Metric    string       `json:"metric"`
+	Order     SortOrder    `json:"order,omitempty"`
+	TopK      int          `json:"top_k,omitempty"`
+	Filters   *Eligibility `json:"filters,omitempty"`
+	Columns   []string     `json:"columns,omitempty"`
+	Precision *int         `json:"precision,omitempty"`
+	Format    Format       `json:"format,omitempty"`
+}
+
+// maxPrecision bounds ReportSpec.Precision; float64 has about 17
+// significant digits.
+const maxPrecision = 17
+
+var (
+	smoothedColumnNames = []string{"CTR_smoothed", "CPA_smoothed"}
+	boundColumnNames    = []string{"CTR_lower", "CTR_upper", "CVR_lower", "CVR_upper"}
+)
+
+// LoadReportConfig reads a config file. Files ending in .yaml or .yml
+// are YAML, in the subset described by decodeYAML; anything else is
+// JSON. Unknown fields are rejected. The config is not validated.
+func LoadReportConfig(path string) (*ReportConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+	cfg, err := ParseReportConfig(data, isYAMLPath(path))
+	if err != nil {
+		return nil, fmt.Errorf("config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+func isYAMLPath(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return true
+	}
+	return false
+}
+
+// ParseReportConfig decodes a config from JSON, or from YAML when yaml
+// is set.
+func ParseReportConfig(data []byte, yaml bool) (*ReportConfig, error) {
+	if yaml {
+		doc, err := decodeYAML(data)
+		if err != nil {
+			return nil, err
+		}
+		if data, err = json.Marshal(doc); err != nil {
+			return nil, err
+		}
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var cfg ReportConfig
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, errors.New("unexpected data after the config object")
+	}
+	return &cfg, nil
+}
+
+// UnmarshalText compiles an expression read from a config file.
+func (e *Expr) UnmarshalText(b []byte) error {
+	x, err := ParseExpr(string(b))
+	if err != nil {
+		return err
+	}
+	*e = *x
+	return nil
+}
+
+// Validate checks c against the group-by keyColumns: there is at least
+// one report, custom metrics pass ValidateCustomMetrics, and every
+// report has a unique file name, a known metric, a valid order, K,
+// filters, columns, precision and format. A report with its own format
+// must not be named after ManifestName; CheckFiles covers the others.
+func (c *ReportConfig) Validate(keyColumns []string) error {
+	if len(c.Reports) == 0 {
+		return errors.New("config: no reports")
+	}
+	for _, m := range c.Metrics {
+		if !isIdentifier(m.Name) {
+			return fmt.Errorf("config: metric %q: name must be letters, digits and underscores, not starting with a digit", m.Name)
+		}
+		if m.Expr == nil {
+			return fmt.Errorf("config: metric %s: missing expr", m.Name)
+		}
+	}
+	if err := ValidateCustomMetrics(c.Metrics, nil, keyColumns); err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+
+	var columns []string
+	for _, col := range (reportOptions{metrics: c.Metrics}).catalog(nil) {
+		columns = append(columns, col.name)
+	}
+	var names []string
+	for i, spec := range c.Reports {
+		if err := spec.validate(c.Metrics, columns); err != nil {
+			return fmt.Errorf("config: report %d (%s): %w", i+1, spec.Name, err)
+		}
+		if containsFold(names, spec.Name) {
+			return fmt.Errorf("config: report %d (%s): name used by an earlier report", i+1, spec.Name)
+		}
+		names = append(names, spec.Name)
+	}
+	return nil
+}
+
+func (s ReportSpec) validate(metrics []CustomMetric, columns []string) error {
+	if !isFileStem(s.Name) {
+		return errors.New("name must be letters, digits, '_', '-' and '.', not starting with '.'")
+	}
+	_, builtin := lookupMetric(s.Metric)
+	custom := slices.ContainsFunc(metrics, func(m CustomMetric) bool { return m.Name == s.Metric })
+	switch {
+	case s.Metric == "":
+		return errors.New("missing metric")
+	case !builtin && !custom:
+		return fmt.Errorf("unknown metric %q; want %s or a metric of the config", s.Metric, strings.Join(metricNames(), ", "))
+	}
+	switch s.Order {
+	case Ascending, Descending:
+	case "":
+		if custom {
+			return fmt.Errorf("order of custom metric %s is required; want asc or desc", s.Metric)
+		}
+	default:
+		return fmt.Errorf("invalid order %q; want asc or desc", s.Order)
+	}
+	if s.TopK < 0 {
+		return fmt.Errorf("invalid top_k %d; want a positive number", s.TopK)
+	}
+	if s.Filters != nil {
+		if err := s.Filters.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, name := range s.Columns {
+		if !containsFold(columns, name) {
+			return fmt.Errorf("unknown column %q; want one of %s", name, strings.Join(columns, ", "))
+		}
+	}
+	if s.Precision != nil && (*s.Precision < 0 || *s.Precision > maxPrecision) {
+		return fmt.Errorf("invalid precision %d; want 0 to %d", *s.Precision, maxPrecision)
+	}
+	if s.Format != "" {
+		if _, err := ParseFormat(string(s.Format)); err != nil {
+			return err
+		}
+		if isManifestFile(s.Name, s.Format) {
+			return fmt.Errorf("file name %s.%s is reserved for the run manifest", s.Name, s.Format)
+		}
+	}
+	return nil
+}
+
+// CheckStream rejects reports whose format differs from f, the format
+// of a stream, which holds every report in one document.
+func (c *ReportConfig) CheckStream(f Format) error {
+	for _, spec := range c.Reports {
+		if spec.Format != "" && spec.Format != f {
+			return fmt.Errorf("config: report %s: format %s cannot be written to a %s stream", spec.Name, spec.Format, f)
+		}
+	}
+	return nil
+}
+
+// CheckFiles rejects reports without a format of their own that would
+// be written to ManifestName when f is the format of the file writer.
+func (c *ReportConfig) CheckFiles(f Format) error {
+	for _, spec := range c.Reports {
+		if spec.Format == "" && isManifestFile(spec.Name, f) {
+			return fmt.Errorf("config: report %s: file name %s.%s is reserved for the run manifest", spec.Name, spec.Name, f)
+		}
+	}
+	return nil
+}
+
+// isManifestFile reports whether a report named name in format f would
+// be written over the run manifest, ignoring case as some file systems
+// do.
+func isManifestFile(name string, f Format) bool {
+	return strings.EqualFold(name+"."+string(f), ManifestName)
+}
+
+func isFileStem(s string) bool {
+	if s == "" || s[0] == '.' {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; !isIdentStart(c) && !isDigit(c) && c != '-' && c != '.' {
+			return false
+		}
+	}
+	return true
+}
+
+// WithReportConfig writes the reports of cfg, which must be valid,
+// instead of those of WithReports and WithRanks, and adds its custom
+// metrics as columns.
+func WithReportConfig(cfg *ReportConfig) ReportOption {
+	return func(o *reportOptions) {
+		o.metrics = cfg.Metrics
+		o.specs = cfg.Reports
+		o.ranks = nil
+		o.reports = nil
+		for _, spec := range cfg.Reports {
+			if _, ok := lookupMetric(spec.Metric); ok && !slices.Contains(o.reports, spec.Metric) {
+				o.reports = append(o.reports, spec.Metric)
+			}
+		}
+	}
+}
+
+// specReports ranks store into the reports of o.specs. Reports without
+// their own columns show columns.
+func (o reportOptions) specReports(store MetricsStore, prior *empiricalPrior, columns []reportColumn) []report {
+	catalog := o.catalog(prior)
+	reps := make([]report, 0, len(o.specs))
+	for _, spec := range o.specs {
+		spec := spec
+		var (
+			value   func(*CampaignMetrics) float64
+			defined Filter
+			volume  = impressionsVolume
+		)
+		if m, ok := lookupMetric(spec.Metric); ok {
+			value, defined, volume = o.rankValue(m, prior), m.eligible, m.volume
+			if spec.Order == "" {
+				spec.Order = Ascending
+				if m.desc {
+					spec.Order = Descending
+				}
+			}
+		} else if m, ok := o.lookupCustom(spec.Metric); ok {
+			value, defined = exprValue(m.Expr)
+		} else {
+			continue
+		}
+		if spec.TopK == 0 {
+			spec.TopK = o.topK
+		}
+		e := o.eligibility
+		if spec.Filters != nil {
+			e = e.merge(*spec.Filters)
+		}
+
+		cols := columns
+		if len(spec.Columns) > 0 {
+			cols = selectColumns(catalog, spec.Columns)
+		}
+		if spec.Precision != nil {
+			cols = withDigits(cols, *spec.Precision)
+		}
+		rank := valueRanking(value, spec.Order == Descending, volume)
+		reps = append(reps, report{
+			name:        spec.Name,
+			rows:        topK(store, spec.TopK, rank, defined, e),
+			columns:     cols,
+			eligibility: e,
+			format:      spec.Format,
+			spec:        &spec,
+		})
+	}
+	return reps
+}
+
+// catalog returns every column a report can select, in display order:
+// the columns of a run showing all derived and custom metrics, the
+// interval bounds (at DefaultConfidence unless a level is set) and the
+// smoothed estimates of prior. A nil prior only serves for the names.
+func (o reportOptions) catalog(prior *empiricalPrior) []reportColumn {
+	all := o
+	all.extra = append(metricNames(), revenueColumn)
+	if all.intervalLevel() == 0 {
+		all.confidence = DefaultConfidence
+	}
+	if prior == nil {
+		prior = &empiricalPrior{}
+	}
+	return all.columns(prior)
+}
+
+// selectsColumn reports whether a spec selects any of names.
+func (o reportOptions) selectsColumn(names ...string) bool {
+	for _, spec := range o.specs {
+		for _, name := range spec.Columns {
+			if containsFold(names, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// selectColumns returns the columns of catalog named by names, in the
+// order of names, ignoring case.
+func selectColumns(catalog []reportColumn, names []string) []reportColumn {
+	var columns []reportColumn
+	for _, name := range names {
+		i := slices.IndexFunc(catalog, func(c reportColumn) bool { return strings.EqualFold(c.name, name) })
+		if i >= 0 {
+			columns = append(columns, catalog[i])
+		}
+	}
+	return columns
+}
+
+// withDigits returns columns with every float column shown to digits
+// decimal places.
+func withDigits(columns []reportColumn, digits int) []reportColumn {
+	out := slices.Clone(columns)
+	for i := range out {
+		if out[i].float {
+			out[i].digits = digits
+		}
+	}
+	return out
+}
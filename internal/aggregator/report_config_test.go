@@ -0,0 +1,234 @@
+package aggregator
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func loadTestConfig(t *testing.T, name string) *ReportConfig {
+	t.Helper()
+	cfg, err := LoadReportConfig(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("load %s: %v", name, err)
+	}
+	if err := cfg.Validate(DefaultGroupBy); err != nil {
+		t.Fatalf("validate %s: %v", name, err)
+	}
+	return cfg
+}
+
+func TestLoadReportConfig_YAMLMatchesJSON(t *testing.T) {
+	fromJSON := loadTestConfig(t, "reports.json")
+	fromYAML := loadTestConfig(t, "reports.yaml")
+	a, _ := json.Marshal(fromJSON)
+	b, _ := json.Marshal(fromYAML)
+	if string(a) != string(b) {
+		t.Errorf("YAML config differs from JSON:\n%s\n%s", b, a)
+	}
+	if len(fromJSON.Reports) != 2 || *fromJSON.Reports[1].Precision != 3 || fromJSON.Reports[0].Filters.MinImpressions != 1000 {
+		t.Errorf("unexpected config: %s", a)
+	}
+}
+
+func TestParseReportConfig_Errors(t *testing.T) {
+	for src, want := range map[string]string{
+		`{"reports": [{"name": "x", "metric": "ctr", "limit": 3}]}`: `unknown field "limit"`,
+		`{"metrics": [{"name": "m", "expr": "spend/"}]}`:            "invalid expression",
+		`{"reports": []} {}`:                           "unexpected data",
+		`{"reports": [{"name": "x", "top_k": "ten"}]}`: "cannot unmarshal",
+	} {
+		_, err := ParseReportConfig([]byte(src), false)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got %v, want error containing %q", src, err, want)
+		}
+	}
+}
+
+func TestReportConfig_Validate(t *testing.T) {
+	cases := map[string]string{
+		`{"reports": []}`: "no reports",
+		`{"metrics": [{"name": "CTR", "expr": "clicks"}], "reports": [{"name": "x", "metric": "ctr"}]}`: "already used",
+		`{"metrics": [{"name": "m"}], "reports": [{"name": "x", "metric": "m"}]}`:                       "missing expr",
+		`{"reports": [{"name": "../x", "metric": "ctr"}]}`:                                              "name must be",
+		`{"reports": [{"name": "x"}]}`:                                                                  "missing metric",
+		`{"reports": [{"name": "x", "metric": "CTR"}]}`:                                                 `unknown metric "CTR"`,
+		`{"metrics": [{"name": "m", "expr": "spend"}], "reports": [{"name": "x", "metric": "m"}]}`:      "order of custom metric m is required",
+		`{"reports": [{"name": "x", "metric": "ctr", "order": "up"}]}`:                                  `invalid order "up"`,
+		`{"reports": [{"name": "x", "metric": "ctr", "top_k": -1}]}`:                                    "invalid top_k",
+		`{"reports": [{"name": "x", "metric": "ctr", "filters": {"min_spend": -1}}]}`:                   "must not be negative",
+		`{"reports": [{"name": "x", "metric": "ctr", "columns": ["spend"]}]}`:                           `unknown column "spend"`,
+		`{"reports": [{"name": "x", "metric": "ctr", "precision": 18}]}`:                                "invalid precision",
+		`{"reports": [{"name": "x", "metric": "ctr", "format": "xml"}]}`:                                `invalid format "xml"`,
+		`{"reports": [{"name": "x", "metric": "ctr"}, {"name": "X", "metric": "cpa"}]}`:                 "report 2 (X): name used by an earlier report",
+		`{"reports": [{"name": "Manifest", "metric": "ctr", "format": "json"}]}`:                        "file name Manifest.json is reserved for the run manifest",
+	}
+	for src, want := range cases {
+		cfg, err := ParseReportConfig([]byte(src), false)
+		if err != nil {
+			t.Fatalf("%s: %v", src, err)
+		}
+		err = cfg.Validate(DefaultGroupBy)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got %v, want error containing %q", src, err, want)
+		}
+	}
+}
+
+func TestReportConfig_CheckStream(t *testing.T) {
+	cfg := loadTestConfig(t, "reports.json")
+	if err := cfg.CheckStream(FormatJSON); err != nil {
+		t.Errorf("json stream: %v", err)
+	}
+	if err := cfg.CheckStream(FormatCSV); err == nil || !strings.Contains(err.Error(), "cheap_clicks") {
+		t.Errorf("csv stream: got %v", err)
+	}
+}
+
+func TestReportConfig_CheckFiles(t *testing.T) {
+	cfg, err := ParseReportConfig([]byte(`{"reports": [{"name": "manifest", "metric": "ctr"}]}`), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Validate(DefaultGroupBy); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := cfg.CheckFiles(FormatCSV); err != nil {
+		t.Errorf("csv files: %v", err)
+	}
+	if err := cfg.CheckFiles(FormatJSON); err == nil || !strings.Contains(err.Error(), "reserved for the run manifest") {
+		t.Errorf("json files: got %v", err)
+	}
+
+	// The writer refuses the report too, before writing anything.
+	dir := filepath.Join(t.TempDir(), "out")
+	w := NewFileReportWriter(dir, 5, WithFormat(FormatJSON), WithReportConfig(cfg))
+	if err := w.WriteReports(context.Background(), configStore(), RunInfo{}); err == nil || !strings.Contains(err.Error(), "reserved for the run manifest") {
+		t.Errorf("got %v, want the report refused", err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("output dir was created: %v", err)
+	}
+}
+
+func configStore() *InMemoryMetricsStore {
+	store := NewInMemoryMetricsStore()
+	store.Add("big", 5000, 100, amount("50.00"), 10)
+	store.Add("mid", 2000, 80, amount("120.00"), 4)
+	store.Add("small", 500, 50, amount("10.00"), 1)
+	store.Add("dud", 4000, 0, amount("20.00"), 0)
+	return store
+}
+
+func TestFileReportWriter_ReportConfig(t *testing.T) {
+	dir := t.TempDir()
+	w := NewFileReportWriter(dir, 10, WithReportConfig(loadTestConfig(t, "reports.json")), fixedClock)
+	if err := w.WriteReports(context.Background(), configStore(), RunInfo{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	entries, _ := os.ReadDir(dir)
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if want := []string{"best_ctr.csv", "cheap_clicks.json", ManifestName}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("got files %v, want %v", names, want)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "best_ctr.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// small is below the report's min_impressions; K is 3.
+	want := "campaign_id,total_impressions,total_clicks,total_spend,total_conversions,CTR,CPA,eCPC\n" +
+		"mid,2000,80,120.00,4,0.0400,30.00,1.5000\n" +
+		"big,5000,100,50.00,10,0.0200,5.00,0.5000\n" +
+		"dud,4000,0,20.00,0,0.0000,,\n"
+	if string(data) != want {
+		t.Errorf("best_ctr.csv: got:\n%s\nwant:\n%s", data, want)
+	}
+
+	var doc struct {
+		Metadata reportMetadata
+		Rows     []map[string]any
+	}
+	data, err = os.ReadFile(filepath.Join(dir, "cheap_clicks.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Rows) != 3 || doc.Rows[0]["campaign_id"] != "small" || len(doc.Rows[0]) != 4 {
+		t.Errorf("cheap_clicks rows: %v", doc.Rows)
+	}
+	spec := doc.Metadata.ReportConfigs
+	if len(spec) != 1 || spec[0].Name != "cheap_clicks" || spec[0].TopK != 10 || doc.Metadata.TopK != 10 {
+		t.Errorf("cheap_clicks metadata: %+v", doc.Metadata)
+	}
+}
+
+func TestReportConfig_OrderAndPrecision(t *testing.T) {
+	cfg, err := ParseReportConfig([]byte(`
+reports:
+  - name: worst_ctr
+    metric: ctr
+    order: asc
+    top_k: 2
+    columns: [CTR, cpc, ctr_smoothed]
+    precision: 1
+`), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Validate(DefaultGroupBy); err != nil {
+		t.Fatal(err)
+	}
+	var buf strings.Builder
+	w := NewStreamReportWriter(&buf, 10, WithReportConfig(cfg))
+	if err := w.WriteReports(context.Background(), configStore(), RunInfo{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Selecting CTR_smoothed fits the prior without smoothed ranking;
+	// its value is only checked for presence.
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 || lines[0] != "report,campaign_id,CTR,CPC,CTR_smoothed" {
+		t.Fatalf("unexpected output:\n%s", buf.String())
+	}
+	if !strings.HasPrefix(lines[1], "worst_ctr,dud,0.0,,0.") || !strings.HasPrefix(lines[2], "worst_ctr,big,0.0,0.5,0.") {
+		t.Errorf("unexpected rows:\n%s", buf.String())
+	}
+}
+
+func TestStreamReportWriter_ReportConfigUnionColumns(t *testing.T) {
+	cfg, err := ParseReportConfig([]byte(`{"reports": [
+		{"name": "a", "metric": "ctr", "top_k": 1, "columns": ["CTR"]},
+		{"name": "b", "metric": "cpc", "top_k": 1, "columns": ["total_clicks", "CPC"], "filters": {"min_clicks": 60}}
+	]}`), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	table := "report,campaign_id,CTR,total_clicks,CPC\n" +
+		"a,small,0.1000,,\n" +
+		"b,big,,100,0.50\n"
+	for _, comments := range []bool{false, true} {
+		opts := []ReportOption{WithReportConfig(cfg)}
+		want := table
+		if comments {
+			opts = append(opts, WithEligibilityComments())
+			want = "# eligibility b: min_impressions=0 min_clicks=60 min_conversions=0 min_spend=0\n" + table
+		}
+		var buf strings.Builder
+		w := NewStreamReportWriter(&buf, 10, opts...)
+		if err := w.WriteReports(context.Background(), configStore(), RunInfo{}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if buf.String() != want {
+			t.Errorf("comments=%v: got:\n%s\nwant:\n%s", comments, buf.String(), want)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package aggregator
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// reportMetadata is the envelope recorded with JSON and JSONL reports.
+// Violations is only present when a row broke a validation rule,
+// eligibility when a threshold is set, the rank mode when it is not
+// raw, the fitted prior when ranking is smoothed, and the confidence
+// level when interval columns are shown, custom metrics and ranks when
+// defined, and the resolved specs of the reports in the document when
+// they come from a ReportConfig.
+type reportMetadata struct {
+	Inputs        []string         `json:"inputs"`
+	RowsAccepted  int64            `json:"rows_accepted"`
+	RowsRejected  int64            `json:"rows_rejected"`
+	Violations    map[string]int64 `json:"violations,omitempty"`
+	GeneratedAt   time.Time        `json:"generated_at"`
+	TopK          int              `json:"top_k"`
+	Eligibility   *Eligibility     `json:"eligibility,omitempty"`
+	RankBy        RankMode         `json:"rank_by,omitempty"`
+	Prior         *empiricalPrior  `json:"prior,omitempty"`
+	Confidence    float64          `json:"confidence,omitempty"`
+	Metrics       []CustomMetric   `json:"metrics,omitempty"`
+	Ranks         []CustomRank     `json:"ranks,omitempty"`
+	ReportConfigs []ReportSpec     `json:"report_configs,omitempty"`
+}
+
+// jsonEncoder writes reports as JSON documents, or as JSON Lines when
+// lines is set. Rows carry the same fields as the CSV header, with
+// numbers left unrounded and nullable columns such as CPA null for
+// campaigns without conversions.
+//
+// A JSON report file is {"report", "metadata", "rows"}; a JSON stream is
+// {"metadata", "reports": [{"report", "rows"}, ...]}. In JSON Lines the
+// first line holds the metadata (and, in a file, the report name) and
+// every following line is one row; in a stream each row also names its
+// report.
+type jsonEncoder struct {
+	keyColumns []string
+	lines      bool
+}
+
+type jsonReport struct {
+	Report   string            `json:"report"`
+	Metadata *reportMetadata   `json:"metadata,omitempty"`
+	Rows     []json.RawMessage `json:"rows"`
+}
+
+func (e jsonEncoder) encodeFile(w io.Writer, rep report, meta reportMetadata) error {
+	rows, err := e.rows(rep, false)
+	if err != nil {
+		return err
+	}
+	if e.lines {
+		return writeJSONLines(w, struct {
+			Report   string         `json:"report"`
+			Metadata reportMetadata `json:"metadata"`
+		}{rep.name, meta}, rows)
+	}
+	return writeJSON(w, jsonReport{Report: rep.name, Metadata: &meta, Rows: rows})
+}
+
+func (e jsonEncoder) encodeStream(w io.Writer, reps []report, meta reportMetadata) error {
+	if e.lines {
+		var rows []json.RawMessage
+		for _, rep := range reps {
+			r, err := e.rows(rep, true)
+			if err != nil {
+				return err
+			}
+			rows = append(rows, r...)
+		}
+		return writeJSONLines(w, struct {
+			Metadata reportMetadata `json:"metadata"`
+		}{meta}, rows)
+	}
+
+	doc := struct {
+		Metadata reportMetadata `json:"metadata"`
+		Reports  []jsonReport   `json:"reports"`
+	}{Metadata: meta, Reports: make([]jsonReport, 0, len(reps))}
+	for _, rep := range reps {
+		rows, err := e.rows(rep, false)
+		if err != nil {
+			return err
+		}
+		doc.Reports = append(doc.Reports, jsonReport{Report: rep.name, Rows: rows})
+	}
+	return writeJSON(w, doc)
+}
+
+// rows encodes every row of rep, optionally tagged with the report name.
+func (e jsonEncoder) rows(rep report, withReport bool) ([]json.RawMessage, error) {
+	rows := make([]json.RawMessage, 0, len(rep.rows))
+	for _, m := range rep.rows {
+		name := ""
+		if withReport {
+			name = rep.name
+		}
+		row, err := e.row(name, m, rep.columns)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// row encodes m as an object whose keys follow the CSV column order.
+// encoding/json sorts map keys, so the object is assembled by hand.
+func (e jsonEncoder) row(reportName string, m *CampaignMetrics, columns []reportColumn) (json.RawMessage, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	field := func(name string, v any) error {
+		if b.Len() > 1 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(name)
+		val, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(val)
+		return nil
+	}
+
+	if reportName != "" {
+		if err := field("report", reportName); err != nil {
+			return nil, err
+		}
+	}
+	for i, v := range m.Key.Values() {
+		if err := field(e.keyColumns[i], v); err != nil {
+			return nil, err
+		}
+	}
+	for _, c := range columns {
+		if err := field(c.name, c.value(m)); err != nil {
+			return nil, err
+		}
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+func writeJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func writeJSONLines(w io.Writer, head any, rows []json.RawMessage) error {
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(head); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if err := enc.Encode(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package aggregator
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fixedClock pins the generated_at timestamp of report metadata.
+func fixedClock(o *reportOptions) {
+	o.now = func() time.Time { return time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC) }
+}
+
+func jsonTestStore() *InMemoryMetricsStore {
+	store := NewInMemoryMetricsStore()
+	store.Add("has_conv", 1000, 100, amount("500.25"), 50)
+	store.Add("no_conv", 3, 1, amount("300.00"), 0)
+	return store
+}
+
+var jsonTestRun = RunInfo{Inputs: []string{"ad_data.csv"}, Stats: ProcessStats{RowsAccepted: 7, RowsRejected: 1}}
+
+func TestFileReportWriter_JSON(t *testing.T) {
+	dir := t.TempDir()
+	w := NewFileReportWriter(dir, 10, WithFormat(FormatJSON), fixedClock)
+	if err := w.WriteReports(context.Background(), jsonTestStore(), jsonTestRun); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "top10_ctr.json"))
+	if err != nil {
+		t.Fatalf("read ctr file: %v", err)
+	}
+	var doc struct {
+		Report   string `json:"report"`
+		Metadata struct {
+			Inputs       []string `json:"inputs"`
+			RowsAccepted int64    `json:"rows_accepted"`
+			RowsRejected int64    `json:"rows_rejected"`
+			GeneratedAt  string   `json:"generated_at"`
+			TopK         int      `json:"top_k"`
+		} `json:"metadata"`
+		Rows []map[string]any `json:"rows"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, data)
+	}
+
+	if doc.Report != "top10_ctr" || doc.Metadata.TopK != 10 || doc.Metadata.RowsAccepted != 7 ||
+		doc.Metadata.RowsRejected != 1 || doc.Metadata.GeneratedAt != "2026-10-16T12:00:00Z" ||
+		len(doc.Metadata.Inputs) != 1 || doc.Metadata.Inputs[0] != "ad_data.csv" {
+		t.Errorf("unexpected envelope: %+v", doc)
+	}
+	if len(doc.Rows) != 2 {
+		t.Fatalf("expected 2 rows, got %d", len(doc.Rows))
+	}
+
+	// no_conv ranks first (CTR 1/3); its values stay unrounded numbers.
+	first := doc.Rows[0]
+	if first["campaign_id"] != "no_conv" {
+		t.Errorf("expected no_conv first, got %v", first["campaign_id"])
+	}
+	if first["CTR"] != 1.0/3 {
+		t.Errorf("CTR: got %v, want unrounded 1/3", first["CTR"])
+	}
+	if v, ok := first["CPA"]; !ok || v != nil {
+		t.Errorf("CPA: got %v, want null", v)
+	}
+	second := doc.Rows[1]
+	if second["total_spend"] != 500.25 || second["total_impressions"] != 1000.0 || second["CPA"] != 10.005 {
+		t.Errorf("unexpected row: %v", second)
+	}
+}
+
+func TestFileReportWriter_JSONFieldOrder(t *testing.T) {
+	dir := t.TempDir()
+	w := NewFileReportWriter(dir, 10, WithFormat(FormatJSONL), fixedClock)
+	if err := w.WriteReports(context.Background(), jsonTestStore(), jsonTestRun); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "top10_cpa.jsonl"))
+	if err != nil {
+		t.Fatalf("read cpa file: %v", err)
+	}
+	want := `{"report":"top10_cpa","metadata":{"inputs":["ad_data.csv"],"rows_accepted":7,"rows_rejected":1,"generated_at":"2026-10-16T12:00:00Z","top_k":10}}
+{"campaign_id":"has_conv","total_impressions":1000,"total_clicks":100,"total_spend":500.25,"total_conversions":50,"CTR":0.1,"CPA":10.005}
+`
+	if string(data) != want {
+		t.Errorf("got:\n%s\nwant:\n%s", data, want)
+	}
+}
+
+func TestStreamReportWriter_JSONL(t *testing.T) {
+	var buf strings.Builder
+	w := NewStreamReportWriter(&buf, 1, WithFormat(FormatJSONL), fixedClock)
+	if err := w.WriteReports(context.Background(), jsonTestStore(), RunInfo{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := `{"metadata":{"inputs":[],"rows_accepted":0,"rows_rejected":0,"generated_at":"2026-10-16T12:00:00Z","top_k":1}}
+{"report":"top1_ctr","campaign_id":"no_conv","total_impressions":3,"total_clicks":1,"total_spend":300,"total_conversions":0,"CTR":0.3333333333333333,"CPA":null}
+{"report":"top1_cpa","campaign_id":"has_conv","total_impressions":1000,"total_clicks":100,"total_spend":500.25,"total_conversions":50,"CTR":0.1,"CPA":10.005}
+`
+	if buf.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestStreamReportWriter_JSON(t *testing.T) {
+	var buf strings.Builder
+	w := NewStreamReportWriter(&buf, 10, WithFormat(FormatJSON), fixedClock)
+	if err := w.WriteReports(context.Background(), jsonTestStore(), jsonTestRun); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var doc struct {
+		Metadata map[string]any `json:"metadata"`
+		Reports  []struct {
+			Report string           `json:"report"`
+			Rows   []map[string]any `json:"rows"`
+		} `json:"reports"`
+	}
+	if err := json.Unmarshal([]byte(buf.String()), &doc); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if len(doc.Reports) != 2 || doc.Reports[0].Report != "top10_ctr" || doc.Reports[1].Report != "top10_cpa" {
+		t.Fatalf("unexpected reports: %+v", doc.Reports)
+	}
+	if len(doc.Reports[1].Rows) != 1 {
+		t.Errorf("expected 1 CPA row, got %d", len(doc.Reports[1].Rows))
+	}
+	if doc.Metadata["top_k"] != 10.0 {
+		t.Errorf("unexpected metadata: %v", doc.Metadata)
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for _, s := range []string{"csv", "json", "jsonl"} {
+		if f, err := ParseFormat(s); err != nil || string(f) != s {
+			t.Errorf("%q: got %q, %v", s, f, err)
+		}
+	}
+	if _, err := ParseFormat("xml"); err == nil {
+		t.Error("expected error for unknown format")
+	}
+}
+
+func TestFileReportWriter_JSONEligibility(t *testing.T) {
+	dir := t.TempDir()
+	e := Eligibility{MinImpressions: 100, MinClicks: 5}
+	w := NewFileReportWriter(dir, 10, WithFormat(FormatJSON), WithEligibility(e), fixedClock)
+	if err := w.WriteReports(context.Background(), jsonTestStore(), jsonTestRun); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "top10_ctr.json"))
+	if err != nil {
+		t.Fatalf("read ctr file: %v", err)
+	}
+	var doc struct {
+		Metadata struct {
+			Eligibility *Eligibility `json:"eligibility"`
+		} `json:"metadata"`
+		Rows []map[string]any `json:"rows"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, data)
+	}
+	if doc.Metadata.Eligibility == nil || *doc.Metadata.Eligibility != e {
+		t.Errorf("eligibility: got %+v, want %+v", doc.Metadata.Eligibility, e)
+	}
+	// no_conv has 3 impressions and is excluded.
+	if len(doc.Rows) != 1 || doc.Rows[0]["campaign_id"] != "has_conv" {
+		t.Errorf("unexpected rows: %v", doc.Rows)
+	}
+}
+
+func TestFileReportWriter_JSONRankSmoothed(t *testing.T) {
+	dir := t.TempDir()
+	w := NewFileReportWriter(dir, 10, WithFormat(FormatJSON), WithRankBy(RankSmoothed), fixedClock)
+	if err := w.WriteReports(context.Background(), jsonTestStore(), jsonTestRun); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "top10_ctr.json"))
+	if err != nil {
+		t.Fatalf("read ctr file: %v", err)
+	}
+	var doc struct {
+		Metadata struct {
+			RankBy string             `json:"rank_by"`
+			Prior  map[string]float64 `json:"prior"`
+		} `json:"metadata"`
+		Rows []map[string]any `json:"rows"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, data)
+	}
+	if doc.Metadata.RankBy != "smoothed" || len(doc.Metadata.Prior) != 4 {
+		t.Errorf("unexpected metadata: %+v", doc.Metadata)
+	}
+	for _, row := range doc.Rows {
+		if _, ok := row["CTR_smoothed"].(float64); !ok {
+			t.Errorf("CTR_smoothed missing or not a number: %v", row)
+		}
+		if v, ok := row["CPA_smoothed"]; !ok || (row["CPA"] == nil) != (v == nil) {
+			t.Errorf("CPA_smoothed should be null exactly when CPA is: %v", row)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package aggregator
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// metric is a derived value that reports can rank by and show as a
+// column. Cost metrics are null, and never ranked, when their
+// denominator is zero; rates are 0.
+type metric struct {
+	name     string // --reports value and report name suffix
+	column   reportColumn
+	value    func(*CampaignMetrics) float64
+	desc     bool // higher is better
+	volume   func(*CampaignMetrics) float64
+	eligible Filter // groups the metric is defined for; nil for all
+}
+
+// DefaultReports are the reports written when none are selected.
+var DefaultReports = []string{"ctr", "cpa"}
+
+var (
+	revenueColumnSpec = reportColumn{name: "total_revenue", float: true, money: true, nullable: true, digits: 2,
+		value: revenueOnly(func(m *CampaignMetrics) any { return m.TotalRevenue })}
+
+	metrics = []metric{
+		{name: "ctr", column: ctrColumn, value: (*CampaignMetrics).CTR, desc: true, volume: impressionsVolume},
+		{name: "cpa", column: cpaColumn, value: (*CampaignMetrics).CPA, volume: conversionsVolume, eligible: hasConversions},
+		{
+			name:   "cpc",
+			column: costColumn("CPC", (*CampaignMetrics).CPC, hasClicks),
+			value:  (*CampaignMetrics).CPC, volume: clicksVolume, eligible: hasClicks,
+		},
+		{
+			name:   "cpm",
+			column: costColumn("CPM", (*CampaignMetrics).CPM, hasImpressions),
+			value:  (*CampaignMetrics).CPM, volume: impressionsVolume, eligible: hasImpressions,
+		},
+		{
+			name:   "cvr",
+			column: reportColumn{name: "CVR", float: true, digits: 4, value: func(m *CampaignMetrics) any { return m.CVR() }},
+			value:  (*CampaignMetrics).CVR, desc: true, volume: clicksVolume,
+		},
+		{
+			name: "roas",
+			column: reportColumn{name: "ROAS", float: true, nullable: true, digits: 4, value: func(m *CampaignMetrics) any {
+				if !hasROAS(m) {
+					return nil
+				}
+				return m.ROAS()
+			}},
+			value: (*CampaignMetrics).ROAS, desc: true, volume: spendVolume, eligible: hasROAS,
+		},
+		{
+			name:   "profit",
+			column: reportColumn{name: "profit", float: true, money: true, nullable: true, digits: 2, value: revenueOnly(profitValue)},
+			value:  (*CampaignMetrics).Profit, desc: true, volume: spendVolume, eligible: hasRevenue,
+		},
+	}
+)
+
+func hasClicks(m *CampaignMetrics) bool      { return m.TotalClicks > 0 }
+func hasImpressions(m *CampaignMetrics) bool { return m.TotalImpressions > 0 }
+func hasRevenue(m *CampaignMetrics) bool     { return m.HasRevenue }
+func hasROAS(m *CampaignMetrics) bool        { return m.HasRevenue && m.TotalSpend > 0 }
+
+// costColumn is a cost per unit, null when defined is false.
+func costColumn(name string, value func(*CampaignMetrics) float64, defined Filter) reportColumn {
+	return reportColumn{
+		name: name, float: true, nullable: true, digits: 2,
+		value: func(m *CampaignMetrics) any {
+			if !defined(m) {
+				return nil
+			}
+			return value(m)
+		},
+	}
+}
+
+// profitValue is the exact profit of m, or its float64 approximation
+// when the difference does not fit in Money.
+func profitValue(m *CampaignMetrics) any {
+	if p, ok := m.exactProfit(); ok {
+		return p
+	}
+	return m.Profit()
+}
+
+// revenueOnly makes value null for groups without revenue.
+func revenueOnly(value func(*CampaignMetrics) any) func(*CampaignMetrics) any {
+	return func(m *CampaignMetrics) any {
+		if !m.HasRevenue {
+			return nil
+		}
+		return value(m)
+	}
+}
+
+func lookupMetric(name string) (metric, bool) {
+	i := slices.IndexFunc(metrics, func(m metric) bool { return m.name == name })
+	if i < 0 {
+		return metric{}, false
+	}
+	return metrics[i], true
+}
+
+func metricNames() []string {
+	names := make([]string, len(metrics))
+	for i, m := range metrics {
+		names[i] = m.name
+	}
+	return names
+}
+
+// ParseReports parses a comma-separated --reports value: the metrics to
+// write a top-K report for.
+func ParseReports(s string) ([]string, error) {
+	return parseNameList("reports", s, metricNames())
+}
+
+// ParseColumns parses a comma-separated --columns value: derived metrics
+// (and "revenue" for total_revenue) to add to every report. CTR and CPA
+// are always present and accepted for convenience.
+func ParseColumns(s string) ([]string, error) {
+	return parseNameList("columns", s, append(metricNames(), revenueColumn))
+}
+
+func parseNameList(flag, s string, valid []string) ([]string, error) {
+	var names []string
+	for _, name := range strings.Split(s, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch {
+		case !slices.Contains(valid, name):
+			return nil, fmt.Errorf("invalid %s %q: unknown name %q; want %s", flag, s, name, strings.Join(valid, ", "))
+		case slices.Contains(names, name):
+			return nil, fmt.Errorf("invalid %s %q: duplicate name %s", flag, s, name)
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// extraColumns returns the derived columns shown beyond baseColumns: the
+// ones selected with WithColumns plus the metric of every report, in
+// registry order with total_revenue first.
+func (o reportOptions) extraColumns() []reportColumn {
+	want := func(name string) bool {
+		return slices.Contains(o.extra, name) || slices.Contains(o.reports, name)
+	}
+	var columns []reportColumn
+	if slices.Contains(o.extra, revenueColumn) {
+		columns = append(columns, revenueColumnSpec)
+	}
+	for _, m := range metrics {
+		if m.name != "ctr" && m.name != "cpa" && want(m.name) {
+			columns = append(columns, m.column)
+		}
+	}
+	return columns
+}
@@ -0,0 +1,120 @@
+package aggregator
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func revenueStore() *InMemoryMetricsStore {
+	store := NewInMemoryMetricsStore()
+	store.Add("good", 1000, 50, amount("100.00"), 5)
+	store.AddRevenue("good", amount("400.00"))
+	store.Add("loss", 2000, 10, amount("200.00"), 0)
+	store.AddRevenue("loss", amount("50.00"))
+	store.Add("unknown", 500, 0, 0, 0)
+	return store
+}
+
+func TestFileReportWriter_Reports(t *testing.T) {
+	dir := t.TempDir()
+	w := NewFileReportWriter(dir, 10, WithReports([]string{"roas", "cpc"}), WithColumns([]string{"revenue"}))
+	if err := w.WriteReports(context.Background(), revenueStore(), RunInfo{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "top10_ctr.csv")); !os.IsNotExist(err) {
+		t.Errorf("top10_ctr.csv written without being selected: %v", err)
+	}
+
+	// Both reports rank good before loss and leave out unknown, which has
+	// neither clicks nor revenue.
+	want := "campaign_id,total_impressions,total_clicks,total_spend,total_conversions,CTR,CPA,total_revenue,CPC,ROAS\n" +
+		"good,1000,50,100.00,5,0.0500,20.00,400.00,2.00,4.0000\n" +
+		"loss,2000,10,200.00,0,0.0050,,50.00,20.00,0.2500\n"
+	for _, name := range []string{"top10_roas.csv", "top10_cpc.csv"} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want {
+			t.Errorf("%s: got:\n%s\nwant:\n%s", name, data, want)
+		}
+	}
+}
+
+func TestFileReportWriter_NullMetricColumns(t *testing.T) {
+	dir := t.TempDir()
+	w := NewFileReportWriter(dir, 10, WithColumns([]string{"cpm", "cvr", "profit"}))
+	if err := w.WriteReports(context.Background(), revenueStore(), RunInfo{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "top10_ctr.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "campaign_id,total_impressions,total_clicks,total_spend,total_conversions,CTR,CPA,CPM,CVR,profit\n" +
+		"good,1000,50,100.00,5,0.0500,20.00,100.00,0.1000,300.00\n" +
+		"loss,2000,10,200.00,0,0.0050,,100.00,0.0000,-150.00\n" +
+		"unknown,500,0,0.00,0,0.0000,,0.00,0.0000,\n"
+	if string(data) != want {
+		t.Errorf("got:\n%s\nwant:\n%s", data, want)
+	}
+}
+
+func TestStore_TopKByMetric(t *testing.T) {
+	store := revenueStore()
+	store.Add("tie", 4000, 20, amount("800.00"), 1) // CPC 40, CPM 200, CVR 0.05
+	store.AddRevenue("tie", amount("800.00"))       // ROAS 1, profit 0
+
+	for _, c := range []struct {
+		metric string
+		want   []string
+	}{
+		{"cpc", []string{"good", "loss", "tie"}},
+		{"cpm", []string{"unknown", "loss", "good", "tie"}}, // good and loss tie on CPM
+		{"cvr", []string{"good", "tie", "loss", "unknown"}},
+		{"roas", []string{"good", "tie", "loss"}},
+		{"profit", []string{"good", "tie", "loss"}},
+	} {
+		o := newReportOptions(10, []ReportOption{WithReports([]string{c.metric})})
+		reps, _ := o.buildReports(store)
+		var got []string
+		for _, m := range reps[0].rows {
+			got = append(got, string(m.Key))
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %v, want %v", c.metric, got, c.want)
+		}
+	}
+}
+
+func TestParseReports(t *testing.T) {
+	got, err := ParseReports(" CTR, roas ,profit")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"ctr", "roas", "profit"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for _, bad := range []string{"", "ctr,,cpa", "revenue", "ctr,ctr", "ecpc"} {
+		if _, err := ParseReports(bad); err == nil {
+			t.Errorf("ParseReports(%q): expected error", bad)
+		}
+	}
+}
+
+func TestParseColumns(t *testing.T) {
+	got, err := ParseColumns("revenue,cvr")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"revenue", "cvr"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, err := ParseColumns("cvr,margin"); err == nil || !strings.Contains(err.Error(), `"margin"`) {
+		t.Errorf("expected unknown column error, got %v", err)
+	}
+}
@@ -0,0 +1,89 @@
+package aggregator
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/khanhduong95/ad-performance-aggregator/internal/parquet"
+)
+
+// parquetEncoder writes reports as Parquet files with a typed schema:
+// the key columns are UTF-8 strings, the totals INT64, total_spend
+// (the double nearest the exact amount) and CTR DOUBLE. CPA, like every
+// nullable column, is an optional DOUBLE, null for campaigns without
+// conversions. Values are not rounded.
+//
+// The report name and the run metadata (as JSON) are stored in the
+// file's key/value metadata under "report" and "metadata". A stream is
+// one table with a leading report column, as in CSV.
+type parquetEncoder struct {
+	keyColumns []string
+}
+
+func (e parquetEncoder) encodeFile(w io.Writer, rep report, meta reportMetadata) error {
+	kv, err := parquetMetadata(meta)
+	if err != nil {
+		return err
+	}
+	kv = append([]parquet.KeyValue{{Key: "report", Value: rep.name}}, kv...)
+	return parquet.Write(w, e.columns([]report{rep}, false), kv)
+}
+
+func (e parquetEncoder) encodeStream(w io.Writer, reps []report, meta reportMetadata) error {
+	kv, err := parquetMetadata(meta)
+	if err != nil {
+		return err
+	}
+	return parquet.Write(w, e.columns(reps, true), kv)
+}
+
+// columns lays out the rows of reps column by column.
+func (e parquetEncoder) columns(reps []report, withReport bool) []parquet.Column {
+	var cols []parquet.Column
+	if withReport {
+		cols = append(cols, parquet.Column{Name: "report", Type: parquet.ByteArray})
+	}
+	for _, name := range e.keyColumns {
+		cols = append(cols, parquet.Column{Name: name, Type: parquet.ByteArray})
+	}
+	metrics := len(cols)
+	columns := streamColumns(reps)
+	for _, c := range columns {
+		typ := parquet.Int64
+		if c.float {
+			typ = parquet.Double
+		}
+		cols = append(cols, parquet.Column{Name: c.name, Type: typ, Optional: c.nullable})
+	}
+
+	for _, rep := range reps {
+		aligned := rep.alignColumns(columns)
+		for _, m := range rep.rows {
+			i := 0
+			if withReport {
+				cols[0].Values = append(cols[0].Values, rep.name)
+				i++
+			}
+			for _, v := range m.Key.Values() {
+				cols[i].Values = append(cols[i].Values, v)
+				i++
+			}
+			for j, c := range aligned {
+				v := c.value(m)
+				if money, ok := v.(Money); ok {
+					v = money.Float64()
+				}
+				cols[metrics+j].Values = append(cols[metrics+j].Values, v)
+			}
+		}
+	}
+	return cols
+}
+
+func parquetMetadata(meta reportMetadata) ([]parquet.KeyValue, error) {
+	b, err := json.Marshal(meta)
+	if err != nil {
+		return nil, err
+	}
+	return []parquet.KeyValue{{Key: "metadata", Value: string(b)}}, nil
+}
@@ -0,0 +1,141 @@
+package aggregator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/khanhduong95/ad-performance-aggregator/internal/parquet"
+)
+
+func readParquet(t *testing.T, data []byte) *parquet.File {
+	t.Helper()
+	f, err := parquet.Read(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("read parquet: %v", err)
+	}
+	return f
+}
+
+func column(t *testing.T, f *parquet.File, name string) parquet.Column {
+	t.Helper()
+	for _, c := range f.Columns {
+		if c.Name == name {
+			return c
+		}
+	}
+	t.Fatalf("missing column %q", name)
+	return parquet.Column{}
+}
+
+func TestFileReportWriter_Parquet(t *testing.T) {
+	dir := t.TempDir()
+	w := NewFileReportWriter(dir, 10, WithFormat(FormatParquet), fixedClock)
+	if err := w.WriteReports(context.Background(), jsonTestStore(), jsonTestRun); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "top10_ctr.parquet"))
+	if err != nil {
+		t.Fatalf("read ctr file: %v", err)
+	}
+	f := readParquet(t, data)
+
+	wantSchema := []struct {
+		name     string
+		typ      parquet.Type
+		optional bool
+	}{
+		{"campaign_id", parquet.ByteArray, false},
+		{"total_impressions", parquet.Int64, false},
+		{"total_clicks", parquet.Int64, false},
+		{"total_spend", parquet.Double, false},
+		{"total_conversions", parquet.Int64, false},
+		{"CTR", parquet.Double, false},
+		{"CPA", parquet.Double, true},
+	}
+	if len(f.Columns) != len(wantSchema) {
+		t.Fatalf("expected %d columns, got %d", len(wantSchema), len(f.Columns))
+	}
+	for i, want := range wantSchema {
+		c := f.Columns[i]
+		if c.Name != want.name || c.Type != want.typ || c.Optional != want.optional {
+			t.Errorf("column %d: got %s %v optional=%v, want %s %v optional=%v",
+				i, c.Name, c.Type, c.Optional, want.name, want.typ, want.optional)
+		}
+	}
+	if f.NumRows != 2 {
+		t.Fatalf("expected 2 rows, got %d", f.NumRows)
+	}
+
+	// no_conv ranks first (CTR 1/3, unrounded) with a null CPA.
+	if got := column(t, f, "campaign_id").Values; got[0] != "no_conv" || got[1] != "has_conv" {
+		t.Errorf("campaign_id: got %v", got)
+	}
+	if got := column(t, f, "CTR").Values[0]; got != 1.0/3 {
+		t.Errorf("CTR: got %v, want unrounded 1/3", got)
+	}
+	if got := column(t, f, "CPA").Values; got[0] != nil || got[1] != 10.005 {
+		t.Errorf("CPA: got %v, want [nil 10.005]", got)
+	}
+	if got := column(t, f, "total_impressions").Values[1]; got != int64(1000) {
+		t.Errorf("total_impressions: got %v", got)
+	}
+	if got := column(t, f, "total_spend").Values[1]; got != 500.25 {
+		t.Errorf("total_spend: got %v", got)
+	}
+
+	if name, _ := f.Lookup("report"); name != "top10_ctr" {
+		t.Errorf("report metadata: got %q", name)
+	}
+	raw, _ := f.Lookup("metadata")
+	var meta reportMetadata
+	if err := json.Unmarshal([]byte(raw), &meta); err != nil {
+		t.Fatalf("metadata is not JSON: %v\n%s", err, raw)
+	}
+	if meta.TopK != 10 || meta.RowsAccepted != 7 || meta.RowsRejected != 1 || len(meta.Inputs) != 1 {
+		t.Errorf("unexpected metadata: %+v", meta)
+	}
+}
+
+func TestFileReportWriter_ParquetGroupBy(t *testing.T) {
+	dir := t.TempDir()
+	store := NewInMemoryMetricsStore()
+	store.Add(NewGroupKey("CMP001", "US"), 100, 10, amount("5"), 1)
+	w := NewFileReportWriter(dir, 5, WithFormat(FormatParquet),
+		WithKeyColumns([]string{"campaign_id", "country"}))
+	if err := w.WriteReports(context.Background(), store, RunInfo{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "top5_cpa.parquet"))
+	if err != nil {
+		t.Fatalf("read cpa file: %v", err)
+	}
+	f := readParquet(t, data)
+	if got := column(t, f, "country"); got.Type != parquet.ByteArray || got.Values[0] != "US" {
+		t.Errorf("country column: %+v", got)
+	}
+}
+
+func TestStreamReportWriter_Parquet(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewStreamReportWriter(&buf, 10, WithFormat(FormatParquet), fixedClock)
+	if err := w.WriteReports(context.Background(), jsonTestStore(), jsonTestRun); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	f := readParquet(t, buf.Bytes())
+	if f.Columns[0].Name != "report" || f.NumRows != 3 {
+		t.Fatalf("expected 3 rows with a leading report column, got %d rows, first column %q",
+			f.NumRows, f.Columns[0].Name)
+	}
+	// no_conv has no CPA, so the CPA report holds one row.
+	want := []any{"top10_ctr", "top10_ctr", "top10_cpa"}
+	for i, v := range f.Columns[0].Values {
+		if v != want[i] {
+			t.Errorf("row %d report: got %v, want %v", i, v, want[i])
+		}
+	}
+}
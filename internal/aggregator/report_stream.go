@@ -0,0 +1,45 @@
+package aggregator
+
+import (
+	"context"
+	"io"
+	"log/slog"
+)
+
+type streamReportWriter struct {
+	reportOptions
+	w io.Writer
+}
+
+// NewStreamReportWriter writes all reports to w as one document instead
+// of one file per report. In CSV a leading report column holds the
+// report name (for example top10_ctr), so the table can be split again
+// downstream. Nothing is written to the filesystem.
+func NewStreamReportWriter(w io.Writer, topK int, opts ...ReportOption) ReportWriter {
+	return &streamReportWriter{
+		reportOptions: newReportOptions(topK, opts),
+		w:             w,
+	}
+}
+
+// WriteReports checks ctx only before it starts writing; once the
+// document is under way it is written in full.
+func (w *streamReportWriter) WriteReports(ctx context.Context, store MetricsStore, run RunInfo) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	reps, prior := w.buildReports(store)
+	meta := w.metadata(run, prior)
+	for _, rep := range reps {
+		if rep.spec != nil {
+			meta.ReportConfigs = append(meta.ReportConfigs, *rep.spec)
+		}
+	}
+	if err := w.encoder(w.format).encodeStream(w.w, reps, meta); err != nil {
+		return err
+	}
+	for _, rep := range reps {
+		slog.Debug("wrote report", "report", rep.name, "campaigns", len(rep.rows))
+	}
+	return nil
+}
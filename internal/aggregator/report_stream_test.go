@@ -0,0 +1,27 @@
+package aggregator
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestStreamReportWriter_CombinedTable(t *testing.T) {
+	store := NewInMemoryMetricsStore()
+	store.Add("has_conv", 1000, 100, amount("500.00"), 50)
+	store.Add("no_conv", 1000, 200, amount("300.00"), 0)
+
+	var buf strings.Builder
+	w := NewStreamReportWriter(&buf, 5)
+	if err := w.WriteReports(context.Background(), store, RunInfo{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := "report,campaign_id,total_impressions,total_clicks,total_spend,total_conversions,CTR,CPA\n" +
+		"top5_ctr,no_conv,1000,200,300.00,0,0.2000,\n" +
+		"top5_ctr,has_conv,1000,100,500.00,50,0.1000,10.00\n" +
+		"top5_cpa,has_conv,1000,100,500.00,50,0.1000,10.00\n"
+	if buf.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
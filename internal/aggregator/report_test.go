@@ -1,21 +1,24 @@
 package aggregator
 
 import (
+	"context"
+	"errors"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
 )
 
 func TestFileReportWriter_WriteReports(t *testing.T) {
 	store := NewInMemoryMetricsStore()
-	store.Add("camp1", 1000, 100, 500.00, 10)
-	store.Add("camp2", 2000, 50, 200.00, 20)
+	store.Add("camp1", 1000, 100, amount("500.00"), 10)
+	store.Add("camp2", 2000, 50, amount("200.00"), 20)
 
 	dir := t.TempDir()
 	w := NewFileReportWriter(dir, 10)
 
-	if err := w.WriteReports(store); err != nil {
+	if err := w.WriteReports(context.Background(), store, RunInfo{}); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
@@ -35,14 +38,14 @@ func TestFileReportWriter_WriteReports(t *testing.T) {
 
 func TestWriteTopCTR_Ranking(t *testing.T) {
 	store := NewInMemoryMetricsStore()
-	store.Add("low", 1000, 10, 5.00, 1)
-	store.Add("high", 1000, 100, 50.00, 10)
-	store.Add("mid", 1000, 50, 25.00, 5)
+	store.Add("low", 1000, 10, amount("5.00"), 1)
+	store.Add("high", 1000, 100, amount("50.00"), 10)
+	store.Add("mid", 1000, 50, amount("25.00"), 5)
 
 	dir := t.TempDir()
 	w := NewFileReportWriter(dir, 10)
 
-	if err := w.WriteReports(store); err != nil {
+	if err := w.WriteReports(context.Background(), store, RunInfo{}); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
@@ -73,13 +76,13 @@ func TestWriteTopCTR_Ranking(t *testing.T) {
 
 func TestWriteTopCPA_ExcludesZeroConversions(t *testing.T) {
 	store := NewInMemoryMetricsStore()
-	store.Add("has_conv", 0, 0, 100.00, 10)
-	store.Add("no_conv", 0, 0, 200.00, 0)
+	store.Add("has_conv", 0, 0, amount("100.00"), 10)
+	store.Add("no_conv", 0, 0, amount("200.00"), 0)
 
 	dir := t.TempDir()
 	w := NewFileReportWriter(dir, 10)
 
-	if err := w.WriteReports(store); err != nil {
+	if err := w.WriteReports(context.Background(), store, RunInfo{}); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
@@ -99,14 +102,14 @@ func TestWriteTopCPA_ExcludesZeroConversions(t *testing.T) {
 
 func TestWriteTopCPA_Ranking(t *testing.T) {
 	store := NewInMemoryMetricsStore()
-	store.Add("expensive", 1000, 50, 1000.00, 10) // CPA = 100
-	store.Add("cheap", 1000, 50, 100.00, 10)      // CPA = 10
-	store.Add("mid", 1000, 50, 500.00, 10)        // CPA = 50
+	store.Add("expensive", 1000, 50, amount("1000.00"), 10) // CPA = 100
+	store.Add("cheap", 1000, 50, amount("100.00"), 10)      // CPA = 10
+	store.Add("mid", 1000, 50, amount("500.00"), 10)        // CPA = 50
 
 	dir := t.TempDir()
 	w := NewFileReportWriter(dir, 10)
 
-	if err := w.WriteReports(store); err != nil {
+	if err := w.WriteReports(context.Background(), store, RunInfo{}); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
@@ -137,13 +140,13 @@ func TestWriteTopCPA_Ranking(t *testing.T) {
 
 func TestWriteTopCTR_CPANullForZeroConversions(t *testing.T) {
 	store := NewInMemoryMetricsStore()
-	store.Add("has_conv", 1000, 100, 500.00, 50) // CPA = 10.00
-	store.Add("no_conv", 1000, 200, 300.00, 0)   // CPA should be empty
+	store.Add("has_conv", 1000, 100, amount("500.00"), 50) // CPA = 10.00
+	store.Add("no_conv", 1000, 200, amount("300.00"), 0)   // CPA should be empty
 
 	dir := t.TempDir()
 	w := NewFileReportWriter(dir, 10)
 
-	if err := w.WriteReports(store); err != nil {
+	if err := w.WriteReports(context.Background(), store, RunInfo{}); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
@@ -181,7 +184,7 @@ func TestConfigurableTopK(t *testing.T) {
 	// Test with topK = 2, should only return top 2 campaigns.
 	w := NewFileReportWriter(dir, 2)
 
-	if err := w.WriteReports(store); err != nil {
+	if err := w.WriteReports(context.Background(), store, RunInfo{}); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
@@ -206,12 +209,12 @@ func TestConfigurableTopK(t *testing.T) {
 
 func TestConfigurableTopK_FileNames(t *testing.T) {
 	store := NewInMemoryMetricsStore()
-	store.Add("camp1", 1000, 100, 500.00, 10)
+	store.Add("camp1", 1000, 100, amount("500.00"), 10)
 
 	dir := t.TempDir()
 	w := NewFileReportWriter(dir, 5)
 
-	if err := w.WriteReports(store); err != nil {
+	if err := w.WriteReports(context.Background(), store, RunInfo{}); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
@@ -231,3 +234,249 @@ func TestConfigurableTopK_FileNames(t *testing.T) {
 		}
 	}
 }
+
+func TestFileReportWriter_KeyColumns(t *testing.T) {
+	store := NewInMemoryMetricsStore()
+	store.Add(NewGroupKey("camp1", "US"), 1000, 100, amount("500.00"), 10)
+
+	dir := t.TempDir()
+	w := NewFileReportWriter(dir, 10, WithKeyColumns([]string{"campaign_id", "country"}))
+
+	if err := w.WriteReports(context.Background(), store, RunInfo{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "top10_ctr.csv"))
+	if err != nil {
+		t.Fatalf("read ctr file: %v", err)
+	}
+	want := "campaign_id,country,total_impressions,total_clicks,total_spend,total_conversions,CTR,CPA\n" +
+		"camp1,US,1000,100,500.00,10,0.1000,50.00\n"
+	if string(data) != want {
+		t.Errorf("got:\n%s\nwant:\n%s", data, want)
+	}
+}
+
+func TestFileReportWriter_ByteIdenticalAcrossRuns(t *testing.T) {
+	// Equal CTRs and CPAs everywhere, as in the 0.0275 ties of real data.
+	build := func() *InMemoryMetricsStore {
+		store := NewInMemoryMetricsStore()
+		for i := 0; i < 200; i++ {
+			store.Add(NewGroupKey("CMP"+strconv.Itoa(i)), 400, 11, amount("44.00"), 4)
+		}
+		return store
+	}
+
+	var want map[string][]byte
+	for run := 0; run < 10; run++ {
+		got := make(map[string][]byte)
+		// Each format gets its own directory: a run removes the reports
+		// of the previous one that it does not write itself.
+		for _, f := range []Format{FormatCSV, FormatJSON, FormatParquet} {
+			dir := t.TempDir()
+			w := NewFileReportWriter(dir, 10, WithFormat(f), fixedClock)
+			if err := w.WriteReports(context.Background(), build(), RunInfo{}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, e := range entries {
+				data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+				if err != nil {
+					t.Fatal(err)
+				}
+				got[string(f)+"/"+e.Name()] = data
+			}
+		}
+		if want == nil {
+			want = got
+			continue
+		}
+		for name, data := range want {
+			if string(got[name]) != string(data) {
+				t.Fatalf("run %d: %s differs from the first run", run, name)
+			}
+		}
+	}
+	if len(want) != 9 {
+		t.Errorf("expected 6 report files and 3 manifests, got %d files", len(want))
+	}
+}
+
+func TestFileReportWriter_Eligibility(t *testing.T) {
+	store := NewInMemoryMetricsStore()
+	store.Add("tiny", 3, 1, amount("1.00"), 1)        // CTR 0.33, CPA 1: tops both without thresholds
+	store.Add("big", 10000, 300, amount("900.00"), 30) // CTR 0.03, CPA 30
+
+	e := Eligibility{MinImpressions: 100, MinSpend: amount("10")}
+	for _, comments := range []bool{false, true} {
+		dir := t.TempDir()
+		opts := []ReportOption{WithEligibility(e)}
+		if comments {
+			opts = append(opts, WithEligibilityComments())
+		}
+		if err := NewFileReportWriter(dir, 10, opts...).WriteReports(context.Background(), store, RunInfo{}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		for _, name := range []string{"top10_ctr.csv", "top10_cpa.csv"} {
+			data, err := os.ReadFile(filepath.Join(dir, name))
+			if err != nil {
+				t.Fatalf("read %s: %v", name, err)
+			}
+			lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+			if comments {
+				want := "# eligibility: min_impressions=100 min_clicks=0 min_conversions=0 min_spend=10"
+				if lines[0] != want {
+					t.Errorf("%s: first line %q, want %q", name, lines[0], want)
+				}
+				lines = lines[1:]
+			}
+			if !strings.HasPrefix(lines[0], "campaign_id,") {
+				t.Errorf("%s: comments=%v: first line %q, want the header", name, comments, lines[0])
+			}
+			if len(lines) != 2 || !strings.HasPrefix(lines[1], "big,") {
+				t.Errorf("%s: expected only big to be ranked, got:\n%s", name, data)
+			}
+		}
+
+		m, err := VerifyManifest(dir)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, rep := range m.Reports {
+			if rep.Eligibility == nil || *rep.Eligibility != e {
+				t.Errorf("%s: got eligibility %v in the manifest, want %v", rep.File, rep.Eligibility, e)
+			}
+		}
+	}
+}
+
+func TestFileReportWriter_NoEligibilityComment(t *testing.T) {
+	store := NewInMemoryMetricsStore()
+	store.Add("camp1", 1000, 100, amount("500.00"), 10)
+
+	dir := t.TempDir()
+	if err := NewFileReportWriter(dir, 10).WriteReports(context.Background(), store, RunInfo{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "top10_ctr.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.HasPrefix(string(data), "#") {
+		t.Errorf("unexpected comment without thresholds:\n%s", data)
+	}
+}
+
+func TestFileReportWriter_RankSmoothed(t *testing.T) {
+	dir := t.TempDir()
+	w := NewFileReportWriter(dir, 3, WithRankBy(RankSmoothed))
+	if err := w.WriteReports(context.Background(), smoothingStore(), RunInfo{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, name := range []string{"top3_ctr.csv", "top3_cpa.csv"} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("read %s: %v", name, err)
+		}
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		wantHeader := "campaign_id,total_impressions,total_clicks,total_spend,total_conversions,CTR,CTR_smoothed,CPA,CPA_smoothed"
+		if lines[0] != wantHeader {
+			t.Errorf("%s header: got %q", name, lines[0])
+		}
+		if len(lines) != 4 {
+			t.Fatalf("%s: expected 3 rows, got:\n%s", name, data)
+		}
+		// The tiny campaign tops both raw rankings but not the smoothed ones.
+		for _, line := range lines[1:] {
+			if strings.HasPrefix(line, "tiny,") {
+				t.Errorf("%s: tiny should not rank in the top 3:\n%s", name, data)
+			}
+		}
+	}
+}
+
+func TestParseRankMode(t *testing.T) {
+	for _, want := range []RankMode{RankRaw, RankSmoothed, RankLower} {
+		if m, err := ParseRankMode(string(want)); err != nil || m != want {
+			t.Errorf("%s: got %v, %v", want, m, err)
+		}
+	}
+	if _, err := ParseRankMode("bayes"); err == nil {
+		t.Error("expected error for unknown mode")
+	}
+}
+
+func TestFileReportWriter_ConfidenceColumns(t *testing.T) {
+	store := NewInMemoryMetricsStore()
+	store.Add("CMP005", 400, 11, amount("44.00"), 0)
+	store.Add("CMP022", 800, 22, amount("88.00"), 4)
+
+	dir := t.TempDir()
+	w := NewFileReportWriter(dir, 10, WithConfidence(0.95))
+	if err := w.WriteReports(context.Background(), store, RunInfo{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "top10_ctr.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "campaign_id,total_impressions,total_clicks,total_spend,total_conversions,CTR,CTR_lower,CTR_upper,CPA,CVR_lower,CVR_upper\n" +
+		"CMP022,800,22,88.00,4,0.0275,0.0182,0.0413,22.00,0.0731,0.3852\n" +
+		"CMP005,400,11,44.00,0,0.0275,0.0154,0.0486,,0.0000,0.2588\n"
+	if string(data) != want {
+		t.Errorf("got:\n%s\nwant:\n%s", data, want)
+	}
+}
+
+func TestStreamReportWriter_RankLowerMetadata(t *testing.T) {
+	var buf strings.Builder
+	w := NewStreamReportWriter(&buf, 10, WithFormat(FormatJSONL), WithRankBy(RankLower), fixedClock)
+	if err := w.WriteReports(context.Background(), jsonTestStore(), RunInfo{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	first := strings.SplitN(buf.String(), "\n", 2)[0]
+	if !strings.Contains(first, `"rank_by":"lower"`) || !strings.Contains(first, `"confidence":0.95`) {
+		t.Errorf("unexpected metadata line: %s", first)
+	}
+	if !strings.Contains(buf.String(), `"CTR_lower":`) {
+		t.Errorf("expected interval columns in rank-by lower mode:\n%s", buf.String())
+	}
+}
+
+// cancelAfter is a context that reports cancellation from its n+1th
+// Err call on.
+type cancelAfter struct {
+	context.Context
+	n int
+}
+
+func (c *cancelAfter) Err() error {
+	if c.n--; c.n < 0 {
+		return context.Canceled
+	}
+	return nil
+}
+
+func TestFileReportWriter_CancelRemovesReports(t *testing.T) {
+	store := NewInMemoryMetricsStore()
+	store.Add("camp1", 1000, 50, amount("100"), 5)
+
+	dir := t.TempDir()
+	w := NewFileReportWriter(dir, 10)
+	ctx := &cancelAfter{Context: context.Background(), n: 1}
+	if err := w.WriteReports(ctx, store, RunInfo{}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want context.Canceled", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("got %d files after cancellation, want none", len(entries))
+	}
+}
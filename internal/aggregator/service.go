@@ -1,6 +1,8 @@
 package aggregator
 
 import (
+	"context"
+	"fmt"
 	"io"
 	"log/slog"
 	"time"
@@ -16,20 +18,90 @@ func NewService(p Processor, w ReportWriter) *Service {
 	return &Service{processor: p, writer: w}
 }
 
-func (s *Service) Run(r io.Reader) error {
+// Run processes r and writes the reports. The returned stats describe
+// the rows read, and are populated even when processing fails. Once ctx
+// is done Run stops and returns ctx.Err() without writing reports.
+func (s *Service) Run(ctx context.Context, r io.Reader) (ProcessStats, error) {
 	store := NewInMemoryMetricsStore()
 
 	t0 := time.Now()
-	if err := s.processor.Process(r, store); err != nil {
-		return err
+	stats, err := s.processor.Process(ctx, r, store)
+	if err != nil {
+		return stats, err
 	}
 	slog.Debug("processing phase complete", "elapsed", time.Since(t0))
 
+	var inputs []string
+	if name := sourceName(r); name != "" {
+		inputs = []string{name}
+	}
+	return stats, s.writeReports(ctx, store, RunInfo{Inputs: inputs, Stats: stats, Started: t0})
+}
+
+// RunInputs processes each input in order into a single store and then
+// writes the reports. Errors are prefixed with the failing input's name;
+// the returned stats cover all inputs read so far. Cancellation works
+// as for Run.
+func (s *Service) RunInputs(ctx context.Context, inputs []Input) (ProcessStats, error) {
+	store := NewInMemoryMetricsStore()
+
+	t0 := time.Now()
+	var total ProcessStats
+	run := RunInfo{Inputs: make([]string, len(inputs)), Files: make([]ManifestInput, len(inputs)), Started: t0}
+	for i, in := range inputs {
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+		stats, file, err := s.processInput(ctx, in, store)
+		total.add(stats)
+		if err != nil {
+			return total, fmt.Errorf("%s: %w", in.Name, err)
+		}
+		run.Inputs[i], run.Files[i] = in.Name, file
+	}
+	slog.Debug("processing phase complete", "inputs", len(inputs), "elapsed", time.Since(t0))
+
+	run.Stats = total
+	return total, s.writeReports(ctx, store, run)
+}
+
+// processInput processes one input into store. An input file is hashed
+// as it is read and described for the run manifest from the same open
+// handle; other inputs are described by name only.
+func (s *Service) processInput(ctx context.Context, in Input, store MetricsStore) (ProcessStats, ManifestInput, error) {
+	file := ManifestInput{Name: in.Name}
+	if in.Path == "" {
+		rc, err := in.Open()
+		if err != nil {
+			return ProcessStats{}, file, err
+		}
+		defer rc.Close()
+		stats, err := s.processor.Process(ctx, rc, store)
+		return stats, file, err
+	}
+
+	f, err := openHashedFile(in.Path)
+	if err != nil {
+		return ProcessStats{}, file, err
+	}
+	rc, err := decompressFile(f, in.Path)
+	if err != nil {
+		return ProcessStats{}, file, err
+	}
+	defer rc.Close()
+	stats, err := s.processor.Process(ctx, rc, store)
+	if err != nil {
+		return stats, file, err
+	}
+	file, err = f.describe(ctx, in.Name)
+	return stats, file, err
+}
+
+func (s *Service) writeReports(ctx context.Context, store MetricsStore, run RunInfo) error {
 	t1 := time.Now()
-	if err := s.writer.WriteReports(store); err != nil {
+	if err := s.writer.WriteReports(ctx, store, run); err != nil {
 		return err
 	}
 	slog.Debug("report writing phase complete", "elapsed", time.Since(t1))
-
 	return nil
 }
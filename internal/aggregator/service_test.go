@@ -1,6 +1,7 @@
 package aggregator
 
 import (
+	"context"
 	"errors"
 	"io"
 	"strings"
@@ -14,25 +15,27 @@ type fakeProcessor struct {
 	err error
 }
 
-func (f *fakeProcessor) Process(r io.Reader, store MetricsStore) error {
+func (f *fakeProcessor) Process(ctx context.Context, r io.Reader, store MetricsStore) (ProcessStats, error) {
 	if f.err != nil {
-		return f.err
+		return ProcessStats{}, f.err
 	}
 	if f.fn != nil {
 		f.fn(store)
 	}
-	return nil
+	return ProcessStats{RowsAccepted: 1}, nil
 }
 
 type fakeWriter struct {
 	called bool
 	store  MetricsStore
+	run    RunInfo
 	err    error
 }
 
-func (f *fakeWriter) WriteReports(store MetricsStore) error {
+func (f *fakeWriter) WriteReports(ctx context.Context, store MetricsStore, run RunInfo) error {
 	f.called = true
 	f.store = store
+	f.run = run
 	return f.err
 }
 
@@ -47,7 +50,7 @@ func TestService_Run(t *testing.T) {
 	writer := &fakeWriter{}
 	svc := NewService(proc, writer)
 
-	if err := svc.Run(strings.NewReader("")); err != nil {
+	if _, err := svc.Run(context.Background(), strings.NewReader("")); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
@@ -57,6 +60,9 @@ func TestService_Run(t *testing.T) {
 	if writer.store == nil {
 		t.Fatal("expected writer to receive store")
 	}
+	if writer.run.Stats.RowsAccepted != 1 {
+		t.Errorf("expected writer to receive run stats, got %+v", writer.run)
+	}
 }
 
 func TestService_ProcessError(t *testing.T) {
@@ -64,7 +70,7 @@ func TestService_ProcessError(t *testing.T) {
 	writer := &fakeWriter{}
 	svc := NewService(proc, writer)
 
-	err := svc.Run(strings.NewReader(""))
+	_, err := svc.Run(context.Background(), strings.NewReader(""))
 	if err == nil {
 		t.Fatal("expected error from processor")
 	}
@@ -82,8 +88,25 @@ func TestService_WriterError(t *testing.T) {
 	writer := &fakeWriter{err: errors.New("disk full")}
 	svc := NewService(proc, writer)
 
-	err := svc.Run(strings.NewReader(""))
+	_, err := svc.Run(context.Background(), strings.NewReader(""))
 	if err == nil {
 		t.Fatal("expected error from writer")
 	}
 }
+
+func TestService_Cancelled(t *testing.T) {
+	writer := &fakeWriter{}
+	svc := NewService(NewCSVProcessor(), writer)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := svc.RunInputs(ctx, []Input{{Name: "a.csv", Open: func() (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader("campaign_id,impressions,clicks,spend,conversions\n")), nil
+	}}})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want context.Canceled", err)
+	}
+	if writer.called {
+		t.Error("writer should not be called once the run is cancelled")
+	}
+}
@@ -0,0 +1,110 @@
+package aggregator
+
+// empiricalPrior holds priors fitted from every group in a store.
+//
+// CTR uses a Beta(CTRAlpha, CTRBeta) prior on the click probability; the
+// smoothed CTR is the posterior mean
+// (clicks + alpha) / (impressions + alpha + beta).
+//
+// CPA is shrunk through its inverse, conversions per unit of spend, with
+// a Gamma(CVRShape, CVRRate) prior on a Poisson rate; the smoothed CPA is
+// (spend + rate) / (conversions + shape), which behaves like adding
+// CVRShape conversions at the population's pooled CPA.
+//
+// Both priors are centred on the pooled rate and fitted by the method of
+// moments: the volume-weighted spread of the per-group rates, minus the
+// spread expected from sampling noise alone, estimates the true
+// between-group variance. Tiny groups therefore cannot inflate the
+// variance and weaken the prior. When no variance is left over the
+// prior is as strong as all the data combined; when there is no data
+// it has zero weight and smoothed values equal the raw ones.
+type empiricalPrior struct {
+	CTRAlpha float64 `json:"ctr_alpha"`
+	CTRBeta  float64 `json:"ctr_beta"`
+	CVRShape float64 `json:"cvr_shape"`
+	CVRRate  float64 `json:"cvr_rate"`
+}
+
+// fitPrior fits the CTR and CPA priors to the contents of store.
+func fitPrior(store MetricsStore) empiricalPrior {
+	// First pass: pooled rates.
+	var groups, spendGroups int
+	var impressions, clicks, spend, conversions float64
+	store.Each(func(m *CampaignMetrics) {
+		if m.TotalImpressions > 0 {
+			groups++
+			impressions += float64(m.TotalImpressions)
+			clicks += float64(m.TotalClicks)
+		}
+		if m.TotalSpend > 0 {
+			spendGroups++
+			spend += m.TotalSpend.Float64()
+			conversions += float64(m.TotalConversions)
+		}
+	})
+
+	var p empiricalPrior
+	if impressions == 0 && spend == 0 {
+		return p
+	}
+
+	// Second pass: volume-weighted squared deviations from the pooled
+	// rates.
+	var ctr, cvr float64
+	if impressions > 0 {
+		ctr = clicks / impressions
+	}
+	if spend > 0 {
+		cvr = conversions / spend
+	}
+	var ctrDev, cvrDev float64
+	store.Each(func(m *CampaignMetrics) {
+		if m.TotalImpressions > 0 {
+			d := m.CTR() - ctr
+			ctrDev += float64(m.TotalImpressions) * d * d
+		}
+		if m.TotalSpend > 0 {
+			d := float64(m.TotalConversions)/m.TotalSpend.Float64() - cvr
+			cvrDev += m.TotalSpend.Float64() * d * d
+		}
+	})
+
+	// Binomial noise contributes ctr*(1-ctr) per group to ctrDev.
+	if noise := ctr * (1 - ctr); noise > 0 {
+		strength := impressions
+		if between := (ctrDev - float64(groups)*noise) / impressions; between > 0 {
+			strength = min(noise/between-1, impressions)
+		}
+		p.CTRAlpha = ctr * max(strength, 0)
+		p.CTRBeta = (1 - ctr) * max(strength, 0)
+	}
+
+	// Poisson noise contributes cvr per group to cvrDev.
+	if cvr > 0 {
+		rate := spend
+		if between := (cvrDev - float64(spendGroups)*cvr) / spend; between > 0 {
+			rate = min(cvr/between, spend)
+		}
+		p.CVRRate = rate
+		p.CVRShape = cvr * rate
+	}
+	return p
+}
+
+// ctr returns the smoothed click-through rate of m.
+func (p empiricalPrior) ctr(m *CampaignMetrics) float64 {
+	d := float64(m.TotalImpressions) + p.CTRAlpha + p.CTRBeta
+	if d == 0 {
+		return 0
+	}
+	return (float64(m.TotalClicks) + p.CTRAlpha) / d
+}
+
+// cpa returns the smoothed cost per acquisition of m. Like CPA it is 0
+// for groups without conversions, which are never ranked by CPA.
+func (p empiricalPrior) cpa(m *CampaignMetrics) float64 {
+	if m.TotalConversions == 0 {
+		return 0
+	}
+	return (m.TotalSpend.Float64() + p.CVRRate) / (float64(m.TotalConversions) + p.CVRShape)
+}
@@ -0,0 +1,96 @@
+package aggregator
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+// smoothingStore holds 20 well-sampled campaigns around CTR 0.03 and CPA
+// 30, plus a tiny campaign with 1 click in 3 impressions and a single
+// cheap conversion.
+func smoothingStore() *InMemoryMetricsStore {
+	s := NewInMemoryMetricsStore()
+	for i := 0; i < 20; i++ {
+		clicks := int64(280 + 2*i)     // CTR 0.028 .. 0.0318
+		conversions := int64(20 + i%5) // CPA 25 .. 30
+		s.Add(GroupKey(fmt.Sprintf("big%02d", i)), 10000, clicks, amount("600"), conversions)
+	}
+	s.Add("tiny", 3, 1, amount("2"), 1)
+	return s
+}
+
+func TestFitPrior_ShrinksSmallCampaigns(t *testing.T) {
+	s := smoothingStore()
+	p := fitPrior(s)
+	if p.CTRAlpha <= 0 || p.CTRBeta <= 0 || p.CVRShape <= 0 || p.CVRRate <= 0 {
+		t.Fatalf("expected a fitted prior, got %+v", p)
+	}
+
+	tiny := s.m["tiny"]
+	var imps, clicks int64
+	s.Each(func(m *CampaignMetrics) { imps += m.TotalImpressions; clicks += m.TotalClicks })
+	pooledCTR := float64(clicks) / float64(imps)
+	if got := p.ctr(tiny); math.Abs(got-pooledCTR) > 0.01 {
+		t.Errorf("tiny smoothed CTR = %v, want close to pooled %v (raw %v)", got, pooledCTR, tiny.CTR())
+	}
+	if got := p.cpa(tiny); got < 20 {
+		t.Errorf("tiny smoothed CPA = %v, want pulled up towards the population (raw %v)", got, tiny.CPA())
+	}
+
+	// Well-sampled campaigns barely move and keep their order.
+	best := s.m["big19"]
+	if d := math.Abs(p.ctr(best) - best.CTR()); d > 0.002 {
+		t.Errorf("big19 smoothed CTR moved by %v", d)
+	}
+	if top := s.TopK(1, ctrRanking(p.ctr)); top[0].Key != "big19" {
+		t.Errorf("smoothed CTR leader: got %s, want big19", top[0].Key)
+	}
+	if top := s.TopKByCTR(1); top[0].Key != "tiny" {
+		t.Errorf("raw CTR leader: got %s, want tiny", top[0].Key)
+	}
+}
+
+func TestFitPrior_Degenerate(t *testing.T) {
+	// An empty store has a zero-weight prior.
+	if p := fitPrior(NewInMemoryMetricsStore()); p != (empiricalPrior{}) {
+		t.Errorf("empty store: got %+v", p)
+	}
+
+	// A single campaign's smoothed values stay its raw values.
+	s := NewInMemoryMetricsStore()
+	s.Add("only", 1000, 50, amount("100"), 4)
+	p := fitPrior(s)
+	m := s.m["only"]
+	if got := p.ctr(m); math.Abs(got-m.CTR()) > 1e-12 {
+		t.Errorf("ctr: got %v, want %v", got, m.CTR())
+	}
+	if got := p.cpa(m); math.Abs(got-m.CPA()) > 1e-12 {
+		t.Errorf("cpa: got %v, want %v", got, m.CPA())
+	}
+
+	// Without conversions there is no CPA prior, and smoothed CPA is 0
+	// like CPA.
+	s = NewInMemoryMetricsStore()
+	s.Add("a", 1000, 50, amount("100"), 0)
+	s.Add("b", 2000, 50, amount("100"), 0)
+	p = fitPrior(s)
+	if p.CVRShape != 0 || p.CVRRate != 0 || p.cpa(s.m["a"]) != 0 {
+		t.Errorf("expected no CPA prior, got %+v", p)
+	}
+}
+
+func TestFitPrior_NoBetweenVariance(t *testing.T) {
+	// Identical rates: all spread is noise, so everything shrinks to the
+	// pooled rate as strongly as the data allows.
+	s := NewInMemoryMetricsStore()
+	s.Add("a", 1000, 30, amount("300"), 10)
+	s.Add("b", 1000, 30, amount("300"), 10)
+	p := fitPrior(s)
+	if p.CTRAlpha+p.CTRBeta != 2000 || p.CVRRate != 600 {
+		t.Errorf("expected prior as strong as the data, got %+v", p)
+	}
+	if got := p.ctr(s.m["a"]); math.Abs(got-0.03) > 1e-12 {
+		t.Errorf("ctr: got %v, want 0.03", got)
+	}
+}
@@ -1,63 +1,190 @@
 package aggregator
 
-import "sort"
+import (
+	"container/heap"
+	"fmt"
+	"strings"
+)
 
 type InMemoryMetricsStore struct {
-	m map[string]*CampaignMetrics
+	m map[GroupKey]*CampaignMetrics
 }
 
 func NewInMemoryMetricsStore() *InMemoryMetricsStore {
-	return &InMemoryMetricsStore{m: make(map[string]*CampaignMetrics)}
+	return &InMemoryMetricsStore{m: make(map[GroupKey]*CampaignMetrics)}
 }
 
 func (s *InMemoryMetricsStore) Add(
-	campaignID string,
+	key GroupKey,
 	impressions, clicks int64,
-	spend float64,
+	spend Money,
 	conversions int64,
-) {
-	cm, ok := s.m[campaignID]
+) error {
+	cm := s.group(key)
+	total, ok := addMoney(cm.TotalSpend, spend)
 	if !ok {
-		cm = &CampaignMetrics{CampaignID: campaignID}
-		s.m[campaignID] = cm
+		return fmt.Errorf("total spend of %s: %w", key, ErrMoneyOverflow)
 	}
 	cm.TotalImpressions += impressions
 	cm.TotalClicks += clicks
-	cm.TotalSpend += spend
+	cm.TotalSpend = total
 	cm.TotalConversions += conversions
+	return nil
 }
 
-func (s *InMemoryMetricsStore) TopKByCTR(k int) []*CampaignMetrics {
-	all := s.all()
-	sort.Slice(all, func(i, j int) bool {
-		return all[i].CTR() > all[j].CTR()
-	})
-	if k > len(all) {
-		k = len(all)
+func (s *InMemoryMetricsStore) AddRevenue(key GroupKey, revenue Money) error {
+	cm := s.group(key)
+	total, ok := addMoney(cm.TotalRevenue, revenue)
+	if !ok {
+		return fmt.Errorf("total revenue of %s: %w", key, ErrMoneyOverflow)
+	}
+	cm.TotalRevenue = total
+	cm.HasRevenue = true
+	return nil
+}
+
+// group returns the metrics for key, creating them on first use.
+func (s *InMemoryMetricsStore) group(key GroupKey) *CampaignMetrics {
+	cm, ok := s.m[key]
+	if !ok {
+		// Keys are often substrings of a whole CSV record; clone so the
+		// map does not pin the record in memory.
+		key = GroupKey(strings.Clone(string(key)))
+		cm = &CampaignMetrics{Key: key}
+		s.m[key] = cm
 	}
-	return all[:k]
+	return cm
+}
+
+// Len returns the number of groups.
+func (s *InMemoryMetricsStore) Len() int {
+	return len(s.m)
+}
+
+func (s *InMemoryMetricsStore) TopKByCTR(k int, filters ...Filter) []*CampaignMetrics {
+	return s.TopK(k, rankByCTR, filters...)
+}
+
+func (s *InMemoryMetricsStore) TopKByCPA(k int, filters ...Filter) []*CampaignMetrics {
+	return s.TopK(k, rankByCPA, append([]Filter{hasConversions}, filters...)...)
 }
 
-func (s *InMemoryMetricsStore) TopKByCPA(k int) []*CampaignMetrics {
-	eligible := make([]*CampaignMetrics, 0, len(s.m))
+func (s *InMemoryMetricsStore) Each(fn func(*CampaignMetrics)) {
 	for _, cm := range s.m {
-		if cm.TotalConversions > 0 {
-			eligible = append(eligible, cm)
-		}
+		fn(cm)
 	}
-	sort.Slice(eligible, func(i, j int) bool {
-		return eligible[i].CPA() < eligible[j].CPA()
-	})
-	if k > len(eligible) {
-		k = len(eligible)
+}
+
+func hasConversions(cm *CampaignMetrics) bool {
+	return cm.TotalConversions > 0
+}
+
+// Ranking reports whether a ranks above b.
+type Ranking func(a, b *CampaignMetrics) bool
+
+// valueRanking ranks by value (descending when desc is set), then by
+// volume descending, so the better-evidenced value wins a tie, then by
+// the smaller key. The chain is total, so the ranking never depends on
+// map iteration order.
+func valueRanking(value func(*CampaignMetrics) float64, desc bool, volume func(*CampaignMetrics) float64) Ranking {
+	return func(a, b *CampaignMetrics) bool {
+		if va, vb := value(a), value(b); va != vb {
+			if desc {
+				return va > vb
+			}
+			return va < vb
+		}
+		if va, vb := volume(a), volume(b); va != vb {
+			return va > vb
+		}
+		return a.Key < b.Key
 	}
-	return eligible[:k]
 }
 
-func (s *InMemoryMetricsStore) all() []*CampaignMetrics {
-	result := make([]*CampaignMetrics, 0, len(s.m))
-	for _, v := range s.m {
-		result = append(result, v)
+// ctrRanking ranks by ctr descending, then more impressions, then the
+// smaller key.
+func ctrRanking(ctr func(*CampaignMetrics) float64) Ranking {
+	return valueRanking(ctr, true, impressionsVolume)
+}
+
+// cpaRanking ranks by cpa ascending, then more conversions, then the
+// smaller key.
+func cpaRanking(cpa func(*CampaignMetrics) float64) Ranking {
+	return valueRanking(cpa, false, conversionsVolume)
+}
+
+func impressionsVolume(m *CampaignMetrics) float64 { return float64(m.TotalImpressions) }
+func clicksVolume(m *CampaignMetrics) float64      { return float64(m.TotalClicks) }
+func conversionsVolume(m *CampaignMetrics) float64 { return float64(m.TotalConversions) }
+func spendVolume(m *CampaignMetrics) float64       { return m.TotalSpend.Float64() }
+
+var (
+	rankByCTR = ctrRanking((*CampaignMetrics).CTR)
+	rankByCPA = cpaRanking((*CampaignMetrics).CPA)
+)
+
+// TopK keeps the k best campaigns seen so far in a heap whose root is
+// the worst of them instead of sorting every campaign, so selection is
+// O(n log k) time and O(k) space.
+func (s *InMemoryMetricsStore) TopK(k int, better Ranking, filters ...Filter) []*CampaignMetrics {
+	if k <= 0 {
+		return []*CampaignMetrics{}
+	}
+	h := &rankHeap{better: better, items: make([]*CampaignMetrics, 0, min(k, len(s.m)))}
+next:
+	for _, cm := range s.m {
+		for _, f := range filters {
+			if !f(cm) {
+				continue next
+			}
+		}
+		if len(h.items) < k {
+			heap.Push(h, cm)
+		} else if better(cm, h.items[0]) {
+			h.items[0] = cm
+			heap.Fix(h, 0)
+		}
+	}
+
+	// Popping yields the worst first, so fill the result from the back.
+	result := make([]*CampaignMetrics, len(h.items))
+	for i := len(result) - 1; i >= 0; i-- {
+		result[i] = heap.Pop(h).(*CampaignMetrics)
 	}
 	return result
 }
+
+// rankHeap is a heap.Interface whose root is the lowest-ranked item
+// under better.
+type rankHeap struct {
+	items  []*CampaignMetrics
+	better Ranking
+}
+
+func (h *rankHeap) Len() int           { return len(h.items) }
+func (h *rankHeap) Less(i, j int) bool { return h.better(h.items[j], h.items[i]) }
+func (h *rankHeap) Swap(i, j int)      { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *rankHeap) Push(x any)         { h.items = append(h.items, x.(*CampaignMetrics)) }
+
+func (h *rankHeap) Pop() any {
+	last := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return last
+}
+
+// mergeInto adds every campaign total held by s into dst. It is used to
+// fold worker-local stores into the caller's store after parallel
+// parsing. It stops at the first overflow.
+func (s *InMemoryMetricsStore) mergeInto(dst MetricsStore) error {
+	for _, cm := range s.m {
+		if err := dst.Add(cm.Key, cm.TotalImpressions, cm.TotalClicks, cm.TotalSpend, cm.TotalConversions); err != nil {
+			return err
+		}
+		if cm.HasRevenue {
+			if err := dst.AddRevenue(cm.Key, cm.TotalRevenue); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
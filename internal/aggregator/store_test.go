@@ -1,6 +1,10 @@
 package aggregator
 
 import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
 	"strings"
 	"testing"
 )
@@ -11,18 +15,18 @@ func TestInMemoryMetricsStore_AddAndCount(t *testing.T) {
 		t.Fatalf("expected 0, got %d", n)
 	}
 
-	s.Add("camp1", 100, 10, 50.0, 5)
+	s.Add("camp1", 100, 10, amount("50.0"), 5)
 	if n := len(s.TopKByCTR(100)); n != 1 {
 		t.Fatalf("expected 1, got %d", n)
 	}
 
 	// Adding to the same campaign does not increase count.
-	s.Add("camp1", 200, 20, 100.0, 10)
+	s.Add("camp1", 200, 20, amount("100.0"), 10)
 	if n := len(s.TopKByCTR(100)); n != 1 {
 		t.Fatalf("expected 1, got %d", n)
 	}
 
-	s.Add("camp2", 300, 30, 150.0, 15)
+	s.Add("camp2", 300, 30, amount("150.0"), 15)
 	if n := len(s.TopKByCTR(100)); n != 2 {
 		t.Fatalf("expected 2, got %d", n)
 	}
@@ -30,8 +34,8 @@ func TestInMemoryMetricsStore_AddAndCount(t *testing.T) {
 
 func TestInMemoryMetricsStore_Accumulation(t *testing.T) {
 	s := NewInMemoryMetricsStore()
-	s.Add("camp1", 1000, 50, 100.00, 10)
-	s.Add("camp1", 500, 25, 50.00, 5)
+	s.Add("camp1", 1000, 50, amount("100.00"), 10)
+	s.Add("camp1", 500, 25, amount("50.00"), 5)
 
 	all := s.TopKByCTR(100)
 	if len(all) != 1 {
@@ -39,8 +43,8 @@ func TestInMemoryMetricsStore_Accumulation(t *testing.T) {
 	}
 
 	m := all[0]
-	if m.CampaignID != "camp1" {
-		t.Errorf("expected camp1, got %s", m.CampaignID)
+	if m.Key != "camp1" {
+		t.Errorf("expected camp1, got %s", m.Key)
 	}
 	if m.TotalImpressions != 1500 {
 		t.Errorf("impressions: got %d, want 1500", m.TotalImpressions)
@@ -48,8 +52,8 @@ func TestInMemoryMetricsStore_Accumulation(t *testing.T) {
 	if m.TotalClicks != 75 {
 		t.Errorf("clicks: got %d, want 75", m.TotalClicks)
 	}
-	if m.TotalSpend != 150.0 {
-		t.Errorf("spend: got %f, want 150.0", m.TotalSpend)
+	if m.TotalSpend != amount("150.0") {
+		t.Errorf("spend: got %s, want 150", m.TotalSpend)
 	}
 	if m.TotalConversions != 15 {
 		t.Errorf("conversions: got %d, want 15", m.TotalConversions)
@@ -66,18 +70,18 @@ func TestInMemoryMetricsStore_TopKByCTR_Ranking(t *testing.T) {
 	if len(top) != 3 {
 		t.Fatalf("expected 3, got %d", len(top))
 	}
-	if top[0].CampaignID != "high" {
-		t.Errorf("expected first to be 'high', got %s", top[0].CampaignID)
+	if top[0].Key != "high" {
+		t.Errorf("expected first to be 'high', got %s", top[0].Key)
 	}
-	if top[2].CampaignID != "low" {
-		t.Errorf("expected last to be 'low', got %s", top[2].CampaignID)
+	if top[2].Key != "low" {
+		t.Errorf("expected last to be 'low', got %s", top[2].Key)
 	}
 }
 
 func TestInMemoryMetricsStore_TopKByCTR_Limit(t *testing.T) {
 	s := NewInMemoryMetricsStore()
 	for i := 0; i < 5; i++ {
-		s.Add(string(rune('A'+i)), 1000, int64((i+1)*10), 0, 0)
+		s.Add(GroupKey(string(rune('A'+i))), 1000, int64((i+1)*10), 0, 0)
 	}
 
 	top := s.TopKByCTR(2)
@@ -88,40 +92,40 @@ func TestInMemoryMetricsStore_TopKByCTR_Limit(t *testing.T) {
 
 func TestInMemoryMetricsStore_TopKByCPA_Ranking(t *testing.T) {
 	s := NewInMemoryMetricsStore()
-	s.Add("expensive", 0, 0, 1000.00, 10) // CPA = 100
-	s.Add("cheap", 0, 0, 100.00, 10)      // CPA = 10
-	s.Add("mid", 0, 0, 500.00, 10)        // CPA = 50
+	s.Add("expensive", 0, 0, amount("1000.00"), 10) // CPA = 100
+	s.Add("cheap", 0, 0, amount("100.00"), 10)      // CPA = 10
+	s.Add("mid", 0, 0, amount("500.00"), 10)        // CPA = 50
 
 	top := s.TopKByCPA(10)
 	if len(top) != 3 {
 		t.Fatalf("expected 3, got %d", len(top))
 	}
-	if top[0].CampaignID != "cheap" {
-		t.Errorf("expected first to be 'cheap', got %s", top[0].CampaignID)
+	if top[0].Key != "cheap" {
+		t.Errorf("expected first to be 'cheap', got %s", top[0].Key)
 	}
-	if top[2].CampaignID != "expensive" {
-		t.Errorf("expected last to be 'expensive', got %s", top[2].CampaignID)
+	if top[2].Key != "expensive" {
+		t.Errorf("expected last to be 'expensive', got %s", top[2].Key)
 	}
 }
 
 func TestInMemoryMetricsStore_TopKByCPA_ExcludesZeroConversions(t *testing.T) {
 	s := NewInMemoryMetricsStore()
-	s.Add("has_conv", 0, 0, 100.00, 10)
-	s.Add("no_conv", 0, 0, 200.00, 0)
+	s.Add("has_conv", 0, 0, amount("100.00"), 10)
+	s.Add("no_conv", 0, 0, amount("200.00"), 0)
 
 	top := s.TopKByCPA(10)
 	if len(top) != 1 {
 		t.Fatalf("expected 1, got %d", len(top))
 	}
-	if top[0].CampaignID != "has_conv" {
-		t.Errorf("expected 'has_conv', got %s", top[0].CampaignID)
+	if top[0].Key != "has_conv" {
+		t.Errorf("expected 'has_conv', got %s", top[0].Key)
 	}
 }
 
 func TestInMemoryMetricsStore_TopKByCPA_Limit(t *testing.T) {
 	s := NewInMemoryMetricsStore()
 	for i := 0; i < 5; i++ {
-		s.Add(string(rune('A'+i)), 0, 0, float64((i+1)*100), 10)
+		s.Add(GroupKey(string(rune('A'+i))), 0, 0, Money((i+1)*100)*microsPerUnit, 10)
 	}
 
 	top := s.TopKByCPA(2)
@@ -146,7 +150,7 @@ func TestInMemoryMetricsStore_TopK_Empty(t *testing.T) {
 
 func TestInMemoryMetricsStore_DerivedMetrics(t *testing.T) {
 	s := NewInMemoryMetricsStore()
-	s.Add("camp1", 1000, 100, 500.00, 50)
+	s.Add("camp1", 1000, 100, amount("500.00"), 50)
 
 	all := s.TopKByCTR(1)
 	m := all[0]
@@ -164,8 +168,13 @@ func TestInMemoryMetricsStore_DerivedMetrics(t *testing.T) {
 
 // findByCampaignID is a test helper that locates a campaign in a slice.
 func findByCampaignID(metrics []*CampaignMetrics, id string) *CampaignMetrics {
+	return findByKey(metrics, GroupKey(id))
+}
+
+// findByKey is a test helper that locates a group in a slice.
+func findByKey(metrics []*CampaignMetrics, key GroupKey) *CampaignMetrics {
 	for _, m := range metrics {
-		if m.CampaignID == id {
+		if m.Key == key {
 			return m
 		}
 	}
@@ -180,10 +189,215 @@ func TestInMemoryMetricsStore_SatisfiesInterface(t *testing.T) {
 	s := NewInMemoryMetricsStore()
 	p := NewCSVProcessor()
 	input := "campaign_id,impressions,clicks,spend,conversions\ncamp1,100,10,50.00,5\n"
-	if err := p.Process(strings.NewReader(input), s); err != nil {
+	if _, err := p.Process(context.Background(), strings.NewReader(input), s); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 	if n := len(s.TopKByCTR(100)); n != 1 {
 		t.Errorf("expected 1 campaign, got %d", n)
 	}
 }
+
+// all returns every campaign held by s, in no particular order.
+func (s *InMemoryMetricsStore) all() []*CampaignMetrics {
+	result := make([]*CampaignMetrics, 0, len(s.m))
+	for _, v := range s.m {
+		result = append(result, v)
+	}
+	return result
+}
+
+// sortTopK is the full-sort selection the heap replaced, kept as the
+// reference for tests and the baseline for benchmarks.
+func sortTopK(all []*CampaignMetrics, k int, better func(a, b *CampaignMetrics) bool) []*CampaignMetrics {
+	sort.Slice(all, func(i, j int) bool { return better(all[i], all[j]) })
+	if k > len(all) {
+		k = len(all)
+	}
+	return all[:k]
+}
+
+// randomStore fills a store with n campaigns with random totals; about
+// one in four has no conversions.
+func randomStore(n int, seed int64) *InMemoryMetricsStore {
+	rng := rand.New(rand.NewSource(seed))
+	s := NewInMemoryMetricsStore()
+	for i := 0; i < n; i++ {
+		impressions := 1 + rng.Int63n(100000)
+		clicks := rng.Int63n(impressions + 1)
+		conversions := rng.Int63n(4) * rng.Int63n(clicks+1)
+		spend := Money(rng.Int63n(1000000)) * 10000 // whole cents
+		s.Add(GroupKey(fmt.Sprintf("CMP%08d", i)), impressions, clicks, spend, conversions)
+	}
+	return s
+}
+
+func TestInMemoryMetricsStore_TopKMatchesFullSort(t *testing.T) {
+	s := randomStore(5000, 1)
+	for _, k := range []int{0, 1, 10, 100, 4999, 5000, 6000} {
+		ctr := s.TopKByCTR(k)
+		wantCTR := sortTopK(s.all(), k, rankByCTR)
+		if len(ctr) != len(wantCTR) {
+			t.Fatalf("k=%d CTR: got %d rows, want %d", k, len(ctr), len(wantCTR))
+		}
+		for i := range ctr {
+			if ctr[i] != wantCTR[i] {
+				t.Fatalf("k=%d CTR row %d: got %v, want %v", k, i, ctr[i], wantCTR[i])
+			}
+		}
+
+		var eligible []*CampaignMetrics
+		for _, m := range s.all() {
+			if m.TotalConversions > 0 {
+				eligible = append(eligible, m)
+			}
+		}
+		cpa := s.TopKByCPA(k)
+		wantCPA := sortTopK(eligible, k, rankByCPA)
+		if len(cpa) != len(wantCPA) {
+			t.Fatalf("k=%d CPA: got %d rows, want %d", k, len(cpa), len(wantCPA))
+		}
+		for i := range cpa {
+			if cpa[i] != wantCPA[i] {
+				t.Fatalf("k=%d CPA row %d: got %v, want %v", k, i, cpa[i], wantCPA[i])
+			}
+		}
+	}
+}
+
+// benchStores caches the generated stores across benchmark runs; the
+// 10M store alone takes several seconds and over a gigabyte to build.
+var benchStores = map[int]*InMemoryMetricsStore{}
+
+func benchmarkTopK(b *testing.B, heapSelect, sortSelect func(*InMemoryMetricsStore) []*CampaignMetrics) {
+	for _, n := range []int{10_000, 1_000_000, 10_000_000} {
+		if n > 1_000_000 && testing.Short() {
+			continue
+		}
+		s, ok := benchStores[n]
+		if !ok {
+			s = randomStore(n, 1)
+			benchStores[n] = s
+		}
+		b.Run(fmt.Sprintf("heap/n=%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				heapSelect(s)
+			}
+		})
+		b.Run(fmt.Sprintf("sort/n=%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				sortSelect(s)
+			}
+		})
+	}
+}
+
+func BenchmarkTopKByCTR(b *testing.B) {
+	benchmarkTopK(b,
+		func(s *InMemoryMetricsStore) []*CampaignMetrics { return s.TopKByCTR(10) },
+		func(s *InMemoryMetricsStore) []*CampaignMetrics { return sortTopK(s.all(), 10, rankByCTR) },
+	)
+}
+
+func BenchmarkTopKByCPA(b *testing.B) {
+	benchmarkTopK(b,
+		func(s *InMemoryMetricsStore) []*CampaignMetrics { return s.TopKByCPA(10) },
+		func(s *InMemoryMetricsStore) []*CampaignMetrics {
+			eligible := make([]*CampaignMetrics, 0, len(s.m))
+			for _, cm := range s.m {
+				if cm.TotalConversions > 0 {
+					eligible = append(eligible, cm)
+				}
+			}
+			return sortTopK(eligible, 10, rankByCPA)
+		},
+	)
+}
+
+func TestInMemoryMetricsStore_TopKByCTR_TieBreak(t *testing.T) {
+	s := NewInMemoryMetricsStore()
+	// All four have CTR 0.0275.
+	s.Add("CMP022", 400, 11, 0, 0)
+	s.Add("CMP005", 400, 11, 0, 0)
+	s.Add("CMP009", 800, 22, 0, 0)
+	s.Add("CMP001", 200, 6, 0, 0)   // CTR 0.03
+	s.Add("CMP002", 1200, 33, 0, 0) // CTR 0.0275
+
+	want := []GroupKey{"CMP001", "CMP002", "CMP009", "CMP005", "CMP022"}
+	for _, k := range []int{5, 3} {
+		top := s.TopKByCTR(k)
+		for i, m := range top {
+			if m.Key != want[i] {
+				t.Errorf("k=%d: position %d: got %s, want %s", k, i, m.Key, want[i])
+			}
+		}
+	}
+}
+
+func TestInMemoryMetricsStore_TopKByCPA_TieBreak(t *testing.T) {
+	s := NewInMemoryMetricsStore()
+	// All CPA 10.
+	s.Add("b", 0, 0, amount("100"), 10)
+	s.Add("a", 0, 0, amount("100"), 10)
+	s.Add("c", 0, 0, amount("200"), 20)
+
+	want := []GroupKey{"c", "a", "b"}
+	top := s.TopKByCPA(3)
+	for i, m := range top {
+		if m.Key != want[i] {
+			t.Errorf("position %d: got %s, want %s", i, m.Key, want[i])
+		}
+	}
+}
+
+func TestInMemoryMetricsStore_TopK_Deterministic(t *testing.T) {
+	// Many exact ties; the selected set and order must not depend on map
+	// iteration order, which differs between stores and calls.
+	build := func() *InMemoryMetricsStore {
+		s := NewInMemoryMetricsStore()
+		for i := 0; i < 500; i++ {
+			s.Add(GroupKey(fmt.Sprintf("CMP%03d", i)), int64(100*(1+i%3)), int64(1+i%3), amount("10"), int64(i%2))
+		}
+		return s
+	}
+	keys := func(ms []*CampaignMetrics) string {
+		var b strings.Builder
+		for _, m := range ms {
+			b.WriteString(string(m.Key) + ",")
+		}
+		return b.String()
+	}
+	first := build()
+	wantCTR, wantCPA := keys(first.TopKByCTR(10)), keys(first.TopKByCPA(10))
+	for run := 0; run < 20; run++ {
+		s := build()
+		if got := keys(s.TopKByCTR(10)); got != wantCTR {
+			t.Fatalf("run %d: CTR order changed:\n got %s\nwant %s", run, got, wantCTR)
+		}
+		if got := keys(s.TopKByCPA(10)); got != wantCPA {
+			t.Fatalf("run %d: CPA order changed:\n got %s\nwant %s", run, got, wantCPA)
+		}
+	}
+	if !strings.HasPrefix(wantCTR, "CMP002,CMP005,") {
+		t.Errorf("expected the most-impression ties in key order, got %s", wantCTR)
+	}
+}
+
+func TestInMemoryMetricsStore_TopK_Filters(t *testing.T) {
+	s := NewInMemoryMetricsStore()
+	s.Add("tiny", 3, 1, amount("1.00"), 1)
+	s.Add("big", 10000, 300, amount("900.00"), 30)
+	s.Add("no_conv", 5000, 100, amount("100.00"), 0)
+
+	minImpressions := Eligibility{MinImpressions: 100}.Allows
+	if top := s.TopKByCTR(10, minImpressions); len(top) != 2 || top[0].Key != "big" || top[1].Key != "no_conv" {
+		t.Errorf("CTR: got %v", top)
+	}
+	// The zero-conversion rule still applies alongside the filters.
+	if top := s.TopKByCPA(10, minImpressions); len(top) != 1 || top[0].Key != "big" {
+		t.Errorf("CPA: got %v", top)
+	}
+	rejectAll := func(*CampaignMetrics) bool { return false }
+	if top := s.TopKByCTR(10, minImpressions, rejectAll); len(top) != 0 {
+		t.Errorf("expected every filter to apply, got %v", top)
+	}
+}
@@ -0,0 +1,206 @@
+package aggregator
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Severity is what happens to a row that breaks a validation rule.
+type Severity int
+
+const (
+	// SeverityReject rejects the row like one that fails to parse, under
+	// the error policy.
+	SeverityReject Severity = iota
+	// SeverityWarn accepts the row as is.
+	SeverityWarn
+	// SeverityClamp repairs the offending value and accepts the row.
+	SeverityClamp
+	// SeverityOff skips the rule.
+	SeverityOff
+)
+
+var severityNames = []string{"reject", "warn", "clamp", "off"}
+
+func (s Severity) String() string {
+	if s < 0 || int(s) >= len(severityNames) {
+		return fmt.Sprintf("Severity(%d)", int(s))
+	}
+	return severityNames[s]
+}
+
+// ParseSeverity maps reject, warn, clamp and off to a Severity.
+func ParseSeverity(s string) (Severity, error) {
+	i := slices.Index(severityNames, strings.ToLower(s))
+	if i < 0 {
+		return 0, fmt.Errorf("invalid severity %q; want %s", s, strings.Join(severityNames, ", "))
+	}
+	return Severity(i), nil
+}
+
+// rowValues are the parsed metric fields of one row.
+type rowValues struct {
+	impressions, clicks, conversions int64
+	spend, revenue                   Money
+	hasRevenue                       bool
+}
+
+// validationRule is a semantic check on a parsed row. broken reports a
+// violation, describe explains it and clamp repairs it.
+type validationRule struct {
+	name     string
+	fallback Severity // when not configured
+	broken   func(v *rowValues) bool
+	describe func(v *rowValues) string
+	clamp    func(v *rowValues)
+}
+
+// validationRules run in this order, so that negative counts are
+// clamped before the counts are compared.
+var validationRules = []validationRule{
+	{
+		name: "negative_impressions", fallback: SeverityReject,
+		broken:   func(v *rowValues) bool { return v.impressions < 0 },
+		describe: func(v *rowValues) string { return fmt.Sprintf("impressions %d < 0", v.impressions) },
+		clamp:    func(v *rowValues) { v.impressions = 0 },
+	},
+	{
+		name: "negative_clicks", fallback: SeverityReject,
+		broken:   func(v *rowValues) bool { return v.clicks < 0 },
+		describe: func(v *rowValues) string { return fmt.Sprintf("clicks %d < 0", v.clicks) },
+		clamp:    func(v *rowValues) { v.clicks = 0 },
+	},
+	{
+		name: "negative_conversions", fallback: SeverityReject,
+		broken:   func(v *rowValues) bool { return v.conversions < 0 },
+		describe: func(v *rowValues) string { return fmt.Sprintf("conversions %d < 0", v.conversions) },
+		clamp:    func(v *rowValues) { v.conversions = 0 },
+	},
+	{
+		// Refunds and credits are legitimately negative, so this only
+		// warns by default.
+		name: "negative_spend", fallback: SeverityWarn,
+		broken:   func(v *rowValues) bool { return v.spend < 0 },
+		describe: func(v *rowValues) string { return fmt.Sprintf("spend %s < 0", v.spend) },
+		clamp:    func(v *rowValues) { v.spend = 0 },
+	},
+	{
+		name: "negative_revenue", fallback: SeverityWarn,
+		broken:   func(v *rowValues) bool { return v.hasRevenue && v.revenue < 0 },
+		describe: func(v *rowValues) string { return fmt.Sprintf("revenue %s < 0", v.revenue) },
+		clamp:    func(v *rowValues) { v.revenue = 0 },
+	},
+	{
+		name: "clicks_exceed_impressions", fallback: SeverityReject,
+		broken: func(v *rowValues) bool { return v.clicks > v.impressions },
+		describe: func(v *rowValues) string {
+			return fmt.Sprintf("clicks %d > impressions %d", v.clicks, v.impressions)
+		},
+		clamp: func(v *rowValues) { v.clicks = v.impressions },
+	},
+	{
+		// View-through conversions need no click, so this only warns by
+		// default.
+		name: "conversions_exceed_clicks", fallback: SeverityWarn,
+		broken: func(v *rowValues) bool { return v.conversions > v.clicks },
+		describe: func(v *rowValues) string {
+			return fmt.Sprintf("conversions %d > clicks %d", v.conversions, v.clicks)
+		},
+		clamp: func(v *rowValues) { v.conversions = v.clicks },
+	},
+}
+
+// ValidationRules returns the names of the built-in rules, in the order
+// they run.
+func ValidationRules() []string {
+	names := make([]string, len(validationRules))
+	for i, r := range validationRules {
+		names[i] = r.name
+	}
+	return names
+}
+
+// Validation overrides the severity of validation rules by name. Rules
+// it does not name keep their default: reject for negative counts and
+// clicks_exceed_impressions, warn for negative_spend, negative_revenue
+// and conversions_exceed_clicks.
+type Validation map[string]Severity
+
+// Severity returns the severity of rule under v.
+func (v Validation) Severity(rule string) Severity {
+	if s, ok := v[rule]; ok {
+		return s
+	}
+	i := slices.IndexFunc(validationRules, func(r validationRule) bool { return r.name == rule })
+	if i < 0 {
+		return SeverityOff
+	}
+	return validationRules[i].fallback
+}
+
+// ParseValidation parses a comma-separated --validate value of
+// rule=severity pairs, such as "negative_spend=reject,
+// conversions_exceed_clicks=clamp". The rule "all" sets every rule;
+// later pairs override earlier ones.
+func ParseValidation(s string) (Validation, error) {
+	v := Validation{}
+	for _, pair := range strings.Split(s, ",") {
+		rule, sev, ok := strings.Cut(pair, "=")
+		rule, sev = strings.ToLower(strings.TrimSpace(rule)), strings.TrimSpace(sev)
+		if !ok || rule == "" {
+			return nil, fmt.Errorf("invalid validation %q: want rule=severity pairs, got %q", s, pair)
+		}
+		severity, err := ParseSeverity(sev)
+		if err != nil {
+			return nil, fmt.Errorf("invalid validation %q: %w", s, err)
+		}
+		switch {
+		case rule == "all":
+			for _, name := range ValidationRules() {
+				v[name] = severity
+			}
+		case slices.Contains(ValidationRules(), rule):
+			v[rule] = severity
+		default:
+			return nil, fmt.Errorf("invalid validation %q: unknown rule %q; want all or one of %s", s, rule, strings.Join(ValidationRules(), ", "))
+		}
+	}
+	return v, nil
+}
+
+// activeRule is a rule at its configured severity.
+type activeRule struct {
+	validationRule
+	severity Severity
+}
+
+// activeRules resolves v to the rules that are not off, in order.
+func (v Validation) activeRules() []activeRule {
+	var rules []activeRule
+	for _, r := range validationRules {
+		if s := v.Severity(r.name); s != SeverityOff {
+			rules = append(rules, activeRule{r, s})
+		}
+	}
+	return rules
+}
+
+// validate applies rules to values, counting every violation in
+// stats. A violated reject rule stops the checks and is returned as an
+// error; clamp rules repair values in place.
+func validate(rules []activeRule, values *rowValues, stats *ProcessStats) error {
+	for _, r := range rules {
+		if !r.broken(values) {
+			continue
+		}
+		stats.countViolation(r.name)
+		switch r.severity {
+		case SeverityReject:
+			return fmt.Errorf("rule %s: %s", r.name, r.describe(values))
+		case SeverityClamp:
+			r.clamp(values)
+		}
+	}
+	return nil
+}
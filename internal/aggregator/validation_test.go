@@ -0,0 +1,248 @@
+package aggregator
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidate_Rules(t *testing.T) {
+	valid := rowValues{impressions: 100, clicks: 10, conversions: 2, spend: amount("5"), revenue: amount("9"), hasRevenue: true}
+	cases := []struct {
+		rule    string
+		row     func(v *rowValues)
+		reason  string
+		clamped func(v *rowValues)
+	}{
+		{
+			rule: "negative_impressions", row: func(v *rowValues) { v.impressions = -1; v.clicks = 0; v.conversions = 0 },
+			reason: "impressions -1 < 0", clamped: func(v *rowValues) { v.impressions = 0; v.clicks = 0; v.conversions = 0 },
+		},
+		{
+			rule: "negative_clicks", row: func(v *rowValues) { v.clicks = -3; v.conversions = 0 },
+			reason: "clicks -3 < 0", clamped: func(v *rowValues) { v.clicks = 0; v.conversions = 0 },
+		},
+		{
+			rule: "negative_conversions", row: func(v *rowValues) { v.conversions = -2 },
+			reason: "conversions -2 < 0", clamped: func(v *rowValues) { v.conversions = 0 },
+		},
+		{
+			rule: "negative_spend", row: func(v *rowValues) { v.spend = amount("-1.5") },
+			reason: "spend -1.5 < 0", clamped: func(v *rowValues) { v.spend = 0 },
+		},
+		{
+			rule: "negative_revenue", row: func(v *rowValues) { v.revenue = amount("-0.25") },
+			reason: "revenue -0.25 < 0", clamped: func(v *rowValues) { v.revenue = 0 },
+		},
+		{
+			rule: "clicks_exceed_impressions", row: func(v *rowValues) { v.clicks = 150 },
+			reason: "clicks 150 > impressions 100", clamped: func(v *rowValues) { v.clicks = 100 },
+		},
+		{
+			rule: "conversions_exceed_clicks", row: func(v *rowValues) { v.conversions = 12 },
+			reason: "conversions 12 > clicks 10", clamped: func(v *rowValues) { v.conversions = 10 },
+		},
+	}
+	if len(cases) != len(ValidationRules()) {
+		t.Fatalf("%d cases for %d rules", len(cases), len(ValidationRules()))
+	}
+	for _, tc := range cases {
+		for _, severity := range []Severity{SeverityReject, SeverityWarn, SeverityClamp, SeverityOff} {
+			t.Run(tc.rule+"/"+severity.String(), func(t *testing.T) {
+				v := valid
+				tc.row(&v)
+				broken := v
+				var stats ProcessStats
+				err := validate(only(tc.rule, severity).activeRules(), &v, &stats)
+
+				want := broken
+				wantCount := int64(1)
+				switch severity {
+				case SeverityReject:
+					wantErr := "rule " + tc.rule + ": " + tc.reason
+					if err == nil || err.Error() != wantErr {
+						t.Fatalf("got error %v, want %q", err, wantErr)
+					}
+				case SeverityClamp:
+					tc.clamped(&want)
+				case SeverityOff:
+					wantCount = 0
+				}
+				if severity != SeverityReject && err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if v != want {
+					t.Errorf("got values %+v, want %+v", v, want)
+				}
+				if got := stats.Violations[tc.rule]; got != wantCount {
+					t.Errorf("got %d violations, want %d", got, wantCount)
+				}
+			})
+		}
+	}
+}
+
+// only turns off every rule but rule.
+func only(rule string, severity Severity) Validation {
+	v := Validation{}
+	for _, name := range ValidationRules() {
+		v[name] = SeverityOff
+	}
+	v[rule] = severity
+	return v
+}
+
+func TestValidate_ValidRow(t *testing.T) {
+	v := rowValues{impressions: 10, clicks: 10, conversions: 10, spend: 0}
+	var stats ProcessStats
+	if err := validate(Validation{}.activeRules(), &v, &stats); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stats.Violations != nil {
+		t.Errorf("got violations %v, want none", stats.Violations)
+	}
+}
+
+func TestValidate_ClampBeforeCompare(t *testing.T) {
+	// Clamping negative impressions to 0 must make the later click check
+	// see the repaired value.
+	v := rowValues{impressions: -5, clicks: 3, conversions: 0}
+	var stats ProcessStats
+	rules := Validation{"negative_impressions": SeverityClamp, "clicks_exceed_impressions": SeverityClamp}.activeRules()
+	if err := validate(rules, &v, &stats); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v.impressions != 0 || v.clicks != 0 {
+		t.Errorf("got %+v, want impressions and clicks clamped to 0", v)
+	}
+	want := map[string]int64{"negative_impressions": 1, "clicks_exceed_impressions": 1}
+	if !reflect.DeepEqual(stats.Violations, want) {
+		t.Errorf("got violations %v, want %v", stats.Violations, want)
+	}
+}
+
+func TestValidation_Defaults(t *testing.T) {
+	want := map[string]Severity{
+		"negative_impressions":      SeverityReject,
+		"negative_clicks":           SeverityReject,
+		"negative_conversions":      SeverityReject,
+		"negative_spend":            SeverityWarn,
+		"negative_revenue":          SeverityWarn,
+		"clicks_exceed_impressions": SeverityReject,
+		"conversions_exceed_clicks": SeverityWarn,
+	}
+	for _, rule := range ValidationRules() {
+		if got := Validation(nil).Severity(rule); got != want[rule] {
+			t.Errorf("%s: got %s, want %s", rule, got, want[rule])
+		}
+	}
+}
+
+func TestParseSeverity(t *testing.T) {
+	for _, s := range []Severity{SeverityReject, SeverityWarn, SeverityClamp, SeverityOff} {
+		got, err := ParseSeverity(strings.ToUpper(s.String()))
+		if err != nil || got != s {
+			t.Errorf("%s: got %s, %v", s, got, err)
+		}
+	}
+	if _, err := ParseSeverity("drop"); err == nil {
+		t.Error("expected error for unknown severity")
+	}
+}
+
+func TestParseValidation(t *testing.T) {
+	got, err := ParseValidation("all=warn, negative_spend=reject,Conversions_Exceed_Clicks=clamp")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got) != len(ValidationRules()) {
+		t.Errorf("got %d rules, want %d", len(got), len(ValidationRules()))
+	}
+	for rule, want := range map[string]Severity{
+		"negative_impressions":      SeverityWarn,
+		"negative_spend":            SeverityReject,
+		"conversions_exceed_clicks": SeverityClamp,
+	} {
+		if got.Severity(rule) != want {
+			t.Errorf("%s: got %s, want %s", rule, got.Severity(rule), want)
+		}
+	}
+
+	for _, bad := range []string{"", "negative_spend", "=warn", "negative_spend=drop", "negative_cpa=warn"} {
+		if _, err := ParseValidation(bad); err == nil {
+			t.Errorf("%q: expected error", bad)
+		}
+	}
+}
+
+func TestCSVProcessor_Validation(t *testing.T) {
+	input := `campaign_id,impressions,clicks,spend,conversions
+camp1,1000,50,100.00,10
+camp1,-10,0,1.00,0
+camp1,100,200,1.00,0
+camp1,100,10,-5.00,20
+camp1,100,10,NaN,0
+camp1,100,10,Inf,0
+`
+	for _, workers := range []int{1, 3} {
+		var rejects strings.Builder
+		store := NewInMemoryMetricsStore()
+		p := NewCSVProcessor(
+			WithWorkers(workers),
+			WithErrorPolicy(ErrorPolicy{Mode: SkipOnError}),
+			WithRejects(NewCSVRejectWriter(&rejects)),
+		)
+		stats, err := p.Process(context.Background(), strings.NewReader(input), store)
+		if err != nil {
+			t.Fatalf("workers=%d: unexpected error: %v", workers, err)
+		}
+		if stats.RowsAccepted != 2 || stats.RowsRejected != 4 {
+			t.Errorf("workers=%d: got %+v, want 2 accepted and 4 rejected", workers, stats)
+		}
+		wantViolations := map[string]int64{
+			"negative_impressions":      1,
+			"clicks_exceed_impressions": 1,
+			"negative_spend":            1,
+			"conversions_exceed_clicks": 1,
+		}
+		if !reflect.DeepEqual(stats.Violations, wantViolations) {
+			t.Errorf("workers=%d: got violations %v, want %v", workers, stats.Violations, wantViolations)
+		}
+		m := findByCampaignID(store.TopKByCTR(1), "camp1")
+		if m == nil || m.TotalImpressions != 1100 || m.TotalSpend != amount("95") {
+			t.Errorf("workers=%d: got %v, want 1100 impressions and spend 95", workers, m)
+		}
+		want := `source,line,reason,record
+,3,rule negative_impressions: impressions -10 < 0,"camp1,-10,0,1.00,0"
+,4,rule clicks_exceed_impressions: clicks 200 > impressions 100,"camp1,100,200,1.00,0"
+,6,"bad spend ""NaN"": number format plain: no digits","camp1,100,10,NaN,0"
+,7,"bad spend ""Inf"": number format plain: no digits","camp1,100,10,Inf,0"
+`
+		if rejects.String() != want {
+			t.Errorf("workers=%d: rejects:\ngot:\n%s\nwant:\n%s", workers, rejects.String(), want)
+		}
+	}
+}
+
+func TestCSVProcessor_ValidationClamp(t *testing.T) {
+	input := `campaign_id,impressions,clicks,spend,conversions,revenue
+camp1,100,200,-1.00,0,-3.00
+`
+	store := NewInMemoryMetricsStore()
+	v, err := ParseValidation("all=clamp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats, err := NewCSVProcessor(WithValidation(v)).Process(context.Background(), strings.NewReader(input), store)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stats.RowsAccepted != 1 || len(stats.Violations) != 3 {
+		t.Errorf("got %+v, want 1 accepted row and 3 violations", stats)
+	}
+	m := findByCampaignID(store.TopKByCTR(1), "camp1")
+	if m == nil || m.TotalClicks != 100 || m.TotalSpend != 0 || m.TotalRevenue != 0 {
+		t.Errorf("got %v, want clicks clamped to 100 and spend and revenue to 0", m)
+	}
+}
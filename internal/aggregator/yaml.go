@@ -0,0 +1,438 @@
+package aggregator
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// decodeYAML decodes the YAML subset config files need into the values
+// encoding/json handles (map[string]any, []any, string, int64, float64,
+// bool and nil), so the result can be re-encoded as JSON and decoded into a
+// struct. It supports:
+//
+//   - block mappings and sequences, indented with spaces;
+//   - flow sequences and mappings ([a, b], {k: v});
+//   - plain, single- and double-quoted scalars, null/~, true/false and
+//     numbers;
+//   - comments and a leading --- document marker.
+//
+// Anchors, aliases, tags, multi-line scalars and multiple documents are
+// rejected.
+func decodeYAML(data []byte) (any, error) {
+	var lines []yamlLine
+	for i, text := range strings.Split(string(data), "\n") {
+		text = strings.TrimRight(stripYAMLComment(text), " \r")
+		content := strings.TrimLeft(text, " ")
+		if content == "" || (len(lines) == 0 && content == "---") {
+			continue
+		}
+		if strings.HasPrefix(content, "\t") {
+			return nil, fmt.Errorf("yaml line %d: tabs are not allowed in indentation", i+1)
+		}
+		lines = append(lines, yamlLine{num: i + 1, indent: len(text) - len(content), text: content})
+	}
+	if len(lines) == 0 {
+		return nil, nil
+	}
+	p := yamlParser{lines: lines}
+	v, err := p.block(lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.i < len(p.lines) {
+		l := p.lines[p.i]
+		return nil, p.errorf(l, "unexpected %q at this indentation", l.text)
+	}
+	return v, nil
+}
+
+type yamlLine struct {
+	num    int
+	indent int
+	text   string
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	i     int
+}
+
+func (p *yamlParser) errorf(l yamlLine, format string, args ...any) error {
+	return fmt.Errorf("yaml line %d: %s", l.num, fmt.Sprintf(format, args...))
+}
+
+// block parses the mapping or sequence starting at the current line,
+// whose entries are indented by indent.
+func (p *yamlParser) block(indent int) (any, error) {
+	if isSequenceItem(p.lines[p.i].text) {
+		return p.sequence(indent)
+	}
+	return p.mapping(indent)
+}
+
+func isSequenceItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+func (p *yamlParser) sequence(indent int) (any, error) {
+	items := []any{}
+	for p.i < len(p.lines) {
+		l := p.lines[p.i]
+		if l.indent != indent || !isSequenceItem(l.text) {
+			break
+		}
+		rest := strings.TrimLeft(strings.TrimPrefix(l.text, "-"), " ")
+		if rest == "" {
+			p.i++
+			v, err := p.nested(indent)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, v)
+			continue
+		}
+		if isSequenceItem(rest) || yamlKeyEnd(rest) >= 0 {
+			// "- key: value" or "- - item" opens a block whose entries
+			// are aligned with the text after the dash.
+			p.lines[p.i] = yamlLine{num: l.num, indent: l.indent + len(l.text) - len(rest), text: rest}
+			v, err := p.block(p.lines[p.i].indent)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, v)
+			continue
+		}
+		v, err := parseYAMLValue(rest)
+		if err != nil {
+			return nil, p.errorf(l, "%v", err)
+		}
+		items = append(items, v)
+		p.i++
+	}
+	return items, nil
+}
+
+func (p *yamlParser) mapping(indent int) (any, error) {
+	m := map[string]any{}
+	for p.i < len(p.lines) {
+		l := p.lines[p.i]
+		if l.indent != indent || isSequenceItem(l.text) {
+			break
+		}
+		end := yamlKeyEnd(l.text)
+		if end < 0 {
+			return nil, p.errorf(l, "expected \"key: value\"")
+		}
+		key, err := parseYAMLKey(l.text[:end])
+		if err != nil {
+			return nil, p.errorf(l, "%v", err)
+		}
+		if _, dup := m[key]; dup {
+			return nil, p.errorf(l, "duplicate key %q", key)
+		}
+		rest := strings.TrimSpace(l.text[end+1:])
+		p.i++
+		if rest == "" {
+			if m[key], err = p.nested(indent); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if m[key], err = parseYAMLValue(rest); err != nil {
+			return nil, p.errorf(l, "%v", err)
+		}
+	}
+	return m, nil
+}
+
+// nested parses the block value of a key or sequence item at indent:
+// a block indented further, a sequence at the same indent (allowed for
+// mapping values), or null when neither follows.
+func (p *yamlParser) nested(indent int) (any, error) {
+	if p.i == len(p.lines) {
+		return nil, nil
+	}
+	next := p.lines[p.i]
+	switch {
+	case next.indent > indent:
+		return p.block(next.indent)
+	case next.indent == indent && isSequenceItem(next.text) && p.i > 0 && !isSequenceItem(p.lines[p.i-1].text):
+		return p.sequence(indent)
+	}
+	return nil, nil
+}
+
+// yamlKeyEnd returns the index of the colon ending a mapping key in
+// text, or -1. The colon must be outside quotes and brackets and be
+// followed by a space or the end of the line.
+func yamlKeyEnd(text string) int {
+	if text == "" || text[0] == '[' || text[0] == '{' {
+		return -1
+	}
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case (c == '"' || c == '\'') && i == 0:
+			if i = yamlQuoteEnd(text, i) - 1; i < 0 {
+				return -1
+			}
+		case c == ':' && (i+1 == len(text) || text[i+1] == ' '):
+			return i
+		}
+	}
+	return -1
+}
+
+func parseYAMLKey(s string) (string, error) {
+	v, err := parseYAMLScalar(strings.TrimSpace(s))
+	if err != nil {
+		return "", err
+	}
+	if v == nil {
+		return "", fmt.Errorf("empty key")
+	}
+	return fmt.Sprint(v), nil
+}
+
+// parseYAMLValue parses a value written on one line: a flow collection
+// or a scalar.
+func parseYAMLValue(s string) (any, error) {
+	if s != "" && (s[0] == '[' || s[0] == '{') {
+		f := yamlFlow{s: s}
+		v, err := f.value()
+		if err != nil {
+			return nil, err
+		}
+		if f.skipSpace(); f.pos != len(s) {
+			return nil, fmt.Errorf("unexpected %q after flow collection", s[f.pos:])
+		}
+		return v, nil
+	}
+	return parseYAMLScalar(s)
+}
+
+func parseYAMLScalar(s string) (any, error) {
+	if s == "" {
+		return nil, nil
+	}
+	switch s[0] {
+	case '"':
+		if yamlQuoteEnd(s, 0) != len(s) {
+			return nil, fmt.Errorf("bad double-quoted string %s", s)
+		}
+		v, err := unescapeYAML(s[1 : len(s)-1])
+		if err != nil {
+			return nil, fmt.Errorf("bad double-quoted string %s: %w", s, err)
+		}
+		return v, nil
+	case '\'':
+		if yamlQuoteEnd(s, 0) != len(s) {
+			return nil, fmt.Errorf("bad single-quoted string %s", s)
+		}
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	case '&', '*', '!', '|', '>', '%', '@', '`':
+		return nil, fmt.Errorf("unsupported YAML syntax %q", s)
+	}
+	switch s {
+	case "null", "Null", "NULL", "~":
+		return nil, nil
+	case "true", "True", "TRUE":
+		return true, nil
+	case "false", "False", "FALSE":
+		return false, nil
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return n, nil
+	}
+	if c := s[0]; (isDigit(c) || c == '-' || c == '+' || c == '.') && !strings.ContainsAny(s, "xXpP_iInN") {
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f, nil
+		}
+	}
+	return s, nil
+}
+
+// yamlFlow parses a flow collection such as [a, "b"] or {k: v, l: [1]}.
+type yamlFlow struct {
+	s   string
+	pos int
+}
+
+func (f *yamlFlow) skipSpace() {
+	for f.pos < len(f.s) && f.s[f.pos] == ' ' {
+		f.pos++
+	}
+}
+
+func (f *yamlFlow) value() (any, error) {
+	f.skipSpace()
+	if f.pos == len(f.s) {
+		return nil, fmt.Errorf("unterminated flow collection %s", f.s)
+	}
+	switch f.s[f.pos] {
+	case '[':
+		f.pos++
+		items := []any{}
+		err := f.entries(']', func() error {
+			v, err := f.value()
+			items = append(items, v)
+			return err
+		})
+		return items, err
+	case '{':
+		f.pos++
+		m := map[string]any{}
+		err := f.entries('}', func() error {
+			k, err := f.scalar(true)
+			if err != nil {
+				return err
+			}
+			if f.skipSpace(); f.pos == len(f.s) || f.s[f.pos] != ':' {
+				return fmt.Errorf("expected ':' after key in %s", f.s)
+			}
+			f.pos++
+			key := fmt.Sprint(k)
+			if _, dup := m[key]; dup {
+				return fmt.Errorf("duplicate key %q", key)
+			}
+			m[key], err = f.value()
+			return err
+		})
+		return m, err
+	}
+	return f.scalar(false)
+}
+
+// entries parses comma-separated entries up to the closing bracket.
+func (f *yamlFlow) entries(closing byte, entry func() error) error {
+	if f.skipSpace(); f.pos < len(f.s) && f.s[f.pos] == closing {
+		f.pos++
+		return nil
+	}
+	for {
+		if err := entry(); err != nil {
+			return err
+		}
+		f.skipSpace()
+		if f.pos == len(f.s) {
+			return fmt.Errorf("unterminated flow collection %s", f.s)
+		}
+		switch f.s[f.pos] {
+		case ',':
+			f.pos++
+		case closing:
+			f.pos++
+			return nil
+		default:
+			return fmt.Errorf("unexpected %q in %s", f.s[f.pos], f.s)
+		}
+	}
+}
+
+// scalar parses a scalar inside a flow collection, which ends at a
+// comma or closing bracket, or for a key at a colon.
+func (f *yamlFlow) scalar(key bool) (any, error) {
+	f.skipSpace()
+	start := f.pos
+	if f.pos < len(f.s) && (f.s[f.pos] == '"' || f.s[f.pos] == '\'') {
+		if f.pos = yamlQuoteEnd(f.s, f.pos); f.pos < 0 {
+			f.pos = len(f.s)
+		}
+		return parseYAMLScalar(f.s[start:f.pos])
+	}
+	stops := ",]}"
+	if key {
+		stops += ":"
+	}
+	for f.pos < len(f.s) && !strings.ContainsRune(stops, rune(f.s[f.pos])) {
+		f.pos++
+	}
+	return parseYAMLScalar(strings.TrimSpace(f.s[start:f.pos]))
+}
+
+// stripYAMLComment removes a # comment: one at the start of the line or
+// preceded by a space, outside quotes.
+func stripYAMLComment(line string) string {
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '"' || c == '\'':
+			if i == 0 || strings.ContainsRune(" [{,:-", rune(line[i-1])) {
+				end := yamlQuoteEnd(line, i)
+				if end < 0 {
+					return line
+				}
+				i = end - 1
+			}
+		case c == '#' && (i == 0 || line[i-1] == ' '):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// yamlQuoteEnd returns the index just past the quoted string starting at
+// s[i], or -1 if it is not closed. Inside double quotes a backslash
+// escapes the next character; inside single quotes a doubled quote
+// stands for one.
+func yamlQuoteEnd(s string, i int) int {
+	quote := s[i]
+	for i++; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && quote == '"':
+			i++
+		case s[i] == quote && quote == '\'' && i+1 < len(s) && s[i+1] == '\'':
+			i++
+		case s[i] == quote:
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// yamlEscapes maps the single-character escapes of YAML double-quoted
+// strings to what they stand for.
+var yamlEscapes = map[byte]string{
+	'0': "\x00", 'a': "\a", 'b': "\b", 't': "\t", '\t': "\t", 'n': "\n",
+	'v': "\v", 'f': "\f", 'r': "\r", 'e': "\x1b", ' ': " ", '"': "\"",
+	'/': "/", '\\': "\\", 'N': "\u0085", '_': "\u00a0", 'L': "\u2028",
+	'P': "\u2029",
+}
+
+// unescapeYAML decodes the escapes of a YAML double-quoted string body:
+// those in yamlEscapes, and \xXX, \uXXXX and \UXXXXXXXX code points.
+func unescapeYAML(s string) (string, error) {
+	if !strings.Contains(s, "\\") {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i++; i == len(s) {
+			return "", fmt.Errorf("trailing backslash")
+		}
+		if r, ok := yamlEscapes[s[i]]; ok {
+			b.WriteString(r)
+			continue
+		}
+		digits := map[byte]int{'x': 2, 'u': 4, 'U': 8}[s[i]]
+		if digits == 0 {
+			return "", fmt.Errorf("unknown escape \\%c", s[i])
+		}
+		if i+digits >= len(s) {
+			return "", fmt.Errorf("short escape \\%s", s[i:])
+		}
+		n, err := strconv.ParseUint(s[i+1:i+1+digits], 16, 32)
+		if err != nil || !utf8.ValidRune(rune(n)) {
+			return "", fmt.Errorf("bad escape \\%s", s[i:i+1+digits])
+		}
+		b.WriteRune(rune(n))
+		i += digits
+	}
+	return b.String(), nil
+}
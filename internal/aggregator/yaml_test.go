@@ -0,0 +1,142 @@
+package aggregator
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeYAML(t *testing.T) {
+	src := `---
+# comment
+name: top # trailing comment
+count: 3
+ratio: -0.5
+on: true
+none: ~
+quoted: "a: #b"
+single: 'it''s'
+list:
+  - 1
+  - two
+same_indent:
+- x
+- y
+flow: [a, "b, c", {k: v}]
+map: {x: 1, y: [2]}
+items:
+  - name: first
+    tags: []
+  - name: second
+    nested:
+      deep: null
+  -
+    - inner
+`
+	want := map[string]any{
+		"name":        "top",
+		"count":       int64(3),
+		"ratio":       -0.5,
+		"on":          true,
+		"none":        nil,
+		"quoted":      "a: #b",
+		"single":      "it's",
+		"list":        []any{int64(1), "two"},
+		"same_indent": []any{"x", "y"},
+		"flow":        []any{"a", "b, c", map[string]any{"k": "v"}},
+		"map":         map[string]any{"x": int64(1), "y": []any{int64(2)}},
+		"items": []any{
+			map[string]any{"name": "first", "tags": []any{}},
+			map[string]any{"name": "second", "nested": map[string]any{"deep": nil}},
+			[]any{"inner"},
+		},
+	}
+	got, err := decodeYAML([]byte(src))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v\nwant %#v", got, want)
+	}
+}
+
+func TestDecodeYAML_Scalars(t *testing.T) {
+	for src, want := range map[string]any{
+		"v: inf":   "inf",
+		"v: nan":   "nan",
+		"v: 0x1F":  "0x1F",
+		"v: 1e3":   1000.0,
+		"v: +7":    int64(7),
+		"v: a b":   "a b",
+		"v: x:y":   "x:y",
+		`v: "\t"`:  "\t",
+		"v: 1_000": "1_000",
+	} {
+		got, err := decodeYAML([]byte(src))
+		if err != nil {
+			t.Errorf("%s: %v", src, err)
+			continue
+		}
+		if v := got.(map[string]any)["v"]; v != want {
+			t.Errorf("%s: got %#v, want %#v", src, v, want)
+		}
+	}
+}
+
+func TestDecodeYAML_Quoted(t *testing.T) {
+	for _, tc := range []struct {
+		src  string
+		key  string
+		want any
+	}{
+		{`v: "a \" # b"`, "v", `a " # b`},
+		{`v: "a \\" # b`, "v", `a \`},
+		{`v: 'it''s # x'`, "v", "it's # x"},
+		{`v: 'it''s' # x`, "v", "it's"},
+		{`v: ''''`, "v", "'"},
+		{`v: ""`, "v", ""},
+		{`v: "tab\there"`, "v", "tab\there"},
+		{`v: "\x41é\U0001F600"`, "v", "Aé\U0001F600"},
+		{`v: "\e[0m\0\/\ \N\_\L\P"`, "v", "\x1b[0m\x00/ \u0085\u00a0\u2028\u2029"},
+		{`v: [a, "b \" ] c", 'd''e']`, "v", []any{"a", `b " ] c`, "d'e"}},
+		{`"k: \"x": 1`, `k: "x`, int64(1)},
+		{`'k''s': 2`, "k's", int64(2)},
+	} {
+		got, err := decodeYAML([]byte(tc.src))
+		if err != nil {
+			t.Errorf("%s: %v", tc.src, err)
+			continue
+		}
+		if v := got.(map[string]any)[tc.key]; !reflect.DeepEqual(v, tc.want) {
+			t.Errorf("%s: got %#v, want %#v", tc.src, v, tc.want)
+		}
+	}
+}
+
+func TestDecodeYAML_Errors(t *testing.T) {
+	for src, want := range map[string]string{
+		"a: 1\n  b: 2":      `line 2: unexpected "b: 2" at this indentation`,
+		"a: 1\na: 2":        `line 2: duplicate key "a"`,
+		"a: 1\nplain":       `line 2: expected "key: value"`,
+		"a:\n\t- 1":         "line 2: tabs",
+		"a: &anchor 1":      "unsupported YAML syntax",
+		"a: |\n  text":      "unsupported YAML syntax",
+		"a: [1, 2":          "unterminated",
+		"a: {k 1}":          "expected ':'",
+		"a: [1] x":          "after flow collection",
+		`a: "open`:          "bad double-quoted string",
+		`a: 'open`:          "bad single-quoted string",
+		`a: "x" y`:          "bad double-quoted string",
+		`a: 'x' y`:          "bad single-quoted string",
+		`a: "\q"`:           `unknown escape \q`,
+		`a: "\x4"`:          "short escape",
+		`a: "\uD800"`:       "bad escape",
+		"- 1\nb: 2":         `line 2: unexpected "b: 2"`,
+		"a:\n  - 1\n  b: 2": `line 3: unexpected "b: 2"`,
+	} {
+		_, err := decodeYAML([]byte(src))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: got %v, want error containing %q", src, err, want)
+		}
+	}
+}
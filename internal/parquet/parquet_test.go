@@ -0,0 +1,189 @@
+package parquet
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWriteReadRoundTrip(t *testing.T) {
+	cols := []Column{
+		{Name: "campaign_id", Type: ByteArray, Values: []any{"CMP001", "", "CMP003"}},
+		{Name: "total_impressions", Type: Int64, Values: []any{int64(1000), int64(0), int64(math.MaxInt64)}},
+		{Name: "CTR", Type: Double, Values: []any{0.027522935779816515, 0.0, 1.0}},
+		{Name: "CPA", Type: Double, Optional: true, Values: []any{12.5, nil, 1.0 / 3}},
+	}
+	meta := []KeyValue{{Key: "report", Value: "top10_ctr"}}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, cols, meta); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	if !bytes.HasPrefix(data, []byte(magic)) || !bytes.HasSuffix(data, []byte(magic)) {
+		t.Fatal("missing PAR1 magic")
+	}
+
+	f, err := Read(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.NumRows != 3 {
+		t.Errorf("NumRows = %d, want 3", f.NumRows)
+	}
+	if !reflect.DeepEqual(f.Columns, cols) {
+		t.Errorf("columns mismatch:\n got %#v\nwant %#v", f.Columns, cols)
+	}
+	if v, ok := f.Lookup("report"); !ok || v != "top10_ctr" {
+		t.Errorf("metadata report = %q, %v", v, ok)
+	}
+}
+
+// golden is the file Write must produce for goldenColumns, annotated
+// field by field against parquet.thrift so that it can be checked
+// without this package's reader. Thrift compact field headers are
+// (id delta << 4 | type), and i32/i64 values are zigzag varints.
+var golden = []byte{
+	'P', 'A', 'R', '1',
+
+	// Column "id" at offset 4, 22 bytes. PageHeader: type DATA_PAGE,
+	// uncompressed and compressed size 5, DataPageHeader{num_values 1,
+	// encoding PLAIN, definition and repetition levels RLE}.
+	0x15, 0x00, 0x15, 0x0a, 0x15, 0x0a,
+	0x2c, 0x15, 0x02, 0x15, 0x00, 0x15, 0x06, 0x15, 0x06, 0x00, 0x00,
+	0x01, 0x00, 0x00, 0x00, 'x', // PLAIN BYTE_ARRAY: length, bytes
+
+	// Column "n" at offset 26, 25 bytes: page size 8.
+	0x15, 0x00, 0x15, 0x10, 0x15, 0x10,
+	0x2c, 0x15, 0x02, 0x15, 0x00, 0x15, 0x06, 0x15, 0x06, 0x00, 0x00,
+	0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // PLAIN INT64 1
+
+	// Column "r" at offset 51, 23 bytes: page size 6.
+	0x15, 0x00, 0x15, 0x0c, 0x15, 0x0c,
+	0x2c, 0x15, 0x02, 0x15, 0x00, 0x15, 0x06, 0x15, 0x06, 0x00, 0x00,
+	// Definition levels: 4-byte length, then one RLE run of 1 zero
+	// (header 1<<1, value 0). The null has no value bytes.
+	0x02, 0x00, 0x00, 0x00, 0x02, 0x00,
+
+	// FileMetaData.
+	0x15, 0x02, // 1: version 1
+	0x19, 0x4c, // 2: schema, list of 4 structs
+	0x48, 0x06, 's', 'c', 'h', 'e', 'm', 'a', 0x15, 0x06, 0x00, // root: name, num_children 3
+	0x15, 0x0c, 0x25, 0x00, 0x18, 0x02, 'i', 'd', // BYTE_ARRAY, REQUIRED, name
+	0x25, 0x00, 0x4c, 0x1c, 0x00, 0x00, 0x00, // converted_type UTF8, logicalType STRING
+	0x15, 0x04, 0x25, 0x00, 0x18, 0x01, 'n', 0x00, // INT64, REQUIRED
+	0x15, 0x0a, 0x25, 0x02, 0x18, 0x01, 'r', 0x00, // DOUBLE, OPTIONAL
+	0x16, 0x02, // 3: num_rows 1
+	0x19, 0x1c, // 4: row_groups, list of 1 struct
+	0x19, 0x3c, // 1: columns, list of 3 structs
+	// ColumnChunk{file_offset, meta_data: ColumnMetaData{type,
+	// encodings, path_in_schema, codec UNCOMPRESSED, num_values,
+	// total_uncompressed_size, total_compressed_size, data_page_offset}}.
+	0x26, 0x08, 0x1c, 0x15, 0x0c, 0x19, 0x15, 0x00, 0x19, 0x18, 0x02, 'i', 'd',
+	0x15, 0x00, 0x16, 0x02, 0x16, 0x2c, 0x16, 0x2c, 0x26, 0x08, 0x00, 0x00,
+	0x26, 0x34, 0x1c, 0x15, 0x04, 0x19, 0x15, 0x00, 0x19, 0x18, 0x01, 'n',
+	0x15, 0x00, 0x16, 0x02, 0x16, 0x32, 0x16, 0x32, 0x26, 0x34, 0x00, 0x00,
+	0x26, 0x66, 0x1c, 0x15, 0x0a, 0x19, 0x25, 0x00, 0x06, 0x19, 0x18, 0x01, 'r',
+	0x15, 0x00, 0x16, 0x02, 0x16, 0x2e, 0x16, 0x2e, 0x26, 0x66, 0x00, 0x00,
+	0x16, 0x8c, 0x01, // 2: total_byte_size 70
+	0x16, 0x02, // 3: num_rows 1
+	0x00,
+	0x19, 0x1c, 0x18, 0x01, 'k', 0x18, 0x01, 'v', 0x00, // 5: key_value_metadata
+	0x18, 0x19, // 6: created_by
+	'a', 'd', '-', 'p', 'e', 'r', 'f', 'o', 'r', 'm', 'a', 'n', 'c', 'e', '-',
+	'a', 'g', 'g', 'r', 'e', 'g', 'a', 't', 'o', 'r',
+	0x00,
+
+	0xa9, 0x00, 0x00, 0x00, // footer length 169
+	'P', 'A', 'R', '1',
+}
+
+var goldenColumns = []Column{
+	{Name: "id", Type: ByteArray, Values: []any{"x"}},
+	{Name: "n", Type: Int64, Values: []any{int64(1)}},
+	{Name: "r", Type: Double, Optional: true, Values: []any{nil}},
+}
+
+func TestWriteGolden(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, goldenColumns, []KeyValue{{Key: "k", Value: "v"}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.Bytes(); !bytes.Equal(got, golden) {
+		t.Errorf("got  % x\nwant % x", got, golden)
+	}
+}
+
+func TestWriteEmpty(t *testing.T) {
+	cols := []Column{
+		{Name: "campaign_id", Type: ByteArray},
+		{Name: "CPA", Type: Double, Optional: true},
+	}
+	var buf bytes.Buffer
+	if err := Write(&buf, cols, nil); err != nil {
+		t.Fatal(err)
+	}
+	f, err := Read(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.NumRows != 0 || len(f.Columns) != 2 {
+		t.Errorf("got %d rows, %d columns", f.NumRows, len(f.Columns))
+	}
+}
+
+func TestWriteManyRows(t *testing.T) {
+	// More than 15 schema elements and long runs exercise the extended list
+	// and varint headers.
+	var cols []Column
+	for i := 0; i < 20; i++ {
+		vals := make([]any, 300)
+		for j := range vals {
+			if j%7 != 0 {
+				vals[j] = float64(i*1000 + j)
+			}
+		}
+		cols = append(cols, Column{Name: strings.Repeat("c", i+1), Type: Double, Optional: true, Values: vals})
+	}
+	var buf bytes.Buffer
+	if err := Write(&buf, cols, nil); err != nil {
+		t.Fatal(err)
+	}
+	f, err := Read(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(f.Columns, cols) {
+		t.Error("columns mismatch after round trip")
+	}
+}
+
+func TestWriteRejectsBadValues(t *testing.T) {
+	cases := map[string][]Column{
+		"null in required": {{Name: "a", Type: Int64, Values: []any{nil}}},
+		"wrong go type":    {{Name: "a", Type: Int64, Values: []any{1.5}}},
+		"ragged columns": {
+			{Name: "a", Type: Int64, Values: []any{int64(1)}},
+			{Name: "b", Type: Int64, Values: []any{int64(1), int64(2)}},
+		},
+	}
+	for name, cols := range cases {
+		if err := Write(&bytes.Buffer{}, cols, nil); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestDecodeLevelsBitPacked(t *testing.T) {
+	// One bit-packed group of 8 values: 0b10110001.
+	out := make([]bool, 8)
+	if err := decodeLevels([]byte{0x03, 0xb1}, out); err != nil {
+		t.Fatal(err)
+	}
+	want := []bool{true, false, false, false, true, true, false, true}
+	if !reflect.DeepEqual(out, want) {
+		t.Errorf("got %v, want %v", out, want)
+	}
+}
@@ -0,0 +1,228 @@
+package parquet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// File is a decoded Parquet file: its columns with values, in schema
+// order, and its key/value metadata.
+type File struct {
+	NumRows  int64
+	Columns  []Column
+	Metadata []KeyValue
+}
+
+// Lookup returns the metadata value for key.
+func (f *File) Lookup(key string) (string, bool) {
+	for _, kv := range f.Metadata {
+		if kv.Key == key {
+			return kv.Value, true
+		}
+	}
+	return "", false
+}
+
+// Read decodes a flat Parquet file of size bytes. It supports what Write
+// produces (uncompressed PLAIN data pages v1 with at most one definition
+// level), which is enough to verify reports without a third-party reader.
+func Read(r io.ReaderAt, size int64) (*File, error) {
+	if size < 12 {
+		return nil, errors.New("parquet: file too small")
+	}
+	var tail [8]byte
+	if _, err := r.ReadAt(tail[:], size-8); err != nil {
+		return nil, err
+	}
+	if string(tail[4:]) != magic {
+		return nil, errors.New("parquet: missing trailing magic")
+	}
+	footerLen := int64(binary.LittleEndian.Uint32(tail[:4]))
+	if footerLen > size-12 {
+		return nil, errors.New("parquet: invalid footer length")
+	}
+	footer := make([]byte, footerLen)
+	if _, err := r.ReadAt(footer, size-8-footerLen); err != nil {
+		return nil, err
+	}
+	meta, err := (&compactReader{buf: footer}).readStruct()
+	if err != nil {
+		return nil, fmt.Errorf("parquet: file metadata: %w", err)
+	}
+
+	f := &File{NumRows: asInt(meta[3])}
+	schema := asList(meta[2])
+	if len(schema) == 0 {
+		return nil, errors.New("parquet: empty schema")
+	}
+	for _, e := range schema[1:] {
+		el := asStruct(e)
+		f.Columns = append(f.Columns, Column{
+			Name:     asString(el[4]),
+			Type:     Type(asInt(el[1])),
+			Optional: asInt(el[3]) == repetitionOptional,
+		})
+	}
+	for _, e := range asList(meta[5]) {
+		kv := asStruct(e)
+		f.Metadata = append(f.Metadata, KeyValue{Key: asString(kv[1]), Value: asString(kv[2])})
+	}
+
+	for _, g := range asList(meta[4]) {
+		chunks := asList(asStruct(g)[1])
+		if len(chunks) != len(f.Columns) {
+			return nil, fmt.Errorf("parquet: row group has %d columns, schema has %d", len(chunks), len(f.Columns))
+		}
+		for i, ch := range chunks {
+			cm := asStruct(asStruct(ch)[3])
+			if codec := asInt(cm[4]); codec != codecUncompressed {
+				return nil, fmt.Errorf("parquet: column %q: unsupported codec %d", f.Columns[i].Name, codec)
+			}
+			buf := make([]byte, asInt(cm[7]))
+			if _, err := r.ReadAt(buf, asInt(cm[9])); err != nil {
+				return nil, fmt.Errorf("parquet: column %q: %w", f.Columns[i].Name, err)
+			}
+			if err := readChunk(&f.Columns[i], buf, asInt(cm[5])); err != nil {
+				return nil, fmt.Errorf("parquet: column %q: %w", f.Columns[i].Name, err)
+			}
+		}
+	}
+	return f, nil
+}
+
+// readChunk appends the values of every data page in buf to c.
+func readChunk(c *Column, buf []byte, numValues int64) error {
+	for read := int64(0); read < numValues; {
+		cr := &compactReader{buf: buf}
+		header, err := cr.readStruct()
+		if err != nil {
+			return err
+		}
+		size := asInt(header[3])
+		if int64(len(buf)-cr.pos) < size {
+			return errTruncated
+		}
+		page := buf[cr.pos : cr.pos+int(size)]
+		buf = buf[cr.pos+int(size):]
+		if asInt(header[1]) != pageTypeData {
+			continue
+		}
+		dph := asStruct(header[5])
+		n := int(asInt(dph[1]))
+		if enc := asInt(dph[2]); enc != encodingPlain {
+			return fmt.Errorf("unsupported encoding %d", enc)
+		}
+
+		present := make([]bool, n)
+		if c.Optional {
+			if len(page) < 4 {
+				return errTruncated
+			}
+			l := binary.LittleEndian.Uint32(page)
+			if uint32(len(page)-4) < l {
+				return errTruncated
+			}
+			if err := decodeLevels(page[4:4+l], present); err != nil {
+				return err
+			}
+			page = page[4+l:]
+		} else {
+			for i := range present {
+				present[i] = true
+			}
+		}
+
+		for _, ok := range present {
+			if !ok {
+				c.Values = append(c.Values, nil)
+				continue
+			}
+			switch c.Type {
+			case Int64, Double:
+				if len(page) < 8 {
+					return errTruncated
+				}
+				bits := binary.LittleEndian.Uint64(page)
+				page = page[8:]
+				if c.Type == Int64 {
+					c.Values = append(c.Values, int64(bits))
+				} else {
+					c.Values = append(c.Values, math.Float64frombits(bits))
+				}
+			case ByteArray:
+				if len(page) < 4 {
+					return errTruncated
+				}
+				l := binary.LittleEndian.Uint32(page)
+				if uint32(len(page)-4) < l {
+					return errTruncated
+				}
+				c.Values = append(c.Values, string(page[4:4+l]))
+				page = page[4+l:]
+			default:
+				return fmt.Errorf("unsupported type %v", c.Type)
+			}
+		}
+		read += int64(n)
+	}
+	return nil
+}
+
+// decodeLevels decodes bit-width-1 levels from the RLE/bit-packing hybrid
+// encoding into out.
+func decodeLevels(buf []byte, out []bool) error {
+	for i := 0; i < len(out); {
+		h, n := binary.Uvarint(buf)
+		if n <= 0 {
+			return errTruncated
+		}
+		buf = buf[n:]
+		if h&1 == 0 {
+			if len(buf) < 1 {
+				return errTruncated
+			}
+			run, v := int(h>>1), buf[0] != 0
+			buf = buf[1:]
+			for ; run > 0 && i < len(out); run-- {
+				out[i] = v
+				i++
+			}
+			continue
+		}
+		groups := int(h >> 1)
+		if len(buf) < groups {
+			return errTruncated
+		}
+		for _, b := range buf[:groups] {
+			for bit := 0; bit < 8 && i < len(out); bit++ {
+				out[i] = b>>bit&1 == 1
+				i++
+			}
+		}
+		buf = buf[groups:]
+	}
+	return nil
+}
+
+func asStruct(v any) map[int16]any {
+	m, _ := v.(map[int16]any)
+	return m
+}
+
+func asList(v any) []any {
+	l, _ := v.([]any)
+	return l
+}
+
+func asInt(v any) int64 {
+	n, _ := v.(int64)
+	return n
+}
+
+func asString(v any) string {
+	b, _ := v.([]byte)
+	return string(b)
+}
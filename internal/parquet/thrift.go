@@ -0,0 +1,228 @@
+package parquet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Thrift compact protocol type codes, as used in field and list headers.
+const (
+	tStop   = 0
+	tTrue   = 1
+	tFalse  = 2
+	tByte   = 3
+	tI16    = 4
+	tI32    = 5
+	tI64    = 6
+	tDouble = 7
+	tBinary = 8
+	tList   = 9
+	tSet    = 10
+	tMap    = 11
+	tStruct = 12
+)
+
+// compactWriter encodes the subset of the Thrift compact protocol that
+// Parquet metadata needs: structs, lists, i32, i64 and binary fields.
+type compactWriter struct {
+	buf  []byte
+	last []int16 // last field id written, per open struct
+}
+
+func (w *compactWriter) structBegin() {
+	w.last = append(w.last, 0)
+}
+
+func (w *compactWriter) structEnd() {
+	w.buf = append(w.buf, tStop)
+	w.last = w.last[:len(w.last)-1]
+}
+
+func (w *compactWriter) fieldHeader(id int16, typ byte) {
+	top := len(w.last) - 1
+	if delta := id - w.last[top]; delta > 0 && delta <= 15 {
+		w.buf = append(w.buf, byte(delta)<<4|typ)
+	} else {
+		w.buf = append(w.buf, typ)
+		w.varint(zigzag(int64(id)))
+	}
+	w.last[top] = id
+}
+
+func (w *compactWriter) i32Field(id int16, v int32) {
+	w.fieldHeader(id, tI32)
+	w.varint(zigzag(int64(v)))
+}
+
+func (w *compactWriter) i64Field(id int16, v int64) {
+	w.fieldHeader(id, tI64)
+	w.varint(zigzag(v))
+}
+
+func (w *compactWriter) binaryField(id int16, s string) {
+	w.fieldHeader(id, tBinary)
+	w.binary(s)
+}
+
+func (w *compactWriter) structField(id int16) {
+	w.fieldHeader(id, tStruct)
+	w.structBegin()
+}
+
+func (w *compactWriter) listField(id int16, size int, elem byte) {
+	w.fieldHeader(id, tList)
+	w.listHeader(size, elem)
+}
+
+func (w *compactWriter) listHeader(size int, elem byte) {
+	if size < 15 {
+		w.buf = append(w.buf, byte(size)<<4|elem)
+		return
+	}
+	w.buf = append(w.buf, 0xf0|elem)
+	w.varint(uint64(size))
+}
+
+func (w *compactWriter) i32(v int32) {
+	w.varint(zigzag(int64(v)))
+}
+
+func (w *compactWriter) binary(s string) {
+	w.varint(uint64(len(s)))
+	w.buf = append(w.buf, s...)
+}
+
+func (w *compactWriter) varint(v uint64) {
+	w.buf = binary.AppendUvarint(w.buf, v)
+}
+
+func zigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
+
+// compactReader decodes Thrift compact structs into a generic tree:
+// structs become map[int16]any, lists []any, integers int64, binary
+// values []byte and booleans bool. It is only used to read back the
+// metadata written by this package.
+type compactReader struct {
+	buf []byte
+	pos int
+}
+
+var errTruncated = errors.New("parquet: truncated thrift data")
+
+func (r *compactReader) readStruct() (map[int16]any, error) {
+	fields := make(map[int16]any)
+	var last int16
+	for {
+		b, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		typ := b & 0x0f
+		if typ == tStop {
+			return fields, nil
+		}
+		id := last + int16(b>>4)
+		if b>>4 == 0 {
+			v, err := r.varint()
+			if err != nil {
+				return nil, err
+			}
+			id = int16(unzigzag(v))
+		}
+		last = id
+
+		var val any
+		switch typ {
+		case tTrue, tFalse:
+			val = typ == tTrue
+		default:
+			if val, err = r.readValue(typ); err != nil {
+				return nil, err
+			}
+		}
+		fields[id] = val
+	}
+}
+
+func (r *compactReader) readValue(typ byte) (any, error) {
+	switch typ {
+	case tByte:
+		b, err := r.byte()
+		return int64(int8(b)), err
+	case tI16, tI32, tI64:
+		v, err := r.varint()
+		return unzigzag(v), err
+	case tDouble:
+		if r.pos+8 > len(r.buf) {
+			return nil, errTruncated
+		}
+		v := binary.LittleEndian.Uint64(r.buf[r.pos:])
+		r.pos += 8
+		return v, nil
+	case tBinary:
+		n, err := r.varint()
+		if err != nil {
+			return nil, err
+		}
+		if uint64(len(r.buf)-r.pos) < n {
+			return nil, errTruncated
+		}
+		v := r.buf[r.pos : r.pos+int(n)]
+		r.pos += int(n)
+		return v, nil
+	case tList, tSet:
+		b, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		size, elem := uint64(b>>4), b&0x0f
+		if size == 15 {
+			if size, err = r.varint(); err != nil {
+				return nil, err
+			}
+		}
+		list := make([]any, 0, min(size, 1024))
+		for i := uint64(0); i < size; i++ {
+			var v any
+			if elem == tTrue || elem == tFalse {
+				b, err := r.byte()
+				if err != nil {
+					return nil, err
+				}
+				v = b == tTrue
+			} else if v, err = r.readValue(elem); err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		return list, nil
+	case tStruct:
+		return r.readStruct()
+	}
+	return nil, fmt.Errorf("parquet: unsupported thrift type %d", typ)
+}
+
+func (r *compactReader) byte() (byte, error) {
+	if r.pos >= len(r.buf) {
+		return 0, errTruncated
+	}
+	b := r.buf[r.pos]
+	r.pos++
+	return b, nil
+}
+
+func (r *compactReader) varint() (uint64, error) {
+	v, n := binary.Uvarint(r.buf[r.pos:])
+	if n <= 0 {
+		return 0, errTruncated
+	}
+	r.pos += n
+	return v, nil
+}
+
+func unzigzag(v uint64) int64 {
+	return int64(v>>1) ^ -int64(v&1)
+}
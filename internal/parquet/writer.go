@@ -0,0 +1,305 @@
+// Package parquet is a minimal, dependency-free Parquet encoder for flat
+// tables. It writes a single row group with one uncompressed, PLAIN-encoded
+// data page (v1) per column, which is all the report writers need.
+//
+// The subset is: a flat schema of REQUIRED or OPTIONAL columns of the
+// INT64, DOUBLE and BYTE_ARRAY (UTF-8 string) physical types, no
+// repetition levels, definition levels as RLE runs only, no statistics,
+// dictionaries, compression or page indexes. TestWriteGolden pins the
+// exact bytes of a small file, annotated field by field against
+// parquet.thrift; the reader in this package only decodes that subset.
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Type is a Parquet physical type.
+type Type int32
+
+// Physical types supported by this package.
+const (
+	Int64     Type = 2
+	Double    Type = 5
+	ByteArray Type = 6
+)
+
+func (t Type) String() string {
+	switch t {
+	case Int64:
+		return "INT64"
+	case Double:
+		return "DOUBLE"
+	case ByteArray:
+		return "BYTE_ARRAY"
+	default:
+		return fmt.Sprintf("Type(%d)", int32(t))
+	}
+}
+
+// Column describes one column of a flat schema together with its values.
+// Values holds one entry per row: int64 for Int64, float64 for Double and
+// string for ByteArray. A nil entry is a null and is only allowed in
+// Optional columns. ByteArray columns are annotated as UTF-8 strings.
+type Column struct {
+	Name     string
+	Type     Type
+	Optional bool
+	Values   []any
+}
+
+// KeyValue is an entry of the file's key/value metadata.
+type KeyValue struct {
+	Key   string
+	Value string
+}
+
+const magic = "PAR1"
+
+// Thrift enum values from parquet.thrift.
+const (
+	repetitionRequired = 0
+	repetitionOptional = 1
+	convertedUTF8      = 0
+	encodingPlain      = 0
+	encodingRLE        = 3
+	codecUncompressed  = 0
+	pageTypeData       = 0
+)
+
+// CreatedBy is recorded in the footer of every file written by Write.
+const CreatedBy = "ad-performance-aggregator"
+
+// Write encodes columns as a Parquet file to w. All columns must hold the
+// same number of values.
+func Write(w io.Writer, columns []Column, metadata []KeyValue) error {
+	numRows := 0
+	for i, c := range columns {
+		if i == 0 {
+			numRows = len(c.Values)
+		} else if len(c.Values) != numRows {
+			return fmt.Errorf("parquet: column %q has %d values, want %d", c.Name, len(c.Values), numRows)
+		}
+	}
+
+	cw := &countingWriter{w: w}
+	if _, err := io.WriteString(cw, magic); err != nil {
+		return err
+	}
+
+	chunks := make([]chunkMeta, len(columns))
+	for i, c := range columns {
+		data, err := encodePage(c)
+		if err != nil {
+			return err
+		}
+		header := pageHeader(len(c.Values), len(data))
+		chunks[i] = chunkMeta{
+			offset: cw.n,
+			size:   int64(len(header) + len(data)),
+		}
+		if _, err := cw.Write(header); err != nil {
+			return err
+		}
+		if _, err := cw.Write(data); err != nil {
+			return err
+		}
+	}
+
+	footer := fileMetaData(columns, chunks, int64(numRows), metadata)
+	if _, err := cw.Write(footer); err != nil {
+		return err
+	}
+	var tail [8]byte
+	binary.LittleEndian.PutUint32(tail[:4], uint32(len(footer)))
+	copy(tail[4:], magic)
+	_, err := cw.Write(tail[:])
+	return err
+}
+
+type chunkMeta struct {
+	offset int64
+	size   int64
+}
+
+// encodePage returns the body of a DataPage v1: definition levels for
+// optional columns followed by the PLAIN-encoded non-null values.
+func encodePage(c Column) ([]byte, error) {
+	var buf bytes.Buffer
+	if c.Optional {
+		buf.Write(definitionLevels(c.Values))
+	}
+	var scratch [8]byte
+	for i, v := range c.Values {
+		if v == nil {
+			if !c.Optional {
+				return nil, fmt.Errorf("parquet: column %q row %d: null in required column", c.Name, i)
+			}
+			continue
+		}
+		switch c.Type {
+		case Int64:
+			n, ok := v.(int64)
+			if !ok {
+				return nil, valueError(c, i, v)
+			}
+			binary.LittleEndian.PutUint64(scratch[:], uint64(n))
+			buf.Write(scratch[:])
+		case Double:
+			f, ok := v.(float64)
+			if !ok {
+				return nil, valueError(c, i, v)
+			}
+			binary.LittleEndian.PutUint64(scratch[:], math.Float64bits(f))
+			buf.Write(scratch[:])
+		case ByteArray:
+			s, ok := v.(string)
+			if !ok {
+				return nil, valueError(c, i, v)
+			}
+			binary.LittleEndian.PutUint32(scratch[:4], uint32(len(s)))
+			buf.Write(scratch[:4])
+			buf.WriteString(s)
+		default:
+			return nil, fmt.Errorf("parquet: column %q: unsupported type %v", c.Name, c.Type)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func valueError(c Column, row int, v any) error {
+	return fmt.Errorf("parquet: column %q row %d: %T value in %v column", c.Name, row, v, c.Type)
+}
+
+// definitionLevels encodes one level per value (1 = present, 0 = null)
+// with the RLE/bit-packing hybrid at bit width 1, using RLE runs only,
+// prefixed by the 4-byte length required by DataPage v1.
+func definitionLevels(values []any) []byte {
+	var runs []byte
+	for i := 0; i < len(values); {
+		present := values[i] != nil
+		j := i + 1
+		for j < len(values) && (values[j] != nil) == present {
+			j++
+		}
+		runs = binary.AppendUvarint(runs, uint64(j-i)<<1)
+		if present {
+			runs = append(runs, 1)
+		} else {
+			runs = append(runs, 0)
+		}
+		i = j
+	}
+	out := binary.LittleEndian.AppendUint32(nil, uint32(len(runs)))
+	return append(out, runs...)
+}
+
+func pageHeader(numValues, size int) []byte {
+	var w compactWriter
+	w.structBegin()
+	w.i32Field(1, pageTypeData)
+	w.i32Field(2, int32(size))
+	w.i32Field(3, int32(size))
+	w.structField(5)
+	w.i32Field(1, int32(numValues))
+	w.i32Field(2, encodingPlain)
+	w.i32Field(3, encodingRLE)
+	w.i32Field(4, encodingRLE)
+	w.structEnd()
+	w.structEnd()
+	return w.buf
+}
+
+func fileMetaData(columns []Column, chunks []chunkMeta, numRows int64, metadata []KeyValue) []byte {
+	var w compactWriter
+	w.structBegin()
+	w.i32Field(1, 1)
+
+	w.listField(2, len(columns)+1, tStruct)
+	w.structBegin()
+	w.binaryField(4, "schema")
+	w.i32Field(5, int32(len(columns)))
+	w.structEnd()
+	for _, c := range columns {
+		w.structBegin()
+		w.i32Field(1, int32(c.Type))
+		if c.Optional {
+			w.i32Field(3, repetitionOptional)
+		} else {
+			w.i32Field(3, repetitionRequired)
+		}
+		w.binaryField(4, c.Name)
+		if c.Type == ByteArray {
+			w.i32Field(6, convertedUTF8)
+			w.structField(10) // LogicalType
+			w.structField(1)  // STRING
+			w.structEnd()
+			w.structEnd()
+		}
+		w.structEnd()
+	}
+
+	w.i64Field(3, numRows)
+
+	var totalSize int64
+	for _, c := range chunks {
+		totalSize += c.size
+	}
+	w.listField(4, 1, tStruct)
+	w.structBegin()
+	w.listField(1, len(columns), tStruct)
+	for i, c := range columns {
+		w.structBegin()
+		w.i64Field(2, chunks[i].offset)
+		w.structField(3)
+		w.i32Field(1, int32(c.Type))
+		if c.Optional {
+			w.listField(2, 2, tI32)
+			w.i32(encodingPlain)
+			w.i32(encodingRLE)
+		} else {
+			w.listField(2, 1, tI32)
+			w.i32(encodingPlain)
+		}
+		w.listField(3, 1, tBinary)
+		w.binary(c.Name)
+		w.i32Field(4, codecUncompressed)
+		w.i64Field(5, int64(len(c.Values)))
+		w.i64Field(6, chunks[i].size)
+		w.i64Field(7, chunks[i].size)
+		w.i64Field(9, chunks[i].offset)
+		w.structEnd()
+		w.structEnd()
+	}
+	w.i64Field(2, totalSize)
+	w.i64Field(3, numRows)
+	w.structEnd()
+
+	if len(metadata) > 0 {
+		w.listField(5, len(metadata), tStruct)
+		for _, kv := range metadata {
+			w.structBegin()
+			w.binaryField(1, kv.Key)
+			w.binaryField(2, kv.Value)
+			w.structEnd()
+		}
+	}
+	w.binaryField(6, CreatedBy)
+	w.structEnd()
+	return w.buf
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}